@@ -0,0 +1,88 @@
+package content
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Admin serves the operational endpoints of the admin listener, which only the operators can reach:
+// the effective config, the model cache and the state of the upstream apps.
+type Admin struct {
+	reader  *ContentReader
+	outputs *OutputCache
+
+	mu     sync.RWMutex
+	config Config
+}
+
+func NewAdmin(cfg Config, reader *ContentReader, outputs *OutputCache) *Admin {
+	return &Admin{reader: reader, outputs: outputs, config: cfg}
+}
+
+// UpdateConfig keeps the config applied, without the changes which need a restart.
+func (a *Admin) UpdateConfig(cfg Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config = cfg
+}
+
+// ConfigHandler responds with the effective config as YAML, the secrets being replaced by their fingerprints.
+func (a *Admin) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	out, err := yaml.Marshal(a.config)
+	a.mu.RUnlock()
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, "Unable to marshal the config: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml; charset=UTF-8")
+	w.Write(out)
+}
+
+// CacheStatsHandler responds with the settings, size, hits, misses and evictions of the model cache.
+func (a *Admin) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, a.reader.cache.stats())
+}
+
+// CacheEntryHandler looks up the models of the UUID at the end of the path in the cache, or purges them
+// with the memoized outputs which expanded them.
+func (a *Admin) CacheEntryHandler(w http.ResponseWriter, r *http.Request) {
+	uuid := path.Base(r.URL.Path)
+	if err := validateUUID(uuid); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "Invalid UUID: "+uuid)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		purged := a.reader.cache.purge(uuid)
+		purgedOutputs := a.outputs.purge(uuid)
+		logger.log.WithField("uuid", uuid).Infof("Purged %d models and %d outputs from the cache", purged, purgedOutputs)
+		writeAdminJSON(w, http.StatusOK, map[string]int{"purged": purged, "purgedOutputs": purgedOutputs})
+		return
+	}
+	models := a.reader.cache.lookup(uuid)
+	if len(models) == 0 {
+		writeAdminError(w, http.StatusNotFound, "The UUID isn't cached")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, models)
+}
+
+// UpstreamsHandler responds with the hosts of the upstream apps, with the ones ejected, and their bulkheads.
+func (a *Admin) UpstreamsHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, a.reader.upstreamsState())
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, ErrorMessage{Message: msg})
+}
@@ -0,0 +1,91 @@
+package content
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const adminUUIDForTest = "22c0d426-1466-11e7-b0c1-37e417ee6c76"
+
+func adminForTest() *Admin {
+	cfg := defaultConfigForTest()
+	cfg.Cache = CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10}
+	return NewAdmin(cfg, NewContentReader(cfg.ReaderConfig(), http.DefaultClient), nil)
+}
+
+func TestAdmin_ConfigHandler_RedactsSecrets(t *testing.T) {
+	a := adminForTest()
+	cfg := defaultConfigForTest()
+	cfg.ContentStore.Auth = AuthConfig{Type: AuthAPIKey, APIKey: secretForTest}
+	cfg.InboundAuth = InboundAuthConfig{Keys: []ClientKey{{Client: "publisher", Key: "publisher-key"}}}
+	a.UpdateConfig(cfg)
+
+	w := httptest.NewRecorder()
+	a.ConfigHandler(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "host: http://localhost:8080")
+	assert.Contains(t, w.Body.String(), "apiKey: "+redacted)
+	assert.NotContains(t, w.Body.String(), secretForTest)
+	assert.NotContains(t, w.Body.String(), "publisher-key")
+}
+
+func TestAdmin_CacheHandlers(t *testing.T) {
+	a := adminForTest()
+	a.outputs = NewOutputCache(OutputCacheConfig{Enabled: true})
+	a.outputs.set(unrolledOutput{key: "article", uuids: map[string]bool{adminUUIDForTest: true}})
+	a.reader.cache.set(readerGet, adminUUIDForTest, Content{ID: "http://www.ft.com/thing/" + adminUUIDForTest})
+	a.reader.cache.get(readerGet, adminUUIDForTest)
+	a.reader.cache.get(readerGetInternal, adminUUIDForTest)
+
+	w := httptest.NewRecorder()
+	a.CacheStatsHandler(w, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	var stats cacheStats
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, cacheStats{Enabled: true, TTL: "1m0s", SoftTTL: "0s", MaxStale: "0s", MaxEntries: 10, Entries: 1, Hits: 1, Misses: 1}, stats)
+
+	w = httptest.NewRecorder()
+	a.CacheEntryHandler(w, httptest.NewRequest(http.MethodGet, "/cache/"+adminUUIDForTest, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var models []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &models))
+	if assert.Len(t, models, 1) {
+		assert.Equal(t, readerGet, models[0]["method"])
+	}
+
+	w = httptest.NewRecorder()
+	a.CacheEntryHandler(w, httptest.NewRequest(http.MethodDelete, "/cache/"+adminUUIDForTest, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"purged":1,"purgedOutputs":1}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	a.CacheEntryHandler(w, httptest.NewRequest(http.MethodGet, "/cache/"+adminUUIDForTest, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "The UUID should have been purged")
+
+	w = httptest.NewRecorder()
+	a.CacheEntryHandler(w, httptest.NewRequest(http.MethodGet, "/cache/not-a-uuid", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdmin_UpstreamsHandler(t *testing.T) {
+	a := adminForTest()
+	h := a.reader.storeHosts.pick(nil)
+	for i := 0; i < defaultEjectAfter; i++ {
+		a.reader.storeHosts.release(h, true)
+	}
+
+	w := httptest.NewRecorder()
+	a.UpstreamsHandler(w, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+	var upstreams []upstreamState
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &upstreams))
+	if assert.Len(t, upstreams, 2) {
+		assert.Equal(t, "content-source-app-name", upstreams[0].App)
+		assert.Len(t, upstreams[0].Hosts, 1)
+		assert.True(t, upstreams[0].Hosts[0].Ejected, "The failing host should be ejected")
+		assert.False(t, upstreams[1].Hosts[0].Ejected)
+	}
+}
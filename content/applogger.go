@@ -15,11 +15,12 @@ func NewAppLogger() *appLogger {
 	return &appLogger{log: log}
 }
 
-func (appLogger *appLogger) TransactionStartedEvent(requestURL string, transactionID string, uuid string) {
+func (appLogger *appLogger) TransactionStartedEvent(requestURL string, transactionID string, uuid string, clientID string) {
 	appLogger.log.WithFields(logrus.Fields{
 		"request_url":    requestURL,
 		"transaction_id": transactionID,
 		"uuid":           uuid,
+		"client_id":      clientID,
 	}).Infof("Transaction started %s", transactionID)
 }
 
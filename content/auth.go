@@ -0,0 +1,145 @@
+package content
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AuthNone   = ""
+	AuthAPIKey = "api-key"
+	AuthBasic  = "basic"
+	AuthBearer = "bearer"
+
+	defaultAPIKeyHeader = "X-Api-Key"
+	redacted            = "<redacted>"
+)
+
+// AuthConfig configures the credentials sent to an upstream app.
+type AuthConfig struct {
+	Type string `yaml:"type"`
+	// Header of the API key, X-Api-Key by default
+	Header   string `yaml:"header"`
+	APIKey   Secret `yaml:"apiKey"`
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+	// TokenFile has the bearer token, it is read again whenever it changes
+	TokenFile string `yaml:"tokenFile"`
+}
+
+func (a AuthConfig) validate(name string) error {
+	switch a.Type {
+	case AuthNone:
+	case AuthAPIKey:
+		if a.APIKey == "" {
+			return errors.Errorf("%s.auth.apiKey is required", name)
+		}
+	case AuthBasic:
+		if a.Username == "" {
+			return errors.Errorf("%s.auth.username is required", name)
+		}
+	case AuthBearer:
+		if a.TokenFile == "" {
+			return errors.Errorf("%s.auth.tokenFile is required", name)
+		}
+	default:
+		return errors.Errorf("unknown %s.auth.type %q, it should be one of: %s, %s or %s", name, a.Type, AuthAPIKey, AuthBasic, AuthBearer)
+	}
+	return nil
+}
+
+// authenticate adds the credentials to a request to the upstream app.
+func (a AuthConfig) authenticate(req *http.Request) error {
+	switch a.Type {
+	case AuthAPIKey:
+		header := a.Header
+		if header == "" {
+			header = defaultAPIKeyHeader
+		}
+		req.Header.Set(header, string(a.APIKey))
+	case AuthBasic:
+		req.SetBasicAuth(a.Username, string(a.Password))
+	case AuthBearer:
+		token, err := bearerTokens.read(a.TokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// Secret is a credential which is never printed or logged. It is shown as a fingerprint
+// in the config, so that a change can be seen without revealing it.
+type Secret string
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.fingerprint(), nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.fingerprint() + `"`), nil
+}
+
+// fingerprint is keyed with a random key, so that it can't be used to guess the secret.
+func (s Secret) fingerprint() string {
+	if s == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(s))
+	return fmt.Sprintf("%s %x", redacted, mac.Sum(nil)[:4])
+}
+
+var fingerprintKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// tokenFiles keeps the tokens read from files, reading a file again when it changes.
+type tokenFiles struct {
+	mu     sync.Mutex
+	tokens map[string]tokenFile
+}
+
+type tokenFile struct {
+	token string
+	stamp fileStamp
+}
+
+var bearerTokens = &tokenFiles{tokens: make(map[string]tokenFile)}
+
+func (f *tokenFiles) read(path string) (string, error) {
+	stamp, err := stampFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading the bearer token file")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cached, found := f.tokens[path]
+	if found && cached.stamp == stamp {
+		return cached.token, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "Error reading the bearer token file")
+	}
+	token := strings.TrimSpace(string(data))
+	f.tokens[path] = tokenFile{token: token, stamp: stamp}
+	return token, nil
+}
@@ -0,0 +1,223 @@
+package content
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	BalancingRoundRobin       = "round-robin"
+	BalancingLeastOutstanding = "least-outstanding"
+
+	defaultEjectAfter = 3
+	defaultEjectFor   = 30 * time.Second
+	// a failed upstream request is tried once more on another host
+	maxUpstreamAttempts = 2
+)
+
+// HostPoolConfig configures the hosts of an upstream app. The failover hosts, usually in
+// another region, are only used when none of the hosts is available.
+type HostPoolConfig struct {
+	Hosts         []string
+	FailoverHosts []string
+	Balancing     string
+	// EjectAfter consecutive failures, a host isn't used for EjectFor
+	EjectAfter int
+	EjectFor   time.Duration
+}
+
+type upstreamHost struct {
+	url          string
+	outstanding  int64
+	failures     int
+	ejectedUntil time.Time
+}
+
+// hostPool picks the host of each upstream request, and passively ejects the hosts which keep failing.
+type hostPool struct {
+	appName string
+	mu      sync.Mutex
+	config  HostPoolConfig
+	hosts   []*upstreamHost
+	backup  []*upstreamHost
+	next    uint32
+	now     func() time.Time
+}
+
+func newHostPool(appName string, cfg HostPoolConfig) *hostPool {
+	p := &hostPool{appName: appName, now: time.Now}
+	p.configure(cfg)
+	return p
+}
+
+// configure applies new hosts, keeping the state of the hosts which were already in the pool.
+func (p *hostPool) configure(cfg HostPoolConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cfg.EjectAfter <= 0 {
+		cfg.EjectAfter = defaultEjectAfter
+	}
+	if cfg.EjectFor <= 0 {
+		cfg.EjectFor = defaultEjectFor
+	}
+	existing := make(map[string]*upstreamHost)
+	for _, group := range [][]*upstreamHost{p.hosts, p.backup} {
+		for _, h := range group {
+			existing[h.url] = h
+		}
+	}
+	hosts := func(urls []string) []*upstreamHost {
+		var hosts []*upstreamHost
+		for _, url := range urls {
+			h, found := existing[url]
+			if !found {
+				h = &upstreamHost{url: url}
+			}
+			hosts = append(hosts, h)
+		}
+		return hosts
+	}
+	p.config = cfg
+	p.hosts = hosts(cfg.Hosts)
+	p.backup = hosts(cfg.FailoverHosts)
+}
+
+// pick returns an available host which hasn't been tried yet, a failover one if none of the hosts is
+// available, or an ejected one if no host is available at all. It returns nil once every host has been tried.
+func (p *hostPool) pick(tried []*upstreamHost) *upstreamHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	all := append(append([]*upstreamHost{}, p.hosts...), p.backup...)
+	for _, c := range [][]*upstreamHost{
+		candidates(p.hosts, tried, now, false),
+		candidates(p.backup, tried, now, false),
+		candidates(all, tried, now, true),
+	} {
+		if len(c) > 0 {
+			return p.balance(c)
+		}
+	}
+	return nil
+}
+
+func candidates(hosts []*upstreamHost, tried []*upstreamHost, now time.Time, ejectedToo bool) []*upstreamHost {
+	var c []*upstreamHost
+	for _, h := range hosts {
+		if (ejectedToo || !now.Before(h.ejectedUntil)) && !containsHost(tried, h) {
+			c = append(c, h)
+		}
+	}
+	return c
+}
+
+func containsHost(hosts []*upstreamHost, host *upstreamHost) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *hostPool) balance(candidates []*upstreamHost) *upstreamHost {
+	if p.config.Balancing == BalancingLeastOutstanding {
+		least := candidates[0]
+		for _, h := range candidates[1:] {
+			if atomic.LoadInt64(&h.outstanding) < atomic.LoadInt64(&least.outstanding) {
+				least = h
+			}
+		}
+		return least
+	}
+	p.next++
+	return candidates[int(p.next)%len(candidates)]
+}
+
+// release records the outcome of a request to the host, ejecting it after too many consecutive failures.
+func (p *hostPool) release(h *upstreamHost, failed bool) {
+	atomic.AddInt64(&h.outstanding, -1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !failed {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures < p.config.EjectAfter {
+		return
+	}
+	h.failures = 0
+	h.ejectedUntil = p.now().Add(p.config.EjectFor)
+	upstreamHostEjectionsTotal.WithLabelValues(p.appName, h.url).Inc()
+	logger.log.WithFields(logrus.Fields{"app": p.appName, "host": h.url}).
+		Warnf("Host ejected for %v after %d consecutive failures", p.config.EjectFor, p.config.EjectAfter)
+}
+
+// do calls the upstream on a host picked from the pool, and on another host if that host failed.
+// The call reports whether the host failed: a request cancelled by the caller, or which outlived the
+// deadline of the caller, isn't a failure of the host.
+func (p *hostPool) do(ctx context.Context, call func(host string) (hostFailed bool, err error)) error {
+	var tried []*upstreamHost
+	err := errors.Errorf("No host configured for %v", p.appName)
+	for attempt := 0; attempt < maxUpstreamAttempts; attempt++ {
+		h := p.pick(tried)
+		if h == nil {
+			break
+		}
+		tried = append(tried, h)
+		atomic.AddInt64(&h.outstanding, 1)
+		var failed bool
+		failed, err = call(h.url)
+		failed = failed && ctx.Err() == nil
+		p.release(h, failed)
+		if !failed {
+			return err
+		}
+	}
+	return err
+}
+
+// withSingleHost returns the config with the single host, unless a list of hosts is configured.
+func (cfg HostPoolConfig) withSingleHost(single string) HostPoolConfig {
+	if len(cfg.Hosts) == 0 && single != "" {
+		cfg.Hosts = []string{single}
+	}
+	return cfg
+}
+
+type hostState struct {
+	URL          string     `json:"url"`
+	Failover     bool       `json:"failover,omitempty"`
+	Outstanding  int64      `json:"outstanding"`
+	Failures     int        `json:"consecutiveFailures"`
+	Ejected      bool       `json:"ejected"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+}
+
+// state returns the hosts of the pool, with whether they are ejected.
+func (p *hostPool) state() []hostState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	hosts := []hostState{}
+	for _, group := range []struct {
+		hosts    []*upstreamHost
+		failover bool
+	}{{p.hosts, false}, {p.backup, true}} {
+		for _, h := range group.hosts {
+			s := hostState{URL: h.url, Failover: group.failover, Outstanding: atomic.LoadInt64(&h.outstanding), Failures: h.failures}
+			if now.Before(h.ejectedUntil) {
+				until := h.ejectedUntil
+				s.Ejected, s.EjectedUntil = true, &until
+			}
+			hosts = append(hosts, s)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,126 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostPool_RoundRobin(t *testing.T) {
+	p := newHostPool("app", HostPoolConfig{Hosts: []string{"a", "b", "c"}})
+	picked := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		picked[p.pick(nil).url]++
+	}
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, picked)
+}
+
+func TestHostPool_LeastOutstanding(t *testing.T) {
+	p := newHostPool("app", HostPoolConfig{Hosts: []string{"a", "b"}, Balancing: BalancingLeastOutstanding})
+	a := p.pick(nil)
+	atomic.AddInt64(&a.outstanding, 1)
+	assert.NotEqual(t, a.url, p.pick(nil).url, "The host without outstanding requests should be picked")
+}
+
+func TestHostPool_EjectsFailingHostsAndFailsOver(t *testing.T) {
+	now := time.Now()
+	p := newHostPool("app", HostPoolConfig{Hosts: []string{"a"}, FailoverHosts: []string{"b"}, EjectAfter: 2, EjectFor: time.Minute})
+	p.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		h := p.pick(nil)
+		assert.Equal(t, "a", h.url)
+		p.release(h, true)
+	}
+	assert.Equal(t, "b", p.pick(nil).url, "The failover host should be used once the host is ejected")
+
+	p.release(p.pick(nil), true)
+	p.release(p.pick(nil), true)
+	assert.NotNil(t, p.pick(nil), "An ejected host should still be tried when every host is ejected")
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "a", p.pick(nil).url, "The host should be used again once the ejection is over")
+}
+
+func TestHostPool_DoTriesAnotherHost(t *testing.T) {
+	p := newHostPool("app", HostPoolConfig{Hosts: []string{"a", "b"}})
+	var called []string
+	err := p.do(context.Background(), func(host string) (bool, error) {
+		called = append(called, host)
+		if len(called) == 1 {
+			return true, errors.New("unavailable")
+		}
+		return false, nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, called, 2)
+	assert.NotEqual(t, called[0], called[1])
+}
+
+func TestHostPool_DoIgnoresTheDeadlineOfTheCaller(t *testing.T) {
+	p := newHostPool("app", HostPoolConfig{Hosts: []string{"a", "b"}, EjectAfter: 1, EjectFor: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	var called []string
+	err := p.do(ctx, func(host string) (bool, error) {
+		called = append(called, host)
+		<-ctx.Done()
+		return true, ctx.Err()
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Len(t, called, 1, "Another host shouldn't be tried once the caller ran out of time")
+	for _, h := range p.state() {
+		assert.False(t, h.Ejected, "%s shouldn't be ejected for the deadline of the caller", h.URL)
+		assert.Zero(t, h.Failures)
+	}
+}
+
+func TestGet_FailsOverToAnotherHost(t *testing.T) {
+	failing := errorContentServerMock(t, http.StatusServiceUnavailable)
+	defer failing.Close()
+	healthy := successfulContentServerMock(t, "../test-resources/source-content-valid-response.json")
+	defer healthy.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHosts:   HostPoolConfig{Hosts: []string{failing.URL}, FailoverHosts: []string{healthy.URL}},
+	}, http.DefaultClient)
+	for i := 0; i < 5; i++ {
+		actual, err := cr.Get(context.Background(), testData, "tid_1")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, actual)
+	}
+}
+
+func TestServiceConfig_CheckReportsEachHost(t *testing.T) {
+	healthy := startFunctionalService()
+	defer healthy.Close()
+	unhealthy := startNotFunctionalService()
+	defer unhealthy.Close()
+
+	sc := initTestServiceConfig("", "")
+	sc.ContentStoreAppHealthURIs = []string{healthy.URL, unhealthy.URL}
+	out, err := sc.ContentStoreCheck().Checker()
+	assert.NoError(t, err, "The app should be available as long as one of its hosts is")
+	assert.Contains(t, out, "1 of 2")
+	assert.Contains(t, out, healthy.URL+": Ok")
+	assert.Contains(t, out, unhealthy.URL+": content-source-app service is not responding with OK. Status=502")
+
+	sc.ContentStoreAppHealthURIs = []string{unhealthy.URL, unhealthy.URL}
+	_, err = sc.ContentStoreCheck().Checker()
+	assert.Error(t, err)
+	assert.False(t, sc.GtgCheck().GoodToGo)
+}
+
+func TestHostPool_ConfigureKeepsHostState(t *testing.T) {
+	p := newHostPool("app", HostPoolConfig{Hosts: []string{"a"}, EjectAfter: 1})
+	p.release(p.pick(nil), true)
+	p.configure(HostPoolConfig{Hosts: []string{"a", "b"}, EjectAfter: 1})
+	assert.Equal(t, "b", p.pick(nil).url, "The host ejected before the reload should stay ejected")
+}
@@ -0,0 +1,155 @@
+package content
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBulkheadQueueTimeout = time.Second
+
+	bulkheadQueueFull    = "queue-full"
+	bulkheadQueueTimeout = "queue-timeout"
+)
+
+// BulkheadConfig limits the requests to an upstream app made concurrently by all the unroll requests.
+// The requests over the limit wait in a queue, and fail when it is full or they have waited for too long.
+type BulkheadConfig struct {
+	// MaxConcurrent is the limit of the requests in flight, 0 is no limit
+	MaxConcurrent int           `yaml:"maxConcurrent" json:"maxConcurrent"`
+	MaxQueued     int           `yaml:"maxQueued" json:"maxQueued"`
+	QueueTimeout  time.Duration `yaml:"queueTimeout" json:"queueTimeout"`
+	// MaxPerRequest limits the requests made concurrently for one unroll request, e.g. for a gallery, 0 is no limit
+	MaxPerRequest int `yaml:"maxPerRequest" json:"maxPerRequest"`
+}
+
+func (c BulkheadConfig) validate(name string) error {
+	if c.MaxConcurrent < 0 || c.MaxQueued < 0 || c.QueueTimeout < 0 || c.MaxPerRequest < 0 {
+		return errors.Errorf("%s.bulkhead settings can't be negative", name)
+	}
+	return nil
+}
+
+// bulkhead lets up to MaxConcurrent requests to an upstream app through, in the order they arrive.
+type bulkhead struct {
+	appName string
+
+	mu       sync.Mutex
+	config   BulkheadConfig
+	inFlight int
+	// queue has a channel per waiting request, closed when it is given a slot
+	queue []chan struct{}
+}
+
+func newBulkhead(appName string, cfg BulkheadConfig) *bulkhead {
+	return &bulkhead{appName: appName, config: cfg}
+}
+
+// configure applies a new limit, giving their slots to the waiting requests if it is higher.
+func (b *bulkhead) configure(cfg BulkheadConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config = cfg
+	for len(b.queue) > 0 && b.hasSlot() {
+		b.inFlight++
+		b.dequeue()
+	}
+	b.observe()
+}
+
+func (b *bulkhead) hasSlot() bool {
+	return b.config.MaxConcurrent == 0 || b.inFlight < b.config.MaxConcurrent
+}
+
+// acquire waits for a slot, which must then be released.
+func (b *bulkhead) acquire(ctx context.Context) error {
+	b.mu.Lock()
+	if len(b.queue) == 0 && b.hasSlot() {
+		b.inFlight++
+		b.observe()
+		b.mu.Unlock()
+		return nil
+	}
+	if len(b.queue) >= b.config.MaxQueued {
+		b.mu.Unlock()
+		bulkheadRejectionsTotal.WithLabelValues(b.appName, bulkheadQueueFull).Inc()
+		return errors.Errorf("Too many requests to %v in flight", b.appName)
+	}
+	ready := make(chan struct{})
+	b.queue = append(b.queue, ready)
+	timeout := b.config.QueueTimeout
+	if timeout == 0 {
+		timeout = defaultBulkheadQueueTimeout
+	}
+	b.observe()
+	b.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		if b.leaveQueue(ready) {
+			bulkheadRejectionsTotal.WithLabelValues(b.appName, bulkheadQueueTimeout).Inc()
+			return errors.Errorf("Timed out waiting for a request to %v after %v", b.appName, timeout)
+		}
+	case <-ctx.Done():
+		if b.leaveQueue(ready) {
+			return ctx.Err()
+		}
+	}
+	// the slot was given just as the wait ended
+	return nil
+}
+
+// leaveQueue removes a waiting request from the queue, unless it has been given a slot.
+func (b *bulkhead) leaveQueue(ready chan struct{}) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, q := range b.queue {
+		if q == ready {
+			b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			b.observe()
+			return true
+		}
+	}
+	return false
+}
+
+// release gives the slot to the first waiting request, unless the limit has been lowered below the requests in flight.
+func (b *bulkhead) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queue) > 0 && (b.config.MaxConcurrent == 0 || b.inFlight <= b.config.MaxConcurrent) {
+		b.dequeue()
+	} else {
+		b.inFlight--
+	}
+	b.observe()
+}
+
+func (b *bulkhead) dequeue() {
+	close(b.queue[0])
+	b.queue = b.queue[1:]
+}
+
+func (b *bulkhead) observe() {
+	upstreamInFlightRequests.WithLabelValues(b.appName).Set(float64(b.inFlight))
+	upstreamQueuedRequests.WithLabelValues(b.appName).Set(float64(len(b.queue)))
+}
+
+type bulkheadState struct {
+	Config   BulkheadConfig `json:"config"`
+	InFlight int            `json:"inFlight"`
+	Queued   int            `json:"queued"`
+}
+
+func (b *bulkhead) state() bulkheadState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bulkheadState{Config: b.config, InFlight: b.inFlight, Queued: len(b.queue)}
+}
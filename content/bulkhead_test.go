@@ -0,0 +1,112 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkhead_QueuesRequestsOverTheLimit(t *testing.T) {
+	b := newBulkhead("bulkhead-test-app", BulkheadConfig{MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: 50 * time.Millisecond})
+	queueFull := bulkheadRejectionsTotal.WithLabelValues("bulkhead-test-app", bulkheadQueueFull)
+	queueTimeout := bulkheadRejectionsTotal.WithLabelValues("bulkhead-test-app", bulkheadQueueTimeout)
+	beforeFull, beforeTimeout := testutil.ToFloat64(queueFull), testutil.ToFloat64(queueTimeout)
+
+	assert.NoError(t, b.acquire(context.Background()))
+	assert.Error(t, b.acquire(context.Background()), "The request should time out in the queue")
+	assert.Equal(t, beforeTimeout+1, testutil.ToFloat64(queueTimeout))
+
+	acquired := make(chan error)
+	go func() { acquired <- b.acquire(context.Background()) }()
+	for testutil.ToFloat64(upstreamQueuedRequests.WithLabelValues("bulkhead-test-app")) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Error(t, b.acquire(context.Background()), "The request should be rejected when the queue is full")
+	assert.Equal(t, beforeFull+1, testutil.ToFloat64(queueFull))
+
+	b.release()
+	assert.NoError(t, <-acquired, "The slot should be given to the waiting request")
+	assert.Equal(t, 1.0, testutil.ToFloat64(upstreamInFlightRequests.WithLabelValues("bulkhead-test-app")))
+	b.release()
+	assert.Equal(t, 0.0, testutil.ToFloat64(upstreamInFlightRequests.WithLabelValues("bulkhead-test-app")))
+}
+
+func TestBulkhead_ConfigureAndCancel(t *testing.T) {
+	b := newBulkhead("bulkhead-test-app", BulkheadConfig{MaxConcurrent: 1, MaxQueued: 5, QueueTimeout: time.Minute})
+	assert.NoError(t, b.acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() { cancelled <- b.acquire(ctx) }()
+	acquired := make(chan error)
+	go func() {
+		for testutil.ToFloat64(upstreamQueuedRequests.WithLabelValues("bulkhead-test-app")) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		acquired <- b.acquire(context.Background())
+	}()
+	for testutil.ToFloat64(upstreamQueuedRequests.WithLabelValues("bulkhead-test-app")) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-cancelled)
+	b.configure(BulkheadConfig{MaxConcurrent: 2})
+	assert.NoError(t, <-acquired, "A higher limit should let the waiting requests through")
+	b.release()
+	b.release()
+	assert.Equal(t, 0, b.inFlight)
+}
+
+func TestGetPreview_BulkheadLimitsConcurrentRequests(t *testing.T) {
+	var inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		uuid := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		w.Write([]byte(`{"id":"http://www.ft.com/thing/` + uuid + `"}`))
+	}))
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentPreviewAppName:  "content-preview-app-name",
+		ContentPreviewHost:     ts.URL,
+		ContentPreviewBulkhead: BulkheadConfig{MaxConcurrent: 3, MaxQueued: 100, QueueTimeout: time.Minute, MaxPerRequest: 2},
+	}, http.DefaultClient)
+
+	uuids := []string{
+		"d02886fc-58ff-11e8-9859-6668838a4c10", "d02886fc-58ff-11e8-9859-6668838a4c11", "d02886fc-58ff-11e8-9859-6668838a4c12",
+		"d02886fc-58ff-11e8-9859-6668838a4c13", "d02886fc-58ff-11e8-9859-6668838a4c14", "d02886fc-58ff-11e8-9859-6668838a4c15",
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cm, err := cr.GetPreview(context.Background(), uuids, "tid_1")
+			assert.NoError(t, err)
+			assert.Len(t, cm, len(uuids))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), maxInFlight, "The requests of all the unroll requests should share the bulkhead")
+
+	maxInFlight = 0
+	cr.GetPreview(context.Background(), uuids, "tid_1")
+	assert.Equal(t, int32(2), maxInFlight, "One unroll request should only use its share of the bulkhead")
+}
@@ -0,0 +1,249 @@
+package content
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// cachedReaderMethods are the reader methods whose models are cached, the preview models never are.
+var cachedReaderMethods = []string{readerGet, readerGetInternal}
+
+// modelCache keeps the models read from the content store for the configured TTL,
+// evicting the least recently used ones when it is full. The models are never
+// modified once decoded, so they are shared between requests.
+// The models past their soft TTL are served while they are read again in the background,
+// and the expired ones are kept for the max staleness, to be served when the content store fails.
+type modelCache struct {
+	mu      sync.Mutex
+	config  CacheConfig
+	entries map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+	// the counters are since the service started, revalidations are the upstream responses which didn't change
+	hits, misses, evictions, revalidations uint64
+}
+
+type cacheEntry struct {
+	key     string
+	content Content
+	stored  time.Time
+	// refreshing is set while the model is read again in the background
+	refreshing bool
+}
+
+// cacheLookup is a model found in the cache, with its age.
+type cacheLookup struct {
+	content Content
+	age     time.Duration
+	// stale is past the soft TTL, and refresh tells the only caller which should read it again
+	stale   bool
+	refresh bool
+}
+
+func newModelCache(cfg CacheConfig) *modelCache {
+	return &modelCache{
+		config:  cfg,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+type modelCacheBypassKey struct{}
+
+// withoutModelCache makes the reads for the context go to the upstream apps, e.g. for the self-test to
+// prove they respond. The models read are still cached.
+func withoutModelCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, modelCacheBypassKey{}, true)
+}
+
+func bypassesModelCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(modelCacheBypassKey{}).(bool)
+	return bypass
+}
+
+func cacheKey(method string, uuid string) string {
+	return method + "/" + uuid
+}
+
+// get returns the model if it hasn't expired.
+func (c *modelCache) get(method string, uuid string) (cacheLookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.config.Enabled {
+		return cacheLookup{}, false
+	}
+	e, found := c.entries[cacheKey(method, uuid)]
+	if !found {
+		c.misses++
+		return cacheLookup{}, false
+	}
+	entry := e.Value.(*cacheEntry)
+	age := c.now().Sub(entry.stored)
+	if age >= c.config.TTL {
+		c.misses++
+		if age >= c.config.TTL+c.config.MaxStale {
+			c.remove(e)
+		}
+		return cacheLookup{}, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+
+	l := cacheLookup{content: entry.content, age: age}
+	if c.config.SoftTTL > 0 && age >= c.config.SoftTTL {
+		l.stale = true
+		l.refresh = !entry.refreshing
+		entry.refreshing = true
+	}
+	return l, true
+}
+
+// getStale returns the model if it has expired, but is still within the max staleness.
+func (c *modelCache) getStale(method string, uuid string) (cacheLookup, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.config.Enabled {
+		return cacheLookup{}, false
+	}
+	e, found := c.entries[cacheKey(method, uuid)]
+	if !found {
+		return cacheLookup{}, false
+	}
+	entry := e.Value.(*cacheEntry)
+	age := c.now().Sub(entry.stored)
+	if age >= c.config.TTL+c.config.MaxStale {
+		return cacheLookup{}, false
+	}
+	return cacheLookup{content: entry.content, age: age, stale: true}, true
+}
+
+// refreshDone lets the models which couldn't be read again be refreshed by a following request.
+func (c *modelCache) refreshDone(method string, uuids []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, uuid := range uuids {
+		if e, found := c.entries[cacheKey(method, uuid)]; found {
+			e.Value.(*cacheEntry).refreshing = false
+		}
+	}
+}
+
+func (c *modelCache) set(method string, uuid string, content Content) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.config.Enabled {
+		return
+	}
+	key := cacheKey(method, uuid)
+	if e, found := c.entries[key]; found {
+		c.remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, content: content, stored: c.now()})
+	c.evict()
+}
+
+// configure applies new settings: the TTLs apply to the models already cached too,
+// and disabling the cache empties it.
+func (c *modelCache) configure(cfg CacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = cfg
+	if !cfg.Enabled {
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		return
+	}
+	c.evict()
+}
+
+func (c *modelCache) evict() {
+	for c.lru.Len() > c.config.MaxEntries {
+		c.evictions++
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *modelCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+type cacheStats struct {
+	Enabled    bool   `json:"enabled"`
+	TTL        string `json:"ttl"`
+	SoftTTL    string `json:"softTTL"`
+	MaxStale   string `json:"maxStale"`
+	Revalidate bool   `json:"revalidate"`
+	MaxEntries int    `json:"maxEntries"`
+	Entries    int    `json:"entries"`
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"`
+	Evictions  uint64 `json:"evictions"`
+	// Revalidations are the conditional upstream requests which got a not modified response
+	Revalidations uint64 `json:"revalidations"`
+}
+
+func (c *modelCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{
+		Enabled:    c.config.Enabled,
+		TTL:        c.config.TTL.String(),
+		SoftTTL:    c.config.SoftTTL.String(),
+		MaxStale:   c.config.MaxStale.String(),
+		Revalidate: c.config.Revalidate,
+		MaxEntries: c.config.MaxEntries,
+		Entries:    c.lru.Len(),
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+
+		Revalidations: c.revalidations,
+	}
+}
+
+// revalidated counts a response of an upstream app which didn't change since it was last read.
+func (c *modelCache) revalidated() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revalidations++
+}
+
+// cachedModel is a model cached for a reader method.
+type cachedModel struct {
+	Method  string    `json:"method"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+	Content Content   `json:"content"`
+}
+
+// lookup returns the models of the uuid cached for every reader method, including the expired ones which are still kept.
+func (c *modelCache) lookup(uuid string) []cachedModel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	models := []cachedModel{}
+	for _, method := range cachedReaderMethods {
+		if e, found := c.entries[cacheKey(method, uuid)]; found {
+			entry := e.Value.(*cacheEntry)
+			models = append(models, cachedModel{Method: method, Stored: entry.stored, Expires: entry.stored.Add(c.config.TTL), Content: entry.content})
+		}
+	}
+	return models
+}
+
+// purge removes the models of the uuid cached for every reader method, returning how many there were.
+func (c *modelCache) purge(uuid string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := 0
+	for _, method := range cachedReaderMethods {
+		if e, found := c.entries[cacheKey(method, uuid)]; found {
+			c.remove(e)
+			purged++
+		}
+	}
+	return purged
+}
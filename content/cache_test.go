@@ -0,0 +1,72 @@
+package content
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelCache_EntriesExpireAfterTTL(t *testing.T) {
+	now := time.Now()
+	c := newModelCache(CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10})
+	c.now = func() time.Time { return now }
+	c.set(readerGet, "uuid-1", Content{ID: "http://www.ft.com/thing/uuid-1"})
+
+	now = now.Add(30 * time.Second)
+	cached, found := c.get(readerGet, "uuid-1")
+	assert.True(t, found)
+	assert.Equal(t, "http://www.ft.com/thing/uuid-1", cached.content.ID)
+	_, found = c.get(readerGetInternal, "uuid-1")
+	assert.False(t, found, "The models read from different endpoints should be cached separately")
+
+	c.configure(CacheConfig{Enabled: true, TTL: 10 * time.Second, MaxEntries: 10})
+	_, found = c.get(readerGet, "uuid-1")
+	assert.False(t, found, "A shorter TTL should apply to the models already cached")
+}
+
+func TestModelCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := newModelCache(CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 2})
+	c.set(readerGet, "uuid-1", Content{})
+	c.set(readerGet, "uuid-2", Content{})
+	c.get(readerGet, "uuid-1")
+	c.set(readerGet, "uuid-3", Content{})
+
+	_, found := c.get(readerGet, "uuid-2")
+	assert.False(t, found)
+	_, found = c.get(readerGet, "uuid-1")
+	assert.True(t, found)
+
+	c.configure(CacheConfig{Enabled: false, TTL: time.Minute, MaxEntries: 2})
+	c.set(readerGet, "uuid-4", Content{})
+	assert.Equal(t, 0, c.lru.Len(), "A disabled cache should be empty")
+}
+
+func TestModelCache_StaleEntries(t *testing.T) {
+	now := time.Now()
+	c := newModelCache(CacheConfig{Enabled: true, TTL: time.Minute, SoftTTL: 30 * time.Second, MaxStale: time.Minute, MaxEntries: 10})
+	c.now = func() time.Time { return now }
+	c.set(readerGet, "uuid-1", Content{ID: "http://www.ft.com/thing/uuid-1"})
+
+	now = now.Add(40 * time.Second)
+	cached, found := c.get(readerGet, "uuid-1")
+	assert.True(t, found)
+	assert.True(t, cached.stale)
+	assert.True(t, cached.refresh, "The first request past the soft TTL should refresh the model")
+	cached, _ = c.get(readerGet, "uuid-1")
+	assert.False(t, cached.refresh, "The model is already being refreshed")
+	c.refreshDone(readerGet, []string{"uuid-1"})
+	cached, _ = c.get(readerGet, "uuid-1")
+	assert.True(t, cached.refresh, "A failed refresh should be retried")
+
+	now = now.Add(40 * time.Second)
+	_, found = c.get(readerGet, "uuid-1")
+	assert.False(t, found, "An expired model is a miss")
+	cached, found = c.getStale(readerGet, "uuid-1")
+	assert.True(t, found, "An expired model should be kept for the max staleness")
+	assert.Equal(t, 80*time.Second, cached.age)
+
+	now = now.Add(time.Minute)
+	_, found = c.getStale(readerGet, "uuid-1")
+	assert.False(t, found)
+}
@@ -0,0 +1,520 @@
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// maxCanonicalDepth is the nesting depth of the JSON written canonically, as encoding/json limits it.
+const maxCanonicalDepth = 10000
+
+// canonicalJSON writes the JSON with the keys of its objects sorted and without whitespace, so that
+// the same article posted with another layout has the same key. The numbers are kept as they were written.
+func canonicalJSON(b []byte) ([]byte, error) {
+	if !json.Valid(b) {
+		return nil, errors.New("Invalid JSON")
+	}
+	return appendCanonical(make([]byte, 0, len(b)), b)
+}
+
+// appendCanonical appends the JSON value b to dst without whitespace, with the keys of its objects sorted
+// and its strings escaped as encoding/json does without HTML escaping. A duplicate key keeps its last
+// value, as when it's decoded. The values which are already canonical are copied without decoding them.
+func appendCanonical(dst []byte, b []byte) ([]byte, error) {
+	s := canonicalScanner{b: b}
+	dst, err := s.value(dst, 0)
+	if err != nil {
+		return nil, err
+	}
+	if s.skipSpace(); s.i != len(b) {
+		return nil, errors.Errorf("Unexpected %q after the JSON value", b[s.i])
+	}
+	return dst, nil
+}
+
+type canonicalScanner struct {
+	b []byte
+	i int
+	// sorting holds the members of the objects being sorted, the nested ones last
+	sorting []canonicalMember
+}
+
+type canonicalMember struct {
+	key        []byte
+	start, end int
+}
+
+func (s *canonicalScanner) skipSpace() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+// next skips the whitespace and returns the following byte, 0 at the end of the JSON.
+func (s *canonicalScanner) next() byte {
+	if s.skipSpace(); s.i < len(s.b) {
+		return s.b[s.i]
+	}
+	return 0
+}
+
+func (s *canonicalScanner) value(dst []byte, depth int) ([]byte, error) {
+	if depth > maxCanonicalDepth {
+		return nil, errors.New("The JSON is nested too deeply")
+	}
+	switch c := s.next(); c {
+	case '{':
+		return s.object(dst, depth)
+	case '[':
+		return s.array(dst, depth)
+	case '"':
+		raw, err := s.str()
+		if err != nil {
+			return nil, err
+		}
+		return appendCanonicalString(dst, raw)
+	case 0:
+		return nil, errors.New("Unexpected end of the JSON")
+	default:
+		// numbers and literals are written as they are
+		start := s.i
+		for s.i < len(s.b) && strings.IndexByte(" \t\n\r,:[]{}\"", s.b[s.i]) < 0 {
+			s.i++
+		}
+		if s.i == start {
+			return nil, errors.Errorf("Unexpected %q in the JSON", c)
+		}
+		return append(dst, s.b[start:s.i]...), nil
+	}
+}
+
+// str returns the string at the current position with its quotes.
+func (s *canonicalScanner) str() ([]byte, error) {
+	start := s.i
+	for i := start + 1; ; i++ {
+		n := bytes.IndexByte(s.b[i:], '"')
+		if n < 0 {
+			return nil, errors.New("Unterminated string in the JSON")
+		}
+		i += n
+		// the quote is escaped when it follows an odd number of backslashes
+		escaped := false
+		for j := i - 1; j > start && s.b[j] == '\\'; j-- {
+			escaped = !escaped
+		}
+		if !escaped {
+			s.i = i + 1
+			return s.b[start:s.i], nil
+		}
+	}
+}
+
+func (s *canonicalScanner) array(dst []byte, depth int) ([]byte, error) {
+	s.i++
+	dst = append(dst, '[')
+	if s.next() == ']' {
+		s.i++
+		return append(dst, ']'), nil
+	}
+	for {
+		var err error
+		if dst, err = s.value(dst, depth+1); err != nil {
+			return nil, err
+		}
+		switch s.next() {
+		case ',':
+			s.i++
+			dst = append(dst, ',')
+		case ']':
+			s.i++
+			return append(dst, ']'), nil
+		default:
+			return nil, errors.New("Expected ',' or ']' in the JSON array")
+		}
+	}
+}
+
+// object writes the members in the order they come while their keys are sorted, and sorts them only
+// when they aren't.
+func (s *canonicalScanner) object(dst []byte, depth int) ([]byte, error) {
+	begin, objStart := s.i, len(dst)
+	var prev []byte
+	sorted := true
+	dst, err := s.members(dst, depth, func(key []byte, _ []byte, _, _ int) bool {
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			sorted = false
+			return false
+		}
+		prev = key
+		return true
+	})
+	if err != nil || sorted {
+		return dst, err
+	}
+
+	// the members are written again, then copied back sorted
+	s.i, dst = begin, dst[:objStart]
+	if s.sorting == nil {
+		s.sorting = make([]canonicalMember, 0, 32)
+	}
+	base := len(s.sorting)
+	dst, err = s.members(dst, depth, func(key []byte, dst []byte, start, _ int) bool {
+		s.sorting = append(s.sorting, canonicalMember{key, start - objStart, len(dst) - objStart})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	members := s.sorting[base:]
+	slices.SortStableFunc(members, func(a, b canonicalMember) int { return bytes.Compare(a.key, b.key) })
+	written := append([]byte(nil), dst[objStart:]...)
+	dst = append(dst[:objStart], '{')
+	for i, m := range members {
+		if i+1 < len(members) && bytes.Equal(m.key, members[i+1].key) {
+			continue
+		}
+		if len(dst) > objStart+1 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, written[m.start:m.end]...)
+	}
+	s.sorting = s.sorting[:base]
+	return append(dst, '}'), nil
+}
+
+// members writes the members of the object at the current position to dst, separated by commas and
+// with their braces, calling member with the key and dst once each one is written, from start, its
+// value from value. They stop being written when member returns false.
+func (s *canonicalScanner) members(dst []byte, depth int, member func(key []byte, dst []byte, start, value int) bool) ([]byte, error) {
+	s.i++
+	dst = append(dst, '{')
+	if s.next() == '}' {
+		s.i++
+		return append(dst, '}'), nil
+	}
+	for {
+		if s.next() != '"' {
+			return nil, errors.New("Expected a key in the JSON object")
+		}
+		raw, err := s.str()
+		if err != nil {
+			return nil, err
+		}
+		key, err := decodeKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		start := len(dst)
+		if dst, err = appendCanonicalString(dst, raw); err != nil {
+			return nil, err
+		}
+		if s.next() != ':' {
+			return nil, errors.New("Expected ':' after the key in the JSON object")
+		}
+		s.i++
+		dst = append(dst, ':')
+		value := len(dst)
+		if dst, err = s.value(dst, depth+1); err != nil {
+			return nil, err
+		}
+		if !member(key, dst, start, value) {
+			return dst, nil
+		}
+		switch s.next() {
+		case ',':
+			s.i++
+			dst = append(dst, ',')
+		case '}':
+			s.i++
+			return append(dst, '}'), nil
+		default:
+			return nil, errors.New("Expected ',' or '}' in the JSON object")
+		}
+	}
+}
+
+// decodeKey returns the key of a member as it's compared, without decoding it when it has no escapes.
+func decodeKey(raw []byte) ([]byte, error) {
+	inner := raw[1 : len(raw)-1]
+	if bytes.IndexByte(inner, '\\') < 0 {
+		return inner, nil
+	}
+	key, err := unquote(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// unquote decodes the JSON string raw, itself unless it has surrogate pairs or invalid UTF-8, which are
+// left to encoding/json.
+func unquote(raw []byte) (string, error) {
+	inner := raw[1 : len(raw)-1]
+	if !utf8.Valid(inner) {
+		return unquoteDecoded(raw)
+	}
+	var sb strings.Builder
+	sb.Grow(len(inner))
+	for i := 0; i < len(inner); {
+		if inner[i] != '\\' {
+			n := bytes.IndexByte(inner[i:], '\\')
+			if n < 0 {
+				n = len(inner) - i
+			}
+			sb.Write(inner[i : i+n])
+			i += n
+			continue
+		}
+		n, r := escapeAt(inner[i:])
+		if n == 0 || utf16.IsSurrogate(r) {
+			return unquoteDecoded(raw)
+		}
+		sb.WriteRune(r)
+		i += n
+	}
+	return sb.String(), nil
+}
+
+func unquoteDecoded(raw []byte) (string, error) {
+	var str string
+	err := json.Unmarshal(raw, &str)
+	return str, err
+}
+
+// appendCanonicalString appends the string with its quotes, escaped again only when encoding/json
+// wouldn't write it the same.
+func appendCanonicalString(dst []byte, raw []byte) ([]byte, error) {
+	if isCanonicalString(raw[1 : len(raw)-1]) {
+		return append(dst, raw...), nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(str); err != nil {
+		return nil, err
+	}
+	return append(dst, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...), nil
+}
+
+// stringEscapes are the escapes encoding/json writes without HTML escaping, by the character escaped.
+var stringEscapes = func() map[rune]string {
+	escapes := make(map[rune]string)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for r := rune(0); r <= 0x2029; r++ {
+		if r == utf8.RuneSelf {
+			r = 0x2028
+		}
+		buf.Reset()
+		enc.Encode(string(r))
+		if escaped := bytes.TrimSuffix(buf.Bytes(), []byte("\n")); escaped[1] == '\\' {
+			escapes[r] = string(escaped[1 : len(escaped)-1])
+		}
+	}
+	return escapes
+}()
+
+// isCanonicalString tells whether the inside of a JSON string is written as encoding/json writes it:
+// valid UTF-8, only escaping what it escapes, the way it escapes it.
+func isCanonicalString(inner []byte) bool {
+	for i := 0; i < len(inner); {
+		switch c := inner[i]; {
+		case c == '\\':
+			n, r := escapeAt(inner[i:])
+			if n == 0 || stringEscapes[r] != string(inner[i:i+n]) {
+				return false
+			}
+			i += n
+		case c < 0x20:
+			return false
+		case c < utf8.RuneSelf:
+			i++
+		default:
+			r, size := utf8.DecodeRune(inner[i:])
+			if r == utf8.RuneError && size == 1 || r == 0x2028 || r == 0x2029 {
+				return false
+			}
+			i += size
+		}
+	}
+	return true
+}
+
+// escapeAt returns the length of the escape at the start of b and the character it escapes, 0 when
+// it isn't a valid escape.
+func escapeAt(b []byte) (int, rune) {
+	if len(b) < 2 {
+		return 0, 0
+	}
+	switch b[1] {
+	case '"', '\\', '/':
+		return 2, rune(b[1])
+	case 'b':
+		return 2, '\b'
+	case 'f':
+		return 2, '\f'
+	case 'n':
+		return 2, '\n'
+	case 'r':
+		return 2, '\r'
+	case 't':
+		return 2, '\t'
+	case 'u':
+		if len(b) < 6 {
+			return 0, 0
+		}
+		r, err := strconv.ParseUint(string(b[2:6]), 16, 16)
+		if err != nil {
+			return 0, 0
+		}
+		return 6, rune(r)
+	}
+	return 0, 0
+}
+
+// appendCanonicalMembers appends the members of the JSON object b to dst as appendCanonical does, but
+// in the order they come, calling member with the key and the value written of each one.
+func appendCanonicalMembers(dst []byte, b []byte, member func(key []byte, value []byte)) ([]byte, error) {
+	s := canonicalScanner{b: b}
+	if s.next() != '{' {
+		return nil, errors.New("Expected a JSON object")
+	}
+	dst, err := s.members(dst, 0, func(key []byte, dst []byte, _, value int) bool {
+		member(key, dst[value:len(dst):len(dst)])
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.skipSpace(); s.i != len(b) {
+		return nil, errors.Errorf("Unexpected %q after the JSON value", b[s.i])
+	}
+	return dst, nil
+}
+
+// eachMember calls f with the key and the value of each member of the canonical JSON object b. The
+// values are slices of b, as they are already canonical.
+func eachMember(b []byte, f func(key []byte, value []byte) error) error {
+	s := canonicalScanner{b: b, i: 1}
+	if s.next() == '}' {
+		return nil
+	}
+	for {
+		if s.next() != '"' {
+			return errors.New("Expected a key in the JSON object")
+		}
+		raw, err := s.str()
+		if err != nil {
+			return err
+		}
+		key, err := decodeKey(raw)
+		if err != nil {
+			return err
+		}
+		if s.next() != ':' {
+			return errors.New("Expected ':' after the key in the JSON object")
+		}
+		s.i++
+		value, err := s.skip()
+		if err != nil {
+			return err
+		}
+		if err := f(key, value); err != nil {
+			return err
+		}
+		switch s.next() {
+		case ',':
+			s.i++
+		case '}':
+			return nil
+		default:
+			return errors.New("Expected ',' or '}' in the JSON object")
+		}
+	}
+}
+
+// eachElement calls f with each element of the canonical JSON array b, as a slice of b.
+func eachElement(b []byte, f func(value []byte) error) error {
+	s := canonicalScanner{b: b, i: 1}
+	if s.next() == ']' {
+		return nil
+	}
+	for {
+		value, err := s.skip()
+		if err != nil {
+			return err
+		}
+		if err := f(value); err != nil {
+			return err
+		}
+		switch s.next() {
+		case ',':
+			s.i++
+		case ']':
+			return nil
+		default:
+			return errors.New("Expected ',' or ']' in the JSON array")
+		}
+	}
+}
+
+// skip returns the value at the current position without writing it.
+func (s *canonicalScanner) skip() ([]byte, error) {
+	c := s.next()
+	start := s.i
+	switch c {
+	case '"':
+		if _, err := s.str(); err != nil {
+			return nil, err
+		}
+	case '{', '[':
+		for depth := 0; ; {
+			if s.i == len(s.b) {
+				return nil, errors.New("Unexpected end of the JSON")
+			}
+			switch s.b[s.i] {
+			case '"':
+				if _, err := s.str(); err != nil {
+					return nil, err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			s.i++
+			if depth == 0 {
+				break
+			}
+		}
+	case 0:
+		return nil, errors.New("Unexpected end of the JSON")
+	default:
+		for s.i < len(s.b) && strings.IndexByte(" \t\n\r,:[]{}\"", s.b[s.i]) < 0 {
+			s.i++
+		}
+		if s.i == start {
+			return nil, errors.Errorf("Unexpected %q in the JSON", c)
+		}
+	}
+	return s.b[start:s.i:s.i], nil
+}
@@ -0,0 +1,81 @@
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	a, err := canonicalJSON([]byte(`{"b": [3, 1.50, {"y": 1, "x": "<p>"}], "a": null}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":null,"b":[3,1.50,{"x":"<p>","y":1}]}`, string(a), "The keys should be sorted, the numbers and the HTML kept")
+
+	b, err := canonicalJSON([]byte("{\n  \"a\": null,\n  \"b\": [3, 1.50, {\"x\": \"\\u003cp>\", \"y\": 1}]\n}"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(a), string(b))
+	assert.Equal(t, outputETag(a), outputETag(b))
+
+	_, err = canonicalJSON([]byte(`{"a":`))
+	assert.Error(t, err)
+}
+
+// decodedCanonicalJSON is canonicalJSON written with encoding/json, which decodes the whole document.
+func decodedCanonicalJSON(t *testing.T, b []byte) string {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	assert.NoError(t, dec.Decode(&v))
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	assert.NoError(t, enc.Encode(v))
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+func TestCanonicalJSON_IsWrittenAsEncodingJSONDoes(t *testing.T) {
+	for _, doc := range []string{
+		`"plain"`,
+		`[]`,
+		`{}`,
+		` { "b" : { "d" : [ ] , "c" : { } } , "a" : -1.5e+3 } `,
+		`{"a":1,"b":2,"a":3}`,
+		`{"z":{"y":{"x":[{"w":true,"v":false}]}},"é":"é","e":null}`,
+		`{"b":1,"a":2}`,
+		`["\b\f\r\u001f\u007f", "\u2028\u2029", "\u00e9", "\ud83d\ude00", "\u0041", "\"\\\n", "\u000A"]`,
+		`["\/", "\t\n", "\u0001", "   ", "😀", "😀", "<&>", "\"\\"]`,
+		"[\" \"]",
+	} {
+		actual, err := canonicalJSON([]byte(doc))
+		if assert.NoError(t, err, doc) {
+			assert.Equal(t, decodedCanonicalJSON(t, []byte(doc)), string(actual), doc)
+		}
+	}
+}
+
+func TestCanonicalJSON_InvalidJSON(t *testing.T) {
+	for _, doc := range []string{``, `{`, `{"a"}`, `[1,]`, `{"a":1}x`, `"unterminated`} {
+		_, err := canonicalJSON([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+}
+
+func TestUnquote_DecodesAsEncodingJSONDoes(t *testing.T) {
+	for _, raw := range []string{
+		`""`,
+		`"plain"`,
+		`"\"\\\/\b\f\n\r\t"`,
+		`"\u003cp> \u00e9 \u2028"`,
+		`"\ud83d\ude00 \ud83d"`,
+		"\"\xff\"",
+	} {
+		var expected string
+		assert.NoError(t, json.Unmarshal([]byte(raw), &expected), raw)
+		actual, err := unquote([]byte(raw))
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, expected, actual, raw)
+		}
+	}
+}
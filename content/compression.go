@@ -0,0 +1,263 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultCompressionMinSize = 1024
+
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+
+	compressionResponse = "response"
+	compressionUpstream = "upstream"
+)
+
+// CompressionConfig configures the compression of the unrolled responses. The compressed request
+// bodies are always accepted, and the upstream responses are always requested compressed.
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the size of the smallest response compressed, in bytes
+	MinSize int `yaml:"minSize"`
+}
+
+// withDefaults returns the config with the defaults of the settings which aren't set.
+func (c CompressionConfig) withDefaults() CompressionConfig {
+	if c.MinSize == 0 {
+		c.MinSize = defaultCompressionMinSize
+	}
+	return c
+}
+
+func (c CompressionConfig) validate() error {
+	if c.MinSize < 0 {
+		return errors.New("compression.minSize can't be negative")
+	}
+	return nil
+}
+
+// Compressor decompresses the gzipped bodies of the unroll requests, and compresses their responses
+// with the encoding the client prefers, gzip or brotli.
+type Compressor struct {
+	mu     sync.RWMutex
+	config CompressionConfig
+	// limits bounds the decompressed bodies, so that a small gzip bomb isn't decompressed in full
+	limits *UnrollLimits
+}
+
+func NewCompressor(cfg CompressionConfig, limits *UnrollLimits) *Compressor {
+	return &Compressor{config: cfg.withDefaults(), limits: limits}
+}
+
+func (c *Compressor) UpdateConfig(cfg CompressionConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = cfg.withDefaults()
+}
+
+func (c *Compressor) currentConfig() CompressionConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.config
+}
+
+// Handler is a middleware compressing the unroll endpoints. The responses are buffered, to only
+// compress the ones of at least the min size.
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/")
+		if !contains(unrollEndpoints, endpoint) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case encodingGzip:
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeErrorMessage(w, http.StatusBadRequest, "Invalid gzip request body: "+err.Error())
+				return
+			}
+			defer gz.Close()
+			// one byte past the max size is enough to reject the body
+			r.Body = gzipBody{Reader: io.LimitReader(gz, c.limits.currentConfig().MaxBodySize+1), gz: gz}
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		default:
+			writeErrorMessage(w, http.StatusUnsupportedMediaType, "Unsupported request Content-Encoding: "+encoding)
+			return
+		}
+
+		cfg := c.currentConfig()
+		if !cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		br := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(br, r)
+		if br.status != http.StatusOK || br.buf.Len() < cfg.MinSize || w.Header().Get("Content-Encoding") != "" {
+			w.WriteHeader(br.status)
+			w.Write(br.buf.Bytes())
+			return
+		}
+
+		compressed, err := compress(encoding, br.buf.Bytes())
+		if err != nil {
+			logger.log.WithError(err).Warn("Unable to compress the response, it is sent uncompressed")
+			w.WriteHeader(br.status)
+			w.Write(br.buf.Bytes())
+			return
+		}
+		observeCompression(compressionResponse, encoding, br.buf.Len(), len(compressed))
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Del("Content-Length")
+		if etag := w.Header().Get("ETag"); etag != "" {
+			w.Header().Set("ETag", encodedETag(etag, encoding))
+		}
+		w.WriteHeader(br.status)
+		w.Write(compressed)
+	})
+}
+
+// gzipBody is a decompressed request body, read up to a limit.
+type gzipBody struct {
+	io.Reader
+	gz *gzip.Reader
+}
+
+func (b gzipBody) Close() error {
+	return b.gz.Close()
+}
+
+// bufferedResponse keeps the response written, to compress it once it is complete.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.buf.Write(p)
+}
+
+// negotiateEncoding returns the encoding of the Accept-Encoding the client prefers, brotli winning
+// a tie, or none if it accepts neither.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v := strings.TrimSpace(param); strings.HasPrefix(v, "q=") {
+				if parsed, err := strconv.ParseFloat(strings.TrimPrefix(v, "q="), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if encoding == "*" {
+			encoding = encodingBrotli
+		}
+		if (encoding != encodingGzip && encoding != encodingBrotli) || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && encoding == encodingBrotli) {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	if encoding == encodingBrotli {
+		w = brotli.NewWriter(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodedETag tells the encoded responses apart from the identity one, as their bytes differ.
+func encodedETag(etag string, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// decodedETag returns the ETag of the identity response of an encoded one.
+func decodedETag(etag string) string {
+	for _, encoding := range []string{encodingGzip, encodingBrotli} {
+		if strings.HasSuffix(etag, "-"+encoding+`"`) {
+			return strings.TrimSuffix(etag, "-"+encoding+`"`) + `"`
+		}
+	}
+	return etag
+}
+
+// readBody reads a response body, decompressing it if it was gzipped. A decompressed body larger than
+// the max size is a limitError, 0 doesn't limit it.
+func readBody(res *http.Response, maxBodySize int64) ([]byte, error) {
+	if !strings.EqualFold(res.Header.Get("Content-Encoding"), encodingGzip) {
+		return ioutil.ReadAll(res.Body)
+	}
+	counted := &countingReader{r: res.Body}
+	gz, err := gzip.NewReader(counted)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	r := io.Reader(gz)
+	if maxBodySize > 0 {
+		r = io.LimitReader(gz, maxBodySize+1)
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxBodySize > 0 && int64(len(body)) > maxBodySize {
+		return nil, limitError{limitMaxBodySize, fmt.Sprintf("The decompressed response is larger than the limit of %d bytes", maxBodySize)}
+	}
+	observeCompression(compressionUpstream, encodingGzip, len(body), counted.n)
+	return body, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,209 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipForTest(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(body))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// echoHandler responds with the body of the request, and an ETag.
+func echoHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		w.Header().Set("ETag", `"abc"`)
+		w.Write(body)
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip":                  encodingGzip,
+		"gzip, deflate, br":     encodingBrotli,
+		"br;q=0.5, gzip":        encodingGzip,
+		"br;q=0, gzip;q=0.1":    encodingGzip,
+		"GZIP;q=0.8, *;q=0.1":   encodingGzip,
+		"*":                     encodingBrotli,
+		"deflate, gzip;q=0":     "",
+		"gzip;q=0.5, br;q=0.5 ": encodingBrotli,
+	}
+	for header, expected := range tests {
+		assert.Equal(t, expected, negotiateEncoding(header), "Accept-Encoding: %s", header)
+	}
+}
+
+func TestCompressor_DecompressesRequestBodies(t *testing.T) {
+	h := NewCompressor(CompressionConfig{}, nil).Handler(echoHandler(t))
+
+	req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(gzipForTest(t, `{"id":"a"}`)))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":"a"}`, w.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set("Content-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "A body which isn't gzipped should be rejected")
+
+	req = httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(`{"id":"a"}`))
+	req.Header.Set("Content-Encoding", "deflate")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestCompressor_CompressesResponses(t *testing.T) {
+	c := NewCompressor(CompressionConfig{Enabled: true, MinSize: 100}, nil)
+	h := c.Handler(echoHandler(t))
+	large := `{"bodyXML":"` + strings.Repeat("<p>text</p>", 100) + `"}`
+	post := func(path string, body string, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	w := post("/content", large, "gzip")
+	assert.Equal(t, encodingGzip, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `"abc-gzip"`, w.Header().Get("ETag"))
+	gz, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, large, string(body))
+
+	w = post("/internalcontent-preview", large, "gzip, br")
+	assert.Equal(t, encodingBrotli, w.Header().Get("Content-Encoding"))
+	assert.True(t, w.Body.Len() < len(large))
+	body, err = ioutil.ReadAll(brotli.NewReader(w.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, large, string(body))
+
+	w = post("/content", `{"id":"a"}`, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "A response under the min size shouldn't be compressed")
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+	assert.Equal(t, `{"id":"a"}`, w.Body.String())
+
+	w = post("/__gtg", large, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"), "Only the unroll endpoints should be compressed")
+
+	c.UpdateConfig(CompressionConfig{})
+	w = post("/content", large, "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Vary"))
+}
+
+func TestNotModified_EncodedETags(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/content", nil)
+	r.Header.Set("If-None-Match", encodedETag(`"abc"`, encodingBrotli))
+	assert.True(t, notModified(r, `"abc"`), "The ETag of an encoded response should match its identity one")
+}
+
+func TestGet_GzippedUpstreamResponses(t *testing.T) {
+	source, err := ioutil.ReadFile("../test-resources/source-content-valid-response.json")
+	assert.NoError(t, err)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, encodingGzip, r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", encodingGzip)
+		w.Write(gzipForTest(t, string(source)))
+	}))
+	defer ts.Close()
+
+	actual, err := readerForTest(ts.URL, "").Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	for _, uuid := range testData {
+		assert.Contains(t, actual, uuid)
+	}
+}
+
+func TestGet_GzippedUpstreamResponsesLargerThanTheMaxBodySize(t *testing.T) {
+	source, err := ioutil.ReadFile("../test-resources/source-content-valid-response.json")
+	assert.NoError(t, err)
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Encoding", encodingGzip)
+		w.Write(gzipForTest(t, string(source)))
+	}))
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHosts:   HostPoolConfig{Hosts: []string{ts.URL}, FailoverHosts: []string{ts.URL}},
+		MaxBodySize:         int64(len(source)) - 1,
+	}, http.DefaultClient)
+
+	_, err = cr.Get(context.Background(), testData, "tid_1")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, limitStatus(err, http.StatusInternalServerError))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "A response too large isn't a failure of the host")
+}
+
+func TestLoadConfig_Compression(t *testing.T) {
+	cfg, err := parseConfig([]byte("compression:\n  enabled: true\n  minSize: 512\n"), defaultConfigForTest())
+	assert.NoError(t, err)
+	assert.Equal(t, CompressionConfig{Enabled: true, MinSize: 512}, cfg.Compression)
+	_, err = parseConfig([]byte("compression:\n  minSize: -1\n"), defaultConfigForTest())
+	assert.Error(t, err)
+}
+
+func TestCompressor_SignedGzippedRequests(t *testing.T) {
+	now := time.Now()
+	limits := NewUnrollLimits(LimitsConfig{MaxBodySize: 1024})
+	auth := inboundAuthForTest(now)
+	auth.limits = limits
+	var client string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = clientID(r.Context())
+		body, err := readBodyWithin(r.Body, 1024)
+		assert.NoError(t, err)
+		w.Write(body)
+	})
+	h := NewCompressor(CompressionConfig{}, limits).Handler(auth.Handler(next))
+
+	signed := func(body []byte) *http.Request {
+		req := signedRequestForTest("renderer-key", "renderer", now, string(body))
+		req.Header.Set("Content-Encoding", "gzip")
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, signed(gzipForTest(t, signedBodyForTest)))
+	assert.Equal(t, http.StatusOK, w.Code, "The signature should be of the decompressed body")
+	assert.Equal(t, "renderer", client)
+	assert.Equal(t, signedBodyForTest, w.Body.String())
+
+	client = ""
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, signed(gzipForTest(t, signedBodyForTest+strings.Repeat(" ", 1<<20))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, "A body decompressing past the limit should be rejected")
+	assert.Contains(t, w.Body.String(), "limit of 1024 bytes")
+	assert.Empty(t, client)
+}
@@ -0,0 +1,412 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// The fields of the content which expansion can be turned off in the config.
+const (
+	expandMainImage        = "mainImage"
+	expandPromotionalImage = "promotionalImage"
+	expandEmbeddedImages   = "embeddedImages"
+	expandDynamicContent   = "dynamicContent"
+	expandLeadImages       = "leadImages"
+)
+
+const configSettleDelay = 100 * time.Millisecond
+
+var expansionToggles = []string{expandMainImage, expandPromotionalImage, expandEmbeddedImages, expandDynamicContent, expandLeadImages}
+
+// restartOnlySettings can't be changed while the service is running: the app names and paths name metrics
+// and healthchecks, the TLS settings configure the connections and the intervals start the polling.
+// A setting ending with a dot covers a section.
+var restartOnlySettings = []string{"contentStore.appName", "contentPreview.appName", "paths.", "upstreamTLS.", "serverTLS.", "healthchecks.interval", "selfTest.interval"}
+
+// Config is the configuration which can be read from the YAML config file.
+type Config struct {
+	APIHost        string          `yaml:"apiHost"`
+	ContentStore   UpstreamConfig  `yaml:"contentStore"`
+	ContentPreview UpstreamConfig  `yaml:"contentPreview"`
+	Paths          PathsConfig     `yaml:"paths"`
+	Timeouts       TimeoutsConfig  `yaml:"timeouts"`
+	Cache          CacheConfig     `yaml:"cache"`
+	Expansion      ExpansionConfig `yaml:"expansion"`
+	// The certificate files are read again when they change
+	UpstreamTLS  UpstreamTLSConfig  `yaml:"upstreamTLS"`
+	ServerTLS    ServerTLSConfig    `yaml:"serverTLS"`
+	InboundAuth  InboundAuthConfig  `yaml:"inboundAuth"`
+	RateLimits   RateLimitConfig    `yaml:"rateLimits"`
+	LoadShedding LoadSheddingConfig `yaml:"loadShedding"`
+	Healthchecks HealthchecksConfig `yaml:"healthchecks"`
+	SelfTest     SelfTestConfig     `yaml:"selfTest"`
+	Invalidation InvalidationConfig `yaml:"invalidation"`
+	OutputCache  OutputCacheConfig  `yaml:"outputCache"`
+	Compression  CompressionConfig  `yaml:"compression"`
+	Limits       LimitsConfig       `yaml:"limits"`
+}
+
+type UpstreamConfig struct {
+	AppName string `yaml:"appName"`
+	Host    string `yaml:"host"`
+	// Hosts replace the single host, to balance the requests between them
+	Hosts []string `yaml:"hosts"`
+	// FailoverHosts, usually in another region, are only used when none of the hosts is available
+	FailoverHosts []string       `yaml:"failoverHosts"`
+	Balancing     string         `yaml:"balancing"`
+	EjectAfter    int            `yaml:"ejectAfter"`
+	EjectFor      time.Duration  `yaml:"ejectFor"`
+	Auth          AuthConfig     `yaml:"auth"`
+	Bulkhead      BulkheadConfig `yaml:"bulkhead"`
+}
+
+func (u UpstreamConfig) hostPool() HostPoolConfig {
+	return HostPoolConfig{
+		Hosts:         u.Hosts,
+		FailoverHosts: u.FailoverHosts,
+		Balancing:     u.Balancing,
+		EjectAfter:    u.EjectAfter,
+		EjectFor:      u.EjectFor,
+	}.withSingleHost(u.Host)
+}
+
+// AllHosts returns the hosts of the upstream app followed by its failover hosts.
+func (u UpstreamConfig) AllHosts() []string {
+	p := u.hostPool()
+	return append(append([]string{}, p.Hosts...), p.FailoverHosts...)
+}
+
+func (u UpstreamConfig) validate(name string) error {
+	if len(u.hostPool().Hosts) == 0 {
+		return errors.Errorf("%s.host or %s.hosts is required", name, name)
+	}
+	if u.Balancing != "" && u.Balancing != BalancingRoundRobin && u.Balancing != BalancingLeastOutstanding {
+		return errors.Errorf("unknown %s.balancing %q, it should be %s or %s", name, u.Balancing, BalancingRoundRobin, BalancingLeastOutstanding)
+	}
+	if err := u.Bulkhead.validate(name); err != nil {
+		return err
+	}
+	return u.Auth.validate(name)
+}
+
+type PathsConfig struct {
+	Content         string `yaml:"content"`
+	InternalContent string `yaml:"internalContent"`
+}
+
+type TimeoutsConfig struct {
+	// Upstream limits each request to the content store and content preview apps
+	Upstream    time.Duration `yaml:"upstream"`
+	Healthcheck time.Duration `yaml:"healthcheck"`
+}
+
+// CacheConfig configures the cache of the models read from the content store.
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	// SoftTTL is when a model is read again in the background while it is still served, 0 never reads it early
+	SoftTTL time.Duration `yaml:"softTTL"`
+	// MaxStale is how long after its TTL a model can be served when the content store fails, 0 never serves it
+	MaxStale   time.Duration `yaml:"maxStale"`
+	MaxEntries int           `yaml:"maxEntries"`
+	// Revalidate keeps the upstream responses with an ETag or a Last-Modified, up to MaxEntries of them,
+	// to request them conditionally. It applies to the preview reads too, whether the cache is enabled or not.
+	Revalidate bool `yaml:"revalidate"`
+}
+
+// ExpansionConfig lists the fields which are left as they are instead of being expanded.
+type ExpansionConfig struct {
+	Disabled []string `yaml:"disabled"`
+}
+
+func (e ExpansionConfig) enabled(field string) bool {
+	for _, d := range e.Disabled {
+		if d == field {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptedTypes returns the types of embedded content which are expanded.
+func (e ExpansionConfig) acceptedTypes(types ...string) []string {
+	var accepted []string
+	for _, t := range types {
+		if t == ImageSetType && !e.enabled(expandEmbeddedImages) || t == DynamicContentType && !e.enabled(expandDynamicContent) {
+			continue
+		}
+		accepted = append(accepted, t)
+	}
+	return accepted
+}
+
+func (c Config) ReaderConfig() ReaderConfig {
+	return ReaderConfig{
+		ContentStoreAppName:         c.ContentStore.AppName,
+		ContentStoreHost:            c.ContentStore.Host,
+		ContentPreviewAppName:       c.ContentPreview.AppName,
+		ContentPreviewHost:          c.ContentPreview.Host,
+		ContentPathEndpoint:         c.Paths.Content,
+		InternalContentPathEndpoint: c.Paths.InternalContent,
+		ContentStoreHosts:           c.ContentStore.hostPool(),
+		ContentPreviewHosts:         c.ContentPreview.hostPool(),
+		ContentStoreAuth:            c.ContentStore.Auth,
+		ContentPreviewAuth:          c.ContentPreview.Auth,
+		ContentStoreBulkhead:        c.ContentStore.Bulkhead,
+		ContentPreviewBulkhead:      c.ContentPreview.Bulkhead,
+		Timeout:                     c.Timeouts.Upstream,
+		Cache:                       c.Cache,
+		MaxBodySize:                 c.Limits.withDefaults().MaxBodySize,
+	}
+}
+
+func (c Config) validate() error {
+	if err := c.ContentStore.validate("contentStore"); err != nil {
+		return err
+	}
+	if err := c.ContentPreview.validate("contentPreview"); err != nil {
+		return err
+	}
+	if c.Timeouts.Upstream <= 0 || c.Timeouts.Healthcheck <= 0 {
+		return errors.New("timeouts must be positive")
+	}
+	if c.Cache.Enabled && (c.Cache.TTL <= 0 || c.Cache.MaxEntries <= 0) {
+		return errors.New("cache.ttl and cache.maxEntries must be positive when the cache is enabled")
+	}
+	if c.Cache.Revalidate && c.Cache.MaxEntries <= 0 {
+		return errors.New("cache.maxEntries must be positive when the responses are revalidated")
+	}
+	if c.Cache.SoftTTL < 0 || c.Cache.MaxStale < 0 {
+		return errors.New("cache.softTTL and cache.maxStale can't be negative")
+	}
+	if c.Cache.SoftTTL >= c.Cache.TTL && c.Cache.SoftTTL > 0 {
+		return errors.New("cache.softTTL must be shorter than cache.ttl")
+	}
+	if err := c.UpstreamTLS.validate(); err != nil {
+		return err
+	}
+	if err := c.ServerTLS.validate(); err != nil {
+		return err
+	}
+	if err := c.InboundAuth.validate(); err != nil {
+		return err
+	}
+	if err := c.RateLimits.validate(); err != nil {
+		return err
+	}
+	if err := c.LoadShedding.validate(); err != nil {
+		return err
+	}
+	if err := c.Healthchecks.validate(); err != nil {
+		return err
+	}
+	if err := c.SelfTest.validate(); err != nil {
+		return err
+	}
+	if err := c.Invalidation.validate(); err != nil {
+		return err
+	}
+	if err := c.OutputCache.validate(); err != nil {
+		return err
+	}
+	if err := c.Compression.validate(); err != nil {
+		return err
+	}
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	for _, d := range c.Expansion.Disabled {
+		if !contains(expansionToggles, d) {
+			return errors.Errorf("unknown field %q in expansion.disabled, it should be one of: %s", d, strings.Join(expansionToggles, ", "))
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the config file, the settings it doesn't have keep their value in defaults.
+func LoadConfig(path string, defaults Config) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return defaults, errors.Wrapf(err, "Error reading config file %s", path)
+	}
+	return parseConfig(data, defaults)
+}
+
+func parseConfig(data []byte, defaults Config) (Config, error) {
+	cfg := defaults
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return defaults, errors.Wrap(err, "Error parsing config file")
+	}
+	if err := cfg.validate(); err != nil {
+		return defaults, errors.Wrap(err, "Invalid config file")
+	}
+	return cfg, nil
+}
+
+// ConfigWatcher reloads the config file when it changes and applies the settings
+// which can be changed at runtime.
+type ConfigWatcher struct {
+	path     string
+	defaults Config
+	apply    func(Config)
+
+	mu      sync.Mutex
+	current Config
+	data    []byte
+}
+
+// NewConfigWatcher returns a watcher of the config file at path, which has been loaded as current.
+func NewConfigWatcher(path string, defaults Config, current Config, apply func(Config)) *ConfigWatcher {
+	data, _ := ioutil.ReadFile(path)
+	return &ConfigWatcher{path: path, defaults: defaults, apply: apply, current: current, data: data}
+}
+
+// Watch reloads the config file whenever it changes, until the context is done.
+// The directory is watched, as Kubernetes replaces the files of a mounted ConfigMap by swapping symlinks.
+func (w *ConfigWatcher) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "Error creating the config file watcher")
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(w.path)); err != nil {
+		return errors.Wrapf(err, "Error watching config file %s", w.path)
+	}
+
+	// the file is reloaded once the writes have settled, so that a partly written file isn't read
+	settled := time.NewTimer(time.Hour)
+	settled.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-watcher.Events:
+			settled.Reset(configSettleDelay)
+		case <-settled.C:
+			w.Reload()
+		case err := <-watcher.Errors:
+			w.log().WithError(err).Warn("Error watching the config file")
+		}
+	}
+}
+
+// Reload reads the config file and applies its runtime settings if it has changed.
+// An invalid file is ignored, keeping the current config.
+func (w *ConfigWatcher) Reload() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil || bytes.Equal(data, w.data) {
+		return
+	}
+	w.data = data
+
+	cfg, err := parseConfig(data, w.defaults)
+	if err != nil {
+		w.log().WithError(err).Error("Config file not reloaded, keeping the current config")
+		return
+	}
+
+	var applied, ignored []string
+	for _, change := range diffConfig(w.current, cfg) {
+		if isRestartOnly(change.setting) {
+			ignored = append(ignored, change.String())
+		} else {
+			applied = append(applied, change.String())
+		}
+	}
+	cfg.ContentStore.AppName = w.current.ContentStore.AppName
+	cfg.ContentPreview.AppName = w.current.ContentPreview.AppName
+	cfg.Paths = w.current.Paths
+	cfg.UpstreamTLS = w.current.UpstreamTLS
+	cfg.ServerTLS = w.current.ServerTLS
+	cfg.Healthchecks.Interval = w.current.Healthchecks.Interval
+	cfg.SelfTest.Interval = w.current.SelfTest.Interval
+
+	if len(ignored) > 0 {
+		w.log().Warnf("Config changes which need a restart were not applied: %s", strings.Join(ignored, ", "))
+	}
+	if len(applied) == 0 {
+		return
+	}
+	w.apply(cfg)
+	w.current = cfg
+	w.log().Infof("Config reloaded: %s", strings.Join(applied, ", "))
+}
+
+func (w *ConfigWatcher) log() *logrus.Entry {
+	return logger.log.WithField("config_file", w.path)
+}
+
+type configChange struct {
+	setting  string
+	from, to interface{}
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.setting, c.from, c.to)
+}
+
+// diffConfig returns the changed settings, named by their path in the config file.
+func diffConfig(from Config, to Config) []configChange {
+	fromSettings, toSettings := flattenConfig(from), flattenConfig(to)
+	var changes []configChange
+	for setting, v := range toSettings {
+		if fmt.Sprint(fromSettings[setting]) != fmt.Sprint(v) {
+			changes = append(changes, configChange{setting, fromSettings[setting], v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].setting < changes[j].setting })
+	return changes
+}
+
+func flattenConfig(c Config) map[string]interface{} {
+	settings := make(map[string]interface{})
+	out, _ := yaml.Marshal(c)
+	var m yaml.MapSlice
+	yaml.Unmarshal(out, &m)
+	flatten("", m, settings)
+	return settings
+}
+
+func flatten(prefix string, m yaml.MapSlice, settings map[string]interface{}) {
+	for _, item := range m {
+		key := prefix + fmt.Sprint(item.Key)
+		if nested, ok := item.Value.(yaml.MapSlice); ok {
+			flatten(key+".", nested, settings)
+			continue
+		}
+		settings[key] = item.Value
+	}
+}
+
+func isRestartOnly(setting string) bool {
+	for _, s := range restartOnlySettings {
+		if setting == s || strings.HasSuffix(s, ".") && strings.HasPrefix(setting, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
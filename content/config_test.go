@@ -0,0 +1,150 @@
+package content
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func defaultConfigForTest() Config {
+	return Config{
+		APIHost:        "test.api.ft.com",
+		ContentStore:   UpstreamConfig{AppName: "content-source-app-name", Host: "http://localhost:8080"},
+		ContentPreview: UpstreamConfig{AppName: "content-preview-app-name", Host: "http://localhost:8081"},
+		Paths:          PathsConfig{Content: "/content", InternalContent: "/internalcontent"},
+		Timeouts:       TimeoutsConfig{Upstream: 10 * time.Second, Healthcheck: 10 * time.Second},
+		Cache:          CacheConfig{TTL: time.Minute, MaxEntries: 100},
+	}
+}
+
+func writeConfigForTest(t *testing.T, dir string, config string) string {
+	path := filepath.Join(dir, "config.yml")
+	err := ioutil.WriteFile(path, []byte(config), 0644)
+	assert.NoError(t, err, "Cannot write the config file")
+	return path
+}
+
+func tempDirForTest(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "content-unroller")
+	assert.NoError(t, err, "Cannot create a directory for the config file")
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfig_OverridesDefaults(t *testing.T) {
+	dir, cleanup := tempDirForTest(t)
+	defer cleanup()
+	path := writeConfigForTest(t, dir, `
+contentStore:
+  host: http://content-public-read:8080
+timeouts:
+  upstream: 3s
+cache:
+  enabled: true
+  ttl: 5m
+expansion:
+  disabled: [leadImages]
+`)
+
+	cfg, err := LoadConfig(path, defaultConfigForTest())
+	assert.NoError(t, err)
+	assert.Equal(t, "http://content-public-read:8080", cfg.ContentStore.Host)
+	assert.Equal(t, "content-source-app-name", cfg.ContentStore.AppName)
+	assert.Equal(t, 3*time.Second, cfg.Timeouts.Upstream)
+	assert.Equal(t, 10*time.Second, cfg.Timeouts.Healthcheck)
+	assert.Equal(t, CacheConfig{Enabled: true, TTL: 5 * time.Minute, MaxEntries: 100}, cfg.Cache)
+	assert.False(t, cfg.Expansion.enabled(expandLeadImages))
+	assert.True(t, cfg.Expansion.enabled(expandMainImage))
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown setting":              "cahce:\n  enabled: true\n",
+		"invalid duration":             "timeouts:\n  upstream: soon\n",
+		"unknown expansion toggle":     "expansion:\n  disabled: [bodyXML]\n",
+		"cache without TTL":            "cache:\n  enabled: true\n  ttl: 0s\n",
+		"soft TTL past the TTL":        "cache:\n  enabled: true\n  ttl: 1m\n  softTTL: 2m\n",
+		"negative max staleness":       "cache:\n  enabled: true\n  maxStale: -1m\n",
+		"revalidation without entries": "cache:\n  revalidate: true\n  maxEntries: 0\n",
+		"missing host":                 "contentStore:\n  host: ''\n",
+		"max depth past the members":   "limits:\n  maxDepth: 3\n",
+	}
+	dir, cleanup := tempDirForTest(t)
+	defer cleanup()
+	for name, config := range tests {
+		path := writeConfigForTest(t, dir, config)
+		_, err := LoadConfig(path, defaultConfigForTest())
+		assert.Error(t, err, "The config with an %s should be rejected", name)
+	}
+}
+
+func TestConfigWatcher_Reload(t *testing.T) {
+	dir, cleanup := tempDirForTest(t)
+	defer cleanup()
+	defaults := defaultConfigForTest()
+	path := writeConfigForTest(t, dir, "cache:\n  enabled: true\n")
+	current, err := LoadConfig(path, defaults)
+	assert.NoError(t, err)
+
+	var applied []Config
+	w := NewConfigWatcher(path, defaults, current, func(c Config) { applied = append(applied, c) })
+
+	writeConfigForTest(t, dir, "cache:\n  enabled: true\n  ttl: 5m\ncontentStore:\n  appName: other-app\n  host: http://other-host\n")
+	w.Reload()
+	if assert.Len(t, applied, 1) {
+		assert.Equal(t, 5*time.Minute, applied[0].Cache.TTL)
+		assert.Equal(t, "http://other-host", applied[0].ContentStore.Host)
+		assert.Equal(t, "content-source-app-name", applied[0].ContentStore.AppName, "The app name should only change on restart")
+	}
+
+	writeConfigForTest(t, dir, "cache:\n  enabled: maybe\n")
+	w.Reload()
+	assert.Len(t, applied, 1, "An invalid config should not be applied")
+}
+
+func TestConfigWatcher_WatchAppliesChanges(t *testing.T) {
+	dir, cleanup := tempDirForTest(t)
+	defer cleanup()
+	defaults := defaultConfigForTest()
+	path := writeConfigForTest(t, dir, "timeouts:\n  upstream: 1s\n")
+	current, err := LoadConfig(path, defaults)
+	assert.NoError(t, err)
+
+	applied := make(chan Config, 10)
+	w := NewConfigWatcher(path, defaults, current, func(c Config) { applied <- c })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watching := make(chan error, 1)
+	go func() { watching <- w.Watch(ctx) }()
+
+	// the watcher may not be set up yet, so the file is written until the change is seen
+	for i := 0; i < 50; i++ {
+		writeConfigForTest(t, dir, "timeouts:\n  upstream: 2s\n")
+		select {
+		case c := <-applied:
+			assert.Equal(t, 2*time.Second, c.Timeouts.Upstream)
+			cancel()
+			assert.NoError(t, <-watching)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	assert.Fail(t, "The change of the config file should be applied")
+}
+
+func TestDiffConfig(t *testing.T) {
+	from := defaultConfigForTest()
+	to := from
+	to.Timeouts.Upstream = 5 * time.Second
+	to.Expansion.Disabled = []string{expandMainImage}
+
+	var changes []string
+	for _, c := range diffConfig(from, to) {
+		changes = append(changes, c.String())
+	}
+	assert.Equal(t, []string{"expansion.disabled: [] -> [mainImage]", "timeouts.upstream: 10s -> 5s"}, changes)
+}
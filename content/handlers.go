@@ -1,13 +1,17 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/Financial-Times/transactionid-utils-go"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ErrorMessage struct {
@@ -18,24 +22,52 @@ var logger = NewAppLogger()
 
 type Handler struct {
 	Service Unroller
+	// Outputs memoizes the unrolled responses by input, when it is set
+	Outputs *OutputCache
+	// Limits caps the cost of the unrolls, the defaults apply when it isn't set
+	Limits *UnrollLimits
 }
 
 type UnrollEvent struct {
-	c    Content
+	c    *Article
 	tid  string
 	uuid string
+	ctx  context.Context
 }
 
 type UnrollResult struct {
-	uc  Content
-	err error
+	uc     *Article
+	err    error
+	report *ExpansionReport
+}
+
+// expandedEmbeds returns the number of embedded items that have been expanded.
+func (res UnrollResult) expandedEmbeds() int {
+	if res.uc == nil {
+		return 0
+	}
+	expanded := 0
+	for _, e := range res.uc.Embeds {
+		if e != nil {
+			expanded++
+		}
+	}
+	return expanded
 }
 
 func (hh *Handler) GetContent(w http.ResponseWriter, r *http.Request) {
+	sr := newStatusRecorder(w)
+	w = sr
+	defer observeRequest(contentEndpoint, time.Now(), sr)
+
 	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	event, err := createUnrollEvent(r, tid)
+	ctx, span := startServerSpan(r, contentEndpoint, tid)
+	defer endServerSpan(span, sr)
+
+	limits := hh.Limits.currentConfig()
+	event, input, err := createUnrollEvent(ctx, r, tid, limits.MaxBodySize)
 	if err != nil {
-		handleError(r, tid, "", w, err, http.StatusBadRequest)
+		handleError(r, tid, "", w, err, limitStatus(err, http.StatusBadRequest))
 		return
 	}
 
@@ -44,30 +76,37 @@ func (hh *Handler) GetContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid)
-
-	res := hh.Service.UnrollContent(event)
-	if res.err != nil {
-		handleError(r, tid, event.uuid, w, res.err, http.StatusInternalServerError)
+	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid, clientID(ctx))
+	key := hh.Outputs.key(contentEndpoint, input)
+	if hh.writeMemoized(w, r, tid, event.uuid, key) {
 		return
 	}
 
-	jsonRes, err := json.Marshal(res.uc)
-	if err != nil {
-		handleError(r, tid, event.uuid, w, err, http.StatusInternalServerError)
+	res, expanded := unrollWithin(event, limits, hh.Service.UnrollContent)
+	if res.err != nil {
+		handleError(r, tid, event.uuid, w, res.err, limitStatus(res.err, http.StatusInternalServerError))
 		return
 	}
+	observeExpansion(contentEndpoint, res)
+	traceExpansion(ctx, res)
 
-	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, event.uuid, "success")
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(jsonRes)
+	hh.writeUnrolled(w, r, tid, event.uuid, key, res, expanded)
 }
 
 func (hh *Handler) GetInternalContent(w http.ResponseWriter, r *http.Request) {
+	sr := newStatusRecorder(w)
+	w = sr
+	defer observeRequest(internalContentEndpoint, time.Now(), sr)
+
 	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	event, err := createUnrollEvent(r, tid)
+	ctx, span := startServerSpan(r, internalContentEndpoint, tid)
+	defer endServerSpan(span, sr)
+
+	limits := hh.Limits.currentConfig()
+	event, input, err := createUnrollEvent(ctx, r, tid, limits.MaxBodySize)
 	if err != nil {
-		handleError(r, tid, "", w, err, http.StatusBadRequest)
+		handleError(r, tid, "", w, err, limitStatus(err, http.StatusBadRequest))
+		return
 	}
 
 	if !validateInternalContent(event.c) {
@@ -75,30 +114,37 @@ func (hh *Handler) GetInternalContent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid)
-
-	res := hh.Service.UnrollInternalContent(event)
-	if res.err != nil {
-		handleError(r, tid, event.uuid, w, res.err, http.StatusInternalServerError)
+	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid, clientID(ctx))
+	key := hh.Outputs.key(internalContentEndpoint, input)
+	if hh.writeMemoized(w, r, tid, event.uuid, key) {
 		return
 	}
 
-	jsonRes, err := json.Marshal(res.uc)
-	if err != nil {
-		handleError(r, tid, event.uuid, w, err, http.StatusInternalServerError)
+	res, expanded := unrollWithin(event, limits, hh.Service.UnrollInternalContent)
+	if res.err != nil {
+		handleError(r, tid, event.uuid, w, res.err, limitStatus(res.err, http.StatusInternalServerError))
 		return
 	}
+	observeExpansion(internalContentEndpoint, res)
+	traceExpansion(ctx, res)
 
-	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, event.uuid, "success")
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(jsonRes)
+	hh.writeUnrolled(w, r, tid, event.uuid, key, res, expanded)
 }
 
 func (hh *Handler) GetContentPreview(w http.ResponseWriter, r *http.Request) {
+	sr := newStatusRecorder(w)
+	w = sr
+	defer observeRequest(contentPreviewEndpoint, time.Now(), sr)
+
 	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	event, err := createUnrollEvent(r, tid)
+	ctx, span := startServerSpan(r, contentPreviewEndpoint, tid)
+	defer endServerSpan(span, sr)
+
+	limits := hh.Limits.currentConfig()
+	event, input, err := createUnrollEvent(ctx, r, tid, limits.MaxBodySize)
 	if err != nil {
-		handleError(r, tid, "", w, err, http.StatusBadRequest)
+		handleError(r, tid, "", w, err, limitStatus(err, http.StatusBadRequest))
+		return
 	}
 
 	if !validateContent(event.c) {
@@ -106,30 +152,37 @@ func (hh *Handler) GetContentPreview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid)
-
-	res := hh.Service.UnrollContentPreview(event)
-	if res.err != nil {
-		handleError(r, tid, event.uuid, w, res.err, http.StatusInternalServerError)
+	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid, clientID(ctx))
+	key := hh.Outputs.key(contentPreviewEndpoint, input)
+	if hh.writeMemoized(w, r, tid, event.uuid, key) {
 		return
 	}
 
-	jsonRes, err := json.Marshal(res.uc)
-	if err != nil {
-		handleError(r, tid, event.uuid, w, err, http.StatusInternalServerError)
+	res, expanded := unrollWithin(event, limits, hh.Service.UnrollContentPreview)
+	if res.err != nil {
+		handleError(r, tid, event.uuid, w, res.err, limitStatus(res.err, http.StatusInternalServerError))
 		return
 	}
+	observeExpansion(contentPreviewEndpoint, res)
+	traceExpansion(ctx, res)
 
-	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, event.uuid, "success")
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(jsonRes)
+	hh.writeUnrolled(w, r, tid, event.uuid, key, res, expanded)
 }
 
 func (hh *Handler) GetInternalContentPreview(w http.ResponseWriter, r *http.Request) {
+	sr := newStatusRecorder(w)
+	w = sr
+	defer observeRequest(internalContentPreviewEndpoint, time.Now(), sr)
+
 	tid := transactionidutils.GetTransactionIDFromRequest(r)
-	event, err := createUnrollEvent(r, tid)
+	ctx, span := startServerSpan(r, internalContentPreviewEndpoint, tid)
+	defer endServerSpan(span, sr)
+
+	limits := hh.Limits.currentConfig()
+	event, input, err := createUnrollEvent(ctx, r, tid, limits.MaxBodySize)
 	if err != nil {
-		handleError(r, tid, "", w, err, http.StatusBadRequest)
+		handleError(r, tid, "", w, err, limitStatus(err, http.StatusBadRequest))
+		return
 	}
 
 	if !validateInternalContent(event.c) {
@@ -137,56 +190,129 @@ func (hh *Handler) GetInternalContentPreview(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid)
-
-	res := hh.Service.UnrollInternalContentPreview(event)
-	if res.err != nil {
-		handleError(r, tid, event.uuid, w, res.err, http.StatusInternalServerError)
+	logger.TransactionStartedEvent(r.RequestURI, tid, event.uuid, clientID(ctx))
+	key := hh.Outputs.key(internalContentPreviewEndpoint, input)
+	if hh.writeMemoized(w, r, tid, event.uuid, key) {
 		return
 	}
 
-	jsonRes, err := json.Marshal(res.uc)
-	if err != nil {
-		handleError(r, tid, event.uuid, w, err, http.StatusInternalServerError)
+	res, expanded := unrollWithin(event, limits, hh.Service.UnrollInternalContentPreview)
+	if res.err != nil {
+		handleError(r, tid, event.uuid, w, res.err, limitStatus(res.err, http.StatusInternalServerError))
 		return
 	}
+	observeExpansion(internalContentPreviewEndpoint, res)
+	traceExpansion(ctx, res)
 
-	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, event.uuid, "success")
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.Write(jsonRes)
+	hh.writeUnrolled(w, r, tid, event.uuid, key, res, expanded)
 }
 
-func createUnrollEvent(r *http.Request, tid string) (UnrollEvent, error) {
+// createUnrollEvent decodes the article of the request, returning its body too. A body larger
+// than the max size is rejected.
+func createUnrollEvent(ctx context.Context, r *http.Request, tid string, maxBodySize int64) (UnrollEvent, []byte, error) {
 	var unrollEvent UnrollEvent
-	b, err := ioutil.ReadAll(r.Body)
+	b, err := readBodyWithin(r.Body, maxBodySize)
 	if err != nil {
-		return unrollEvent, err
+		return unrollEvent, nil, err
 	}
 
-	var article Content
+	var article Article
 	err = json.Unmarshal(b, &article)
 	if err != nil {
-		return unrollEvent, err
+		return unrollEvent, nil, err
 	}
 
-	id, ok := article[id].(string)
-	if !ok {
-		return unrollEvent, errors.New("Missing or invalid id field")
+	if article.ID == "" {
+		return unrollEvent, nil, errors.New("Missing or invalid id field")
 	}
-	uuid, err := extractUUIDFromString(id)
+	uuid, err := extractUUIDFromString(article.ID)
 	if err != nil {
-		return unrollEvent, err
+		return unrollEvent, nil, err
 	}
-	unrollEvent = UnrollEvent{article, tid, uuid}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("content.uuid", uuid))
+	unrollEvent = UnrollEvent{&article, tid, uuid, ctx}
 
-	return unrollEvent, nil
+	return unrollEvent, b, nil
+}
+
+// unrollWithin unrolls the article within the limits, reporting the timeout if the unroll ran out of
+// time, and failing it if the article itself exceeds the limits. It returns the UUIDs of the models read too.
+func unrollWithin(event UnrollEvent, limits LimitsConfig, unroll func(UnrollEvent) UnrollResult) (UnrollResult, map[string]bool) {
+	budget := newUnrollBudget(limits)
+	ctx, cancel := withTimeout(withUnrollBudget(event.ctx, budget), limits.Timeout)
+	defer cancel()
+	event.ctx = ctx
+
+	res := unroll(event)
+	if ctx.Err() == context.DeadlineExceeded {
+		res.report.addLimit(limitTimeout, limits.Timeout.String(), "the unroll ran out of time")
+		if res.err != nil {
+			res.err = limitError{limitTimeout, fmt.Sprintf("The unroll took longer than the limit of %s: %v", limits.Timeout, res.err)}
+		}
+	}
+	if err := budget.rejected(); err != nil {
+		res.err = err
+	}
+	return res, budget.uuids()
+}
+
+// writeMemoized writes the response memoized for the input, if there is one.
+func (hh *Handler) writeMemoized(w http.ResponseWriter, r *http.Request, tid string, uuid string, key string) bool {
+	o, found := hh.Outputs.get(key)
+	if key != "" {
+		outputCacheLookupsTotal.WithLabelValues(strconv.FormatBool(found)).Inc()
+	}
+	if !found {
+		return false
+	}
+	if o.report != "" {
+		w.Header().Set(expansionReportHeader, o.report)
+	}
+	writeOutput(w, r, tid, uuid, o)
+	return true
+}
+
+// writeUnrolled writes the unrolled content, compacted with its keys sorted, with its ETag, and memoizes
+// the response unless it may expand more when the article is unrolled again.
+func (hh *Handler) writeUnrolled(w http.ResponseWriter, r *http.Request, tid string, uuid string, key string, res UnrollResult, expanded map[string]bool) {
+	jsonRes, err := res.uc.MarshalJSON()
+	if err != nil {
+		handleError(r, tid, uuid, w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeExpansionReport(w, tid, uuid, res.report)
+	o := unrolledOutput{key: key, body: jsonRes, etag: outputETag(jsonRes), report: w.Header().Get(expansionReportHeader), uuids: expanded}
+	if !res.report.degraded() {
+		hh.Outputs.set(o)
+	}
+	writeOutput(w, r, tid, uuid, o)
+}
+
+// writeOutput writes the unrolled response, or a 304 if the request has its ETag.
+func writeOutput(w http.ResponseWriter, r *http.Request, tid string, uuid string, o unrolledOutput) {
+	w.Header().Set("ETag", o.etag)
+	if notModified(r, o.etag) {
+		logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusNotModified, uuid, "not modified")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusOK, uuid, "success")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Write(o.body)
 }
 
 func handleError(r *http.Request, tid string, uuid string, w http.ResponseWriter, err error, statusCode int) {
 	var errMsg string
-	if statusCode >= 400 && statusCode < 500 {
+	if _, ok := errors.Cause(err).(limitError); ok {
+		errMsg = fmt.Sprintf("Error expanding content, the limits were exceeded: %s", err.Error())
+		logger.TransactionFinishedEvent(r.RequestURI, tid, statusCode, uuid, err.Error())
+		if statusCode == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "1")
+		}
+	} else if statusCode >= 400 && statusCode < 500 {
 		errMsg = fmt.Sprintf("Error expanding content, supplied UUID is invalid: %s", err.Error())
-		logger.Errorf(tid, errMsg)
+		logger.Errorf(tid, "%s", errMsg)
 	} else if statusCode >= 500 {
 		errMsg = fmt.Sprintf("Error expanding content for: %v: %v", uuid, err.Error())
 		logger.TransactionFinishedEvent(r.RequestURI, tid, statusCode, uuid, err.Error())
@@ -195,17 +321,42 @@ func handleError(r *http.Request, tid string, uuid string, w http.ResponseWriter
 	w.Write([]byte(errMsg))
 }
 
-func validateContent(article Content) bool {
-	_, hasMainImage := article[mainImage]
-	_, hasBody := article[bodyXML]
-	_, hasAltImg := article[altImages].(map[string]interface{})
+// writeErrorMessage writes the message of an error as JSON.
+func writeErrorMessage(w http.ResponseWriter, status int, msg string) {
+	body, _ := json.Marshal(ErrorMessage{Message: msg})
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeExpansionReport(w http.ResponseWriter, tid string, uuid string, report *ExpansionReport) {
+	if report.isEmpty() {
+		return
+	}
+
+	for _, p := range report.problems() {
+		logger.Warnf(tid, uuid, "Field %s was not expanded (%s): %s", p.Field, p.Reason, p.Detail)
+	}
+
+	jsonReport, err := json.Marshal(report)
+	if err != nil {
+		logger.Errorf(tid, "Cannot marshal expansion report: %v", err)
+		return
+	}
+	w.Header().Set(expansionReportHeader, string(jsonReport))
+}
+
+func validateContent(article *Article) bool {
+	hasMainImage := article.has(mainImage)
+	hasBody := article.has(bodyXML)
+	hasAltImg := article.AlternativeImages != nil
 
 	return hasMainImage || hasBody || hasAltImg
 }
 
-func validateInternalContent(article Content) bool {
-	_, hasLeadImages := article[leadImages]
-	_, hasBody := article[bodyXML]
+func validateInternalContent(article *Article) bool {
+	hasLeadImages := article.has(leadImages)
+	hasBody := article.has(bodyXML)
 
 	return hasLeadImages || hasBody
 }
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -44,16 +45,16 @@ func (cu *ContentUnrollerMock) UnrollInternalContentPreview(req UnrollEvent) Unr
 func TestGetContentReturns200(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollContent: func(req UnrollEvent) UnrollResult {
-			var r Content
+			var r Article
 			fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-response.json")
 			assert.NoError(t, err, "Cannot read resources test file")
 			err = json.Unmarshal(fileBytes, &r)
 			assert.NoError(t, err, "Cannot build json body")
-			return UnrollResult{r, nil}
+			return UnrollResult{&r, nil, nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
@@ -73,7 +74,7 @@ func TestGetContentReturns200(t *testing.T) {
 }
 
 func TestGetContent_UnrollEventError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/content", strings.NewReader("sample body"))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -86,7 +87,7 @@ func TestGetContent_UnrollEventError(t *testing.T) {
 }
 
 func TestGetContent_UnrollEventError_MissingID(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/content", strings.NewReader(invalidBodyMissingID))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -99,7 +100,7 @@ func TestGetContent_UnrollEventError_MissingID(t *testing.T) {
 }
 
 func TestGetContent_ValidationError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/content", strings.NewReader(InvalidBodyRequest))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -114,11 +115,11 @@ func TestGetContent_ValidationError(t *testing.T) {
 func TestGetContent_UnrollingError(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollContent: func(req UnrollEvent) UnrollResult {
-			return UnrollResult{nil, errors.New("Error while unrolling content")}
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
@@ -135,16 +136,16 @@ func TestGetContent_UnrollingError(t *testing.T) {
 func TestGetInternalContentReturns200(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollInternalContent: func(req UnrollEvent) UnrollResult {
-			var r Content
+			var r Article
 			fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response.json")
 			assert.NoError(t, err, "Cannot read test file")
 			err = json.Unmarshal(fileBytes, &r)
 			assert.NoError(t, err, "Cannot build json body")
-			return UnrollResult{r, nil}
+			return UnrollResult{&r, nil, nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", bytes.NewReader(body))
@@ -162,7 +163,7 @@ func TestGetInternalContentReturns200(t *testing.T) {
 }
 
 func TestGetInternalContent_UnrollEventError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader("sample body"))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -175,7 +176,7 @@ func TestGetInternalContent_UnrollEventError(t *testing.T) {
 }
 
 func TestGetInternalContent_UnrollEventError_MissingID(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader(invalidBodyMissingID))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -188,7 +189,7 @@ func TestGetInternalContent_UnrollEventError_MissingID(t *testing.T) {
 }
 
 func TestGetInternalContent_ValidationError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader(InvalidBodyRequest))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -203,11 +204,11 @@ func TestGetInternalContent_ValidationError(t *testing.T) {
 func TestGetInternalContent_UnrollingError(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollInternalContent: func(req UnrollEvent) UnrollResult {
-			return UnrollResult{nil, errors.New("Error while unrolling content")}
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent", bytes.NewReader(body))
@@ -224,16 +225,16 @@ func TestGetInternalContent_UnrollingError(t *testing.T) {
 func TestGetContentPreviewReturns200(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollContentPreview: func(req UnrollEvent) UnrollResult {
-			var r Content
+			var r Article
 			fileBytes, err := ioutil.ReadFile("../test-resources/contentpreview-valid-response.json")
 			assert.NoError(t, err, "Cannot read resources test file")
 			err = json.Unmarshal(fileBytes, &r)
 			assert.NoError(t, err, "Cannot build json body")
-			return UnrollResult{r, nil}
+			return UnrollResult{&r, nil, nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/content-preview", bytes.NewReader(body))
@@ -253,7 +254,7 @@ func TestGetContentPreviewReturns200(t *testing.T) {
 }
 
 func TestGetContentPreview_UnrollEventError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/content-preview", strings.NewReader("sample body"))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -266,7 +267,7 @@ func TestGetContentPreview_UnrollEventError(t *testing.T) {
 }
 
 func TestGetContentPreview_UnrollEventError_MissingID(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/content-preview", strings.NewReader(invalidBodyMissingID))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -279,7 +280,7 @@ func TestGetContentPreview_UnrollEventError_MissingID(t *testing.T) {
 }
 
 func TestGetContentPreview_ValidationError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/content-preview", strings.NewReader(InvalidBodyRequest))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -294,11 +295,11 @@ func TestGetContentPreview_ValidationError(t *testing.T) {
 func TestGetContentPreview_UnrollingError(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollContentPreview: func(req UnrollEvent) UnrollResult {
-			return UnrollResult{nil, errors.New("Error while unrolling content")}
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/content-preview", bytes.NewReader(body))
@@ -315,16 +316,16 @@ func TestGetContentPreview_UnrollingError(t *testing.T) {
 func TestGetInternalContentPreviewReturns200(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollInternalContentPreview: func(req UnrollEvent) UnrollResult {
-			var r Content
+			var r Article
 			fileBytes, err := ioutil.ReadFile("../test-resources/internalcontentpreview-valid-response.json")
 			assert.NoError(t, err, "Cannot read test file")
 			err = json.Unmarshal(fileBytes, &r)
 			assert.NoError(t, err, "Cannot build json body")
-			return UnrollResult{r, nil}
+			return UnrollResult{&r, nil, nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent-preview", bytes.NewReader(body))
@@ -344,7 +345,7 @@ func TestGetInternalContentPreviewReturns200(t *testing.T) {
 }
 
 func TestGetInternalContentPreview_UnrollEventError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent-preview", strings.NewReader("sample body"))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -357,7 +358,7 @@ func TestGetInternalContentPreview_UnrollEventError(t *testing.T) {
 }
 
 func TestGetInternalContentPreview_UnrollEventError_MissingID(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent-preview", strings.NewReader(invalidBodyMissingID))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -370,7 +371,7 @@ func TestGetInternalContentPreview_UnrollEventError_MissingID(t *testing.T) {
 }
 
 func TestGetInternalContentPreview_ValidationError(t *testing.T) {
-	h := Handler{nil}
+	h := Handler{Service: nil}
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent-preview", strings.NewReader(InvalidBodyRequest))
 	assert.NoError(t, err, "Cannot create request necessary for test")
 
@@ -385,11 +386,11 @@ func TestGetInternalContentPreview_ValidationError(t *testing.T) {
 func TestGetInternalContentPreview_UnrollingError(t *testing.T) {
 	cu := ContentUnrollerMock{
 		mockUnrollInternalContentPreview: func(req UnrollEvent) UnrollResult {
-			return UnrollResult{nil, errors.New("Error while unrolling content")}
+			return UnrollResult{nil, errors.New("Error while unrolling content"), nil}
 		},
 	}
 
-	h := Handler{&cu}
+	h := Handler{Service: &cu}
 	body, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
 	req, err := http.NewRequest(http.MethodPost, "/internalcontent-preview", bytes.NewReader(body))
@@ -402,3 +403,219 @@ func TestGetInternalContentPreview_UnrollingError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	assert.Contains(t, string(rr.Body.Bytes()), "Error while unrolling content")
 }
+
+func TestGetContent_ExpansionReportHeader(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			report := newExpansionReport()
+			report.add(mainImage, reasonMissingID, "")
+			return UnrollResult{req.c, nil, report}
+		},
+	}
+
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(h.GetContent)
+
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"problems":[{"field":"mainImage","reason":"missing-id"}]}`, rr.Header().Get(expansionReportHeader))
+}
+
+func TestGetInternalContent_InvalidBodyDoesNotReachUnroller(t *testing.T) {
+	h := Handler{Service: nil}
+	req, err := http.NewRequest(http.MethodPost, "/internalcontent", strings.NewReader("sample body"))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(h.GetInternalContent)
+
+	assert.NotPanics(t, func() { handler.ServeHTTP(rr, req) })
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetContent_ETagAndNotModified(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, nil}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	rr := httptest.NewRecorder()
+	h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	var compact bytes.Buffer
+	assert.NoError(t, json.Compact(&compact, rr.Body.Bytes()))
+	assert.Equal(t, compact.String(), rr.Body.String(), "The response should be compact JSON")
+
+	req := httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	h.GetContent(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, etag, rr.Header().Get("ETag"))
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestGetContent_OutputsAreMemoized(t *testing.T) {
+	unrolls := 0
+	var problem string
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			unrolls++
+			report := newExpansionReport()
+			if problem != "" {
+				report.add(mainImage, problem, "")
+			}
+			return UnrollResult{req.c, nil, report}
+		},
+	}
+	h := Handler{Service: &cu, Outputs: NewOutputCache(OutputCacheConfig{Enabled: true})}
+	post := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr
+	}
+
+	first := post(`{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","bodyXML":"<body></body>","title":"t"}`)
+	second := post(`{ "title": "t", "bodyXML": "<body></body>", "id": "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76" }`)
+	assert.Equal(t, 1, unrolls, "The same article should only be unrolled once")
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, first.Header().Get("ETag"), second.Header().Get("ETag"))
+
+	problem = reasonUpstreamError
+	post(`{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","bodyXML":"<body>other</body>"}`)
+	rr := post(`{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","bodyXML":"<body>other</body>"}`)
+	assert.Equal(t, 3, unrolls, "A degraded output shouldn't be memoized")
+	assert.Contains(t, rr.Header().Get(expansionReportHeader), reasonUpstreamError)
+}
+
+func TestGetContent_OutputIsTheSameWhateverTheLayoutOfTheArticle(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			return UnrollResult{req.c, nil, newExpansionReport()}
+		},
+	}
+	layouts := []string{
+		`{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","standout":{"scoop":false,"editorsChoice":true},"annotations":[{"predicate":"about","id":"x"}],"bodyXML":"<body></body>","title":"<b>t</b>"}`,
+		"{\n  \"title\": \"\\u003cb>t</b>\",\n  \"annotations\": [ { \"id\": \"x\", \"predicate\": \"about\" } ],\n  \"standout\": { \"editorsChoice\": true, \"scoop\": false },\n  \"bodyXML\": \"<body></body>\",\n  \"id\": \"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76\"\n}",
+	}
+	post := func(h Handler, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr
+	}
+
+	expected := post(Handler{Service: &cu}, layouts[0])
+	assert.Equal(t, `{"annotations":[{"id":"x","predicate":"about"}],"bodyXML":"<body></body>","id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","standout":{"editorsChoice":true,"scoop":false},"title":"<b>t</b>"}`, expected.Body.String())
+	for i, first := range layouts {
+		// the memoized output is the one of the layout posted first
+		memoizing := Handler{Service: &cu, Outputs: NewOutputCache(OutputCacheConfig{Enabled: true})}
+		post(memoizing, first)
+		for _, body := range append([]string{layouts[1-i]}, layouts...) {
+			for _, h := range []Handler{{Service: &cu}, memoizing} {
+				rr := post(h, body)
+				assert.Equal(t, expected.Body.String(), rr.Body.String())
+				assert.Equal(t, expected.Header().Get("ETag"), rr.Header().Get("ETag"))
+			}
+		}
+	}
+}
+
+func TestGetContent_MemoizedOutputsArePurgedWithTheirModels(t *testing.T) {
+	const imageUUID = "639cd952-149f-11e7-2ea7-a07ecd9ac73f"
+	unrolls := 0
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			unrolls++
+			budgetOf(req.ctx).take(req.ctx, []string{imageUUID})
+			return UnrollResult{req.c, nil, newExpansionReport()}
+		},
+	}
+	h := Handler{Service: &cu, Outputs: NewOutputCache(OutputCacheConfig{Enabled: true})}
+	post := func() {
+		rr := httptest.NewRecorder()
+		h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(`{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","bodyXML":"<body></body>"}`)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+
+	post()
+	post()
+	assert.Equal(t, 1, unrolls)
+	assert.Equal(t, 1, h.Outputs.purge(imageUUID))
+	post()
+	assert.Equal(t, 2, unrolls, "The article should be unrolled again once the model it expanded is purged")
+}
+
+func TestGetContent_BodyLargerThanTheLimitIsRejected(t *testing.T) {
+	h := Handler{Service: nil, Limits: NewUnrollLimits(LimitsConfig{MaxBodySize: 16})}
+
+	rr := httptest.NewRecorder()
+	h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", strings.NewReader(`{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Contains(t, rr.Body.String(), "limit of 16 bytes")
+}
+
+func TestGetContent_TooManyEmbedsAreRejected(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			budgetOf(req.ctx).checkEmbeds(3)
+			return UnrollResult{req.c, nil, newExpansionReport()}
+		},
+	}
+	h := Handler{Service: &cu, Limits: NewUnrollLimits(LimitsConfig{MaxEmbeds: 2})}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	rr := httptest.NewRecorder()
+	h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "3 embeds, more than the limit of 2")
+}
+
+func TestGetContentPreview_TimeoutIsReported(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContentPreview: func(req UnrollEvent) UnrollResult {
+			<-req.ctx.Done()
+			return UnrollResult{req.c, nil, newExpansionReport()}
+		},
+	}
+	h := Handler{Service: &cu, Limits: NewUnrollLimits(LimitsConfig{Timeout: 10 * time.Millisecond})}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	rr := httptest.NewRecorder()
+	h.GetContentPreview(rr, httptest.NewRequest(http.MethodPost, "/content-preview", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"limits":[{"limit":"timeout","max":"10ms","detail":"the unroll ran out of time"}]}`, rr.Header().Get(expansionReportHeader))
+}
+
+func TestGetContent_TimeoutFailingTheUnrollIsUnavailable(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			<-req.ctx.Done()
+			return UnrollResult{req.c, req.ctx.Err(), newExpansionReport()}
+		},
+	}
+	h := Handler{Service: &cu, Limits: NewUnrollLimits(LimitsConfig{Timeout: 10 * time.Millisecond})}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	rr := httptest.NewRecorder()
+	h.GetContent(rr, httptest.NewRequest(http.MethodPost, "/content", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), "limit of 10ms")
+}
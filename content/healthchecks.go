@@ -1,30 +1,252 @@
 package content
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/service-status-go/gtg"
 	"github.com/pkg/errors"
 )
 
+const (
+	HealthProbeHealth = "health"
+	HealthProbeGTG    = "gtg"
+)
+
+// HealthchecksConfig configures the checks of the upstream apps.
+type HealthchecksConfig struct {
+	// Interval of the background checks, whose results are served by the healthchecks and the GTG.
+	// The apps are checked on every probe when it is 0.
+	Interval time.Duration `yaml:"interval"`
+	// Probe is the endpoint of the apps which is checked: health (/__health) or gtg (/__gtg)
+	Probe string `yaml:"probe"`
+	// SlowThreshold reports the hosts responding slower than it as degraded, they are still healthy
+	SlowThreshold time.Duration `yaml:"slowThreshold"`
+}
+
+// Path returns the path of the endpoint checked.
+func (c HealthchecksConfig) Path() string {
+	if c.Probe == HealthProbeGTG {
+		return "/__gtg"
+	}
+	return "/__health"
+}
+
+func (c HealthchecksConfig) validate() error {
+	if c.Probe != "" && c.Probe != HealthProbeHealth && c.Probe != HealthProbeGTG {
+		return errors.Errorf("unknown healthchecks.probe %q, it should be %s or %s", c.Probe, HealthProbeHealth, HealthProbeGTG)
+	}
+	if c.Interval < 0 || c.SlowThreshold < 0 {
+		return errors.New("healthchecks.interval and healthchecks.slowThreshold can't be negative")
+	}
+	return nil
+}
+
+// healthResult is the result of the last background check of an app.
+type healthResult struct {
+	msg       string
+	err       error
+	checkedAt time.Time
+	latency   time.Duration
+}
+
+// result returns the message and error of the check, with when it was made and how long it took.
+func (r healthResult) result() (string, error) {
+	msg := fmt.Sprintf("%s Last checked at %s in %v.", r.msg, r.checkedAt.UTC().Format(time.RFC3339), r.latency.Round(time.Millisecond))
+	if r.err != nil {
+		return msg, errors.New(msg)
+	}
+	return msg, nil
+}
+
 type ServiceConfig struct {
 	ContentStoreAppName        string
 	ContentStoreAppHealthURI   string
 	ContentPreviewAppName      string
 	ContentPreviewAppHealthURI string
-	HTTPClient                 *http.Client
+	// ContentStoreAppHealthURIs and ContentPreviewAppHealthURIs replace the single health URIs
+	// when the apps have several hosts, which are checked one by one
+	ContentStoreAppHealthURIs   []string
+	ContentPreviewAppHealthURIs []string
+	ContentStoreAuth            AuthConfig
+	ContentPreviewAuth          AuthConfig
+	HTTPClient                  *http.Client
+	// HealthcheckTimeout limits the requests to the health endpoints of the upstream apps, on top of the timeout of the client
+	HealthcheckTimeout time.Duration
+	// HealthcheckSlowThreshold reports the hosts responding slower than it as degraded
+	HealthcheckSlowThreshold time.Duration
+	mu                       sync.RWMutex
+	shuttingDown             int32
+	// results has the last background check of each app, by app name
+	results map[string]healthResult
+}
+
+// UpdateUpstreams applies new health endpoints, credentials, timeout and slow threshold to the following checks.
+func (sc *ServiceConfig) UpdateUpstreams(contentStoreHealthURIs []string, contentPreviewHealthURIs []string, contentStoreAuth AuthConfig, contentPreviewAuth AuthConfig, timeout time.Duration, slowThreshold time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.ContentStoreAppHealthURIs = contentStoreHealthURIs
+	sc.ContentPreviewAppHealthURIs = contentPreviewHealthURIs
+	sc.ContentStoreAuth = contentStoreAuth
+	sc.ContentPreviewAuth = contentPreviewAuth
+	sc.HealthcheckTimeout = timeout
+	sc.HealthcheckSlowThreshold = slowThreshold
+}
+
+// PollUpstreams checks the upstream apps every interval until the context is done, the healthchecks
+// and the GTG then serve the results of the last checks instead of checking the apps themselves.
+func (sc *ServiceConfig) PollUpstreams(ctx context.Context, interval time.Duration, preview bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sc.pollApp(sc.ContentStoreAppName, sc.doCheckContentStore)
+		if preview {
+			sc.pollApp(sc.ContentPreviewAppName, sc.doCheckContentPreview)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sc *ServiceConfig) pollApp(appName string, check func() (string, error)) {
+	start := time.Now()
+	msg, err := check()
+	result := healthResult{msg: msg, err: err, checkedAt: start, latency: time.Since(start)}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.results == nil {
+		sc.results = make(map[string]healthResult)
+	}
+	sc.results[appName] = result
+}
+
+// lastResult returns the result of the last background check of the app, if it is polled.
+func (sc *ServiceConfig) lastResult(appName string) (healthResult, bool) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	r, found := sc.results[appName]
+	return r, found
+}
+
+func (sc *ServiceConfig) checkContentStore() (string, error) {
+	if r, found := sc.lastResult(sc.ContentStoreAppName); found {
+		return r.result()
+	}
+	return sc.doCheckContentStore()
+}
+
+func (sc *ServiceConfig) checkContentPreview() (string, error) {
+	if r, found := sc.lastResult(sc.ContentPreviewAppName); found {
+		return r.result()
+	}
+	return sc.doCheckContentPreview()
+}
+
+func (sc *ServiceConfig) doCheckContentStore() (string, error) {
+	sc.mu.RLock()
+	healthURIs, auth := healthURIs(sc.ContentStoreAppHealthURI, sc.ContentStoreAppHealthURIs), sc.ContentStoreAuth
+	timeout, slow := sc.HealthcheckTimeout, sc.HealthcheckSlowThreshold
+	sc.mu.RUnlock()
+	return sc.checkHosts(sc.ContentStoreAppName, healthURIs, auth, timeout, slow)
+}
+
+func (sc *ServiceConfig) doCheckContentPreview() (string, error) {
+	sc.mu.RLock()
+	healthURIs, auth := healthURIs(sc.ContentPreviewAppHealthURI, sc.ContentPreviewAppHealthURIs), sc.ContentPreviewAuth
+	timeout, slow := sc.HealthcheckTimeout, sc.HealthcheckSlowThreshold
+	sc.mu.RUnlock()
+	return sc.checkHosts(sc.ContentPreviewAppName, healthURIs, auth, timeout, slow)
+}
+
+func healthURIs(single string, multiple []string) []string {
+	if len(multiple) > 0 {
+		return multiple
+	}
+	return []string{single}
+}
+
+// checkHosts checks every host of an app, reporting each of them. The app is available as long as one of its hosts is.
+func (sc *ServiceConfig) checkHosts(serviceName string, healthURIs []string, auth AuthConfig, timeout time.Duration, slow time.Duration) (string, error) {
+	if len(healthURIs) == 1 {
+		latency, err := sc.checkServiceAvailability(serviceName, healthURIs[0], auth, timeout)
+		if err != nil {
+			return "Error", err
+		}
+		return hostStatus(latency, slow), nil
+	}
+
+	errs := make([]error, len(healthURIs))
+	latencies := make([]time.Duration, len(healthURIs))
+	var wg sync.WaitGroup
+	for i, uri := range healthURIs {
+		wg.Add(1)
+		go func(i int, uri string) {
+			defer wg.Done()
+			latencies[i], errs[i] = sc.checkServiceAvailability(serviceName, uri, auth, timeout)
+		}(i, uri)
+	}
+	wg.Wait()
+
+	var healthy int
+	hosts := make([]string, len(healthURIs))
+	for i, uri := range healthURIs {
+		if errs[i] != nil {
+			hosts[i] = fmt.Sprintf("%s: %v", uri, errs[i])
+			continue
+		}
+		healthy++
+		hosts[i] = uri + ": " + hostStatus(latencies[i], slow)
+	}
+	msg := fmt.Sprintf("%d of %d %s hosts healthy. %s", healthy, len(healthURIs), serviceName, strings.Join(hosts, "; "))
+	if healthy == 0 {
+		return msg, errors.New(msg)
+	}
+	return msg, nil
+}
+
+// hostStatus reports a host which responded slower than the threshold as degraded.
+func hostStatus(latency time.Duration, slow time.Duration) string {
+	if slow > 0 && latency > slow {
+		return fmt.Sprintf("Degraded, responded in %v", latency.Round(time.Millisecond))
+	}
+	return "Ok"
+}
+
+// StartShutdown makes the GTG checks fail, so that no new requests are routed to the service.
+func (sc *ServiceConfig) StartShutdown() {
+	atomic.StoreInt32(&sc.shuttingDown, 1)
+}
+
+func (sc *ServiceConfig) shutdownCheck() gtg.Status {
+	if atomic.LoadInt32(&sc.shuttingDown) == 1 {
+		return gtg.Status{GoodToGo: false, Message: "The service is shutting down"}
+	}
+	return gtg.Status{GoodToGo: true}
 }
 
 func (sc *ServiceConfig) GtgCheck() gtg.Status {
 	contentStoreCheck := func() gtg.Status {
-		msg, err := sc.checkServiceAvailability(sc.ContentStoreAppName, sc.ContentStoreAppHealthURI)
+		msg, err := sc.checkContentStore()
 		if err != nil {
 			return gtg.Status{GoodToGo: false, Message: msg}
 		}
 		return gtg.Status{GoodToGo: true}
 	}
+	if s := sc.shutdownCheck(); !s.GoodToGo {
+		return s
+	}
 	return gtg.FailFastParallelCheck([]gtg.StatusChecker{
 		contentStoreCheck,
 	})()
@@ -32,13 +254,16 @@ func (sc *ServiceConfig) GtgCheck() gtg.Status {
 
 func (sc *ServiceConfig) GtgCheckPreview() gtg.Status {
 	contentPreviewCheck := func() gtg.Status {
-		msg, err := sc.checkServiceAvailability(sc.ContentPreviewAppName, sc.ContentPreviewAppHealthURI)
+		msg, err := sc.checkContentPreview()
 		if err != nil {
 			return gtg.Status{GoodToGo: false, Message: msg}
 		}
 
 		return gtg.Status{GoodToGo: true}
 	}
+	if s := sc.shutdownCheck(); !s.GoodToGo {
+		return s
+	}
 	return gtg.FailFastParallelCheck([]gtg.StatusChecker{
 		contentPreviewCheck,
 	})()
@@ -52,9 +277,7 @@ func (sc *ServiceConfig) ContentStoreCheck() fthealth.Check {
 		BusinessImpact:   "Unrolled images and dynamic content won't be available",
 		TechnicalSummary: fmt.Sprintf(`Cannot connect to %v.`, sc.ContentStoreAppName),
 		PanicGuide:       "https://dewey.in.ft.com/runbooks/contentreadapi",
-		Checker: func() (string, error) {
-			return sc.checkServiceAvailability(sc.ContentStoreAppName, sc.ContentStoreAppHealthURI)
-		},
+		Checker:          sc.checkContentStore,
 	}
 }
 
@@ -66,20 +289,33 @@ func (sc *ServiceConfig) ContentPreviewCheck() fthealth.Check {
 		BusinessImpact:   "Unrolled dynamic content won't be available",
 		TechnicalSummary: fmt.Sprintf(`Cannot connect to %v.`, sc.ContentPreviewAppName),
 		PanicGuide:       "https://dewey.in.ft.com/runbooks/content-preview",
-		Checker: func() (string, error) {
-			return sc.checkServiceAvailability(sc.ContentPreviewAppName, sc.ContentPreviewAppHealthURI)
-		},
+		Checker:          sc.checkContentPreview,
 	}
 }
 
-func (sc *ServiceConfig) checkServiceAvailability(serviceName string, healthURI string) (string, error) {
+// checkServiceAvailability returns how long a host took to respond with OK.
+func (sc *ServiceConfig) checkServiceAvailability(serviceName string, healthURI string, auth AuthConfig, timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := withTimeout(context.Background(), timeout)
+	defer cancel()
 	req, err := http.NewRequest(http.MethodGet, healthURI, nil)
-	resp, err := sc.HTTPClient.Do(req)
+	if err == nil {
+		err = auth.authenticate(req)
+	}
+	if err != nil {
+		return 0, errors.Errorf("%s service check cannot be made: %v", serviceName, err)
+	}
+
+	start := time.Now()
+	resp, err := sc.HTTPClient.Do(req.WithContext(ctx))
 	if err != nil {
-		return "Error", errors.Errorf("%s service is unreachable: %v", serviceName, err)
+		return 0, errors.Errorf("%s service is unreachable: %v", serviceName, err)
 	}
+	// the body is read so that the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	latency := time.Since(start)
 	if resp.StatusCode != http.StatusOK {
-		return "Error", errors.Errorf("%s service is not responding with OK. Status=%d", serviceName, resp.StatusCode)
+		return latency, errors.Errorf("%s service is not responding with OK. Status=%d", serviceName, resp.StatusCode)
 	}
-	return "Ok", nil
+	return latency, nil
 }
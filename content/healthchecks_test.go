@@ -1,10 +1,14 @@
 package content
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/Financial-Times/service-status-go/gtg"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -100,6 +104,22 @@ func TestServiceConfig_GtgCheck(t *testing.T) {
 	assert.Equal(t, true, status.GoodToGo)
 }
 
+func TestServiceConfig_GtgCheck_ShuttingDown(t *testing.T) {
+	contentStoreTestService := startFunctionalService()
+	defer contentStoreTestService.Close()
+
+	contentPreviewTestService := startFunctionalService()
+	defer contentPreviewTestService.Close()
+
+	sc := initTestServiceConfig(contentStoreTestService.URL, contentPreviewTestService.URL)
+	sc.StartShutdown()
+
+	for _, status := range []gtg.Status{sc.GtgCheck(), sc.GtgCheckPreview()} {
+		assert.False(t, status.GoodToGo)
+		assert.Equal(t, "The service is shutting down", status.Message)
+	}
+}
+
 func TestServiceConfig_GtgCheck_NotGtg(t *testing.T) {
 	contentStoreTestService := startNotFunctionalService()
 	defer contentStoreTestService.Close()
@@ -115,3 +135,74 @@ func TestServiceConfig_GtgCheck_NotGtg(t *testing.T) {
 	status := sc.GtgCheck()
 	assert.Equal(t, false, status.GoodToGo)
 }
+
+func TestServiceConfig_PollUpstreams(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	sc := initTestServiceConfig(ts.URL, ts.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sc.PollUpstreams(ctx, time.Hour, false)
+	for {
+		if _, found := sc.lastResult(sc.ContentStoreAppName); found {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		out, err := sc.ContentStoreCheck().Checker()
+		assert.NoError(t, err)
+		assert.Regexp(t, `^Ok Last checked at \S+ in \S+\.$`, out)
+		assert.True(t, sc.GtgCheck().GoodToGo)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "The probes should be served the result of the background check")
+
+	out, _ := sc.ContentPreviewCheck().Checker()
+	assert.Equal(t, "Ok", out, "The apps which aren't polled should be checked on every probe")
+}
+
+func TestServiceConfig_CachedFailure(t *testing.T) {
+	ts := startNotFunctionalService()
+	defer ts.Close()
+	sc := initTestServiceConfig(ts.URL, "")
+	sc.pollApp(sc.ContentStoreAppName, sc.doCheckContentStore)
+
+	out, err := sc.ContentStoreCheck().Checker()
+	assert.Error(t, err)
+	assert.Contains(t, out, "Last checked at")
+	assert.False(t, sc.GtgCheck().GoodToGo)
+}
+
+func TestServiceConfig_SlowHostsAreDegraded(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer slow.Close()
+	fast := startFunctionalService()
+	defer fast.Close()
+	sc := initTestServiceConfig(slow.URL, "")
+	sc.HealthcheckSlowThreshold = 10 * time.Millisecond
+
+	out, err := sc.ContentStoreCheck().Checker()
+	assert.NoError(t, err, "A slow host is still healthy")
+	assert.Contains(t, out, "Degraded, responded in")
+
+	sc.ContentStoreAppHealthURIs = []string{slow.URL, fast.URL}
+	out, err = sc.ContentStoreCheck().Checker()
+	assert.NoError(t, err)
+	assert.Contains(t, out, slow.URL+": Degraded, responded in")
+	assert.Contains(t, out, fast.URL+": Ok")
+}
+
+func TestHealthchecksConfig_Path(t *testing.T) {
+	assert.Equal(t, "/__health", HealthchecksConfig{}.Path())
+	assert.Equal(t, "/__gtg", HealthchecksConfig{Probe: HealthProbeGTG}.Path())
+	assert.Error(t, HealthchecksConfig{Probe: "ping"}.validate())
+}
@@ -0,0 +1,306 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// clientIDHeader names the client of a signed request, and is sent to the upstream apps
+	clientIDHeader  = "X-Client-Id"
+	timestampHeader = "X-Request-Timestamp"
+	nonceHeader     = "X-Request-Nonce"
+	signatureHeader = "X-Request-Signature"
+
+	anonymousClient     = "anonymous"
+	defaultMaxClockSkew = 5 * time.Minute
+	maxNonceLength      = 128
+	// nonceSweepInterval is how often the nonces which can't be replayed anymore are forgotten
+	nonceSweepInterval = time.Minute
+
+	authFailureMissing          = "missing-credentials"
+	authFailureInvalidKey       = "invalid-key"
+	authFailureInvalidSignature = "invalid-signature"
+	authFailureExpired          = "expired-signature"
+	authFailureReplayed         = "replayed-signature"
+)
+
+// unrollEndpoints are the endpoints which can be protected, named like their paths.
+var unrollEndpoints = []string{contentEndpoint, internalContentEndpoint, contentPreviewEndpoint, internalContentPreviewEndpoint}
+
+// defaultProtectedEndpoints return unpublished content, so they need credentials unless configured otherwise.
+var defaultProtectedEndpoints = []string{contentPreviewEndpoint, internalContentPreviewEndpoint}
+
+// InboundAuthConfig configures the credentials of the clients. It is enabled when there are keys.
+type InboundAuthConfig struct {
+	// Header of the API key, X-Api-Key by default
+	Header string      `yaml:"header"`
+	Keys   []ClientKey `yaml:"keys"`
+	// Protect lists the endpoints which need credentials, the preview endpoints by default.
+	// The other endpoints identify the clients which send credentials.
+	Protect []string `yaml:"protect"`
+	// MaxClockSkew is how old or early the timestamp of a signed request can be, 5m by default
+	MaxClockSkew time.Duration `yaml:"maxClockSkew"`
+}
+
+// ClientKey is the key of a client, sent as an API key or used to sign its requests.
+// A client can have several keys while they are rotated.
+type ClientKey struct {
+	Client string `yaml:"client"`
+	Key    Secret `yaml:"key"`
+}
+
+func (c InboundAuthConfig) enabled() bool {
+	return len(c.Keys) > 0
+}
+
+func (c InboundAuthConfig) protects(endpoint string) bool {
+	if !c.enabled() {
+		return false
+	}
+	if c.Protect == nil {
+		return contains(defaultProtectedEndpoints, endpoint)
+	}
+	return contains(c.Protect, endpoint)
+}
+
+func (c InboundAuthConfig) validate() error {
+	for i, k := range c.Keys {
+		if k.Client == "" || k.Key == "" {
+			return errors.Errorf("inboundAuth.keys[%d] needs a client and a key", i)
+		}
+		if k.Client == anonymousClient {
+			return errors.Errorf("inboundAuth.keys[%d].client can't be %q", i, anonymousClient)
+		}
+	}
+	for _, e := range c.Protect {
+		if !contains(unrollEndpoints, e) {
+			return errors.Errorf("unknown endpoint %q in inboundAuth.protect, it should be one of: %s", e, strings.Join(unrollEndpoints, ", "))
+		}
+	}
+	if len(c.Protect) > 0 && !c.enabled() {
+		return errors.New("inboundAuth.keys is required to protect endpoints")
+	}
+	if c.MaxClockSkew < 0 {
+		return errors.New("inboundAuth.maxClockSkew can't be negative")
+	}
+	return nil
+}
+
+// InboundAuth identifies the clients of the unroll endpoints, from an API key or the HMAC signature
+// of the request, and rejects the requests to the protected endpoints which have no valid credentials.
+type InboundAuth struct {
+	mu     sync.RWMutex
+	config InboundAuthConfig
+	// limits bounds the bodies read to verify their signature
+	limits *UnrollLimits
+	nonces *seenNonces
+	now    func() time.Time
+}
+
+func NewInboundAuth(cfg InboundAuthConfig, limits *UnrollLimits) *InboundAuth {
+	return &InboundAuth{config: cfg, limits: limits, nonces: newSeenNonces(), now: time.Now}
+}
+
+func (a *InboundAuth) UpdateConfig(cfg InboundAuthConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.config = cfg
+}
+
+func (a *InboundAuth) currentConfig() InboundAuthConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config
+}
+
+// Handler is a middleware which adds the client to the request context. The notifications endpoint
+// always needs credentials, so it can't be used without keys. The requests to other endpoints than
+// the unroll ones are passed on as they are.
+func (a *InboundAuth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/")
+		if !contains(unrollEndpoints, endpoint) && endpoint != notificationsEndpoint {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cfg := a.currentConfig()
+		client, reason, err := a.identify(cfg, r)
+		if err == nil && client == "" && (endpoint == notificationsEndpoint || cfg.protects(endpoint)) {
+			reason, err = authFailureMissing, errors.New("Credentials are required")
+		}
+		if status := limitStatus(err, http.StatusUnauthorized); status != http.StatusUnauthorized {
+			writeErrorMessage(w, status, err.Error())
+			return
+		}
+		if err != nil {
+			rejectRequest(w, r, endpoint, reason, err)
+			return
+		}
+		if client == "" {
+			client = anonymousClient
+		}
+
+		sr := newStatusRecorder(w)
+		defer observeClientRequest(client, endpoint, sr)
+		next.ServeHTTP(sr, r.WithContext(withClientID(r.Context(), client)))
+	})
+}
+
+// identify returns the client of the request, or no client if it has no credentials.
+func (a *InboundAuth) identify(cfg InboundAuthConfig, r *http.Request) (string, string, error) {
+	if !cfg.enabled() {
+		return "", "", nil
+	}
+	if r.Header.Get(signatureHeader) != "" {
+		return a.verifySignature(cfg, r)
+	}
+
+	header := cfg.Header
+	if header == "" {
+		header = defaultAPIKeyHeader
+	}
+	key := r.Header.Get(header)
+	if key == "" {
+		return "", "", nil
+	}
+	for _, k := range cfg.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+			return k.Client, "", nil
+		}
+	}
+	return "", authFailureInvalidKey, errors.New("Invalid API key")
+}
+
+// verifySignature checks the signature of the request with the keys of its client.
+func (a *InboundAuth) verifySignature(cfg InboundAuthConfig, r *http.Request) (string, string, error) {
+	client := r.Header.Get(clientIDHeader)
+	timestamp := r.Header.Get(timestampHeader)
+	nonce := r.Header.Get(nonceHeader)
+	signature, err := hex.DecodeString(r.Header.Get(signatureHeader))
+	if client == "" || timestamp == "" || nonce == "" || err != nil {
+		return "", authFailureInvalidSignature, errors.Errorf("A signed request needs the %s, %s, %s and %s headers", clientIDHeader, timestampHeader, nonceHeader, signatureHeader)
+	}
+	if len(nonce) > maxNonceLength {
+		return "", authFailureInvalidSignature, errors.Errorf("The %s is longer than %d characters", nonceHeader, maxNonceLength)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", authFailureInvalidSignature, errors.Errorf("Invalid %s", timestampHeader)
+	}
+	maxSkew := cfg.MaxClockSkew
+	if maxSkew == 0 {
+		maxSkew = defaultMaxClockSkew
+	}
+	now := a.now()
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > maxSkew || skew < -maxSkew {
+		return "", authFailureExpired, errors.New("The request timestamp is too old or too far in the future")
+	}
+
+	body, err := readBodyWithin(r.Body, a.limits.currentConfig().MaxBodySize)
+	if _, tooLarge := err.(limitError); tooLarge {
+		return "", "", err
+	}
+	if err != nil {
+		return "", authFailureInvalidSignature, errors.Wrap(err, "Error reading the request body")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	for _, k := range cfg.Keys {
+		if k.Client != client || !hmac.Equal(signature, SignRequest(k.Key, r.Method, r.URL.RequestURI(), timestamp, nonce, body)) {
+			continue
+		}
+		if !a.nonces.add(client, nonce, time.Unix(seconds, 0).Add(maxSkew), now) {
+			return "", authFailureReplayed, errors.Errorf("The %s was already used", nonceHeader)
+		}
+		return client, "", nil
+	}
+	return "", authFailureInvalidSignature, errors.New("Invalid request signature")
+}
+
+// SignRequest returns the HMAC-SHA256 signature of a request, sent hex encoded in the X-Request-Signature header.
+// It signs the method, the path with the query, the unix timestamp in seconds, the nonce and the SHA-256 of
+// the body, before the body is gzipped.
+func SignRequest(key Secret, method string, requestURI string, timestamp string, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%x", method, requestURI, timestamp, nonce, bodyHash)
+	return mac.Sum(nil)
+}
+
+// seenNonces are the nonces of the signed requests accepted, kept until their timestamp is too old for them
+// to be replayed.
+type seenNonces struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	swept   time.Time
+}
+
+func newSeenNonces() *seenNonces {
+	return &seenNonces{expires: make(map[string]time.Time)}
+}
+
+// add records the nonce of the client until it expires, false if it is already recorded.
+func (s *seenNonces) add(client string, nonce string, expires time.Time, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) >= nonceSweepInterval {
+		for k, e := range s.expires {
+			if now.After(e) {
+				delete(s.expires, k)
+			}
+		}
+		s.swept = now
+	}
+
+	key := client + "\n" + nonce
+	if e, seen := s.expires[key]; seen && !now.After(e) {
+		return false
+	}
+	s.expires[key] = expires
+	return true
+}
+
+func rejectRequest(w http.ResponseWriter, r *http.Request, endpoint string, reason string, err error) {
+	tid := transactionidutils.GetTransactionIDFromRequest(r)
+	logger.log.WithFields(logrus.Fields{"tid": tid, "request_url": r.RequestURI, "reason": reason}).
+		WithError(err).Warn("Request rejected")
+	authFailuresTotal.WithLabelValues(endpoint, reason).Inc()
+
+	msg, _ := json.Marshal(ErrorMessage{Message: err.Error()})
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(msg)
+}
+
+type clientIDKey struct{}
+
+func withClientID(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientIDKey{}, client)
+}
+
+// clientID returns the client of the request, or anonymous if it wasn't identified.
+func clientID(ctx context.Context) string {
+	if client, ok := ctx.Value(clientIDKey{}).(string); ok {
+		return client
+	}
+	return anonymousClient
+}
@@ -0,0 +1,186 @@
+package content
+
+import (
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const signedBodyForTest = `{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76"}`
+
+func inboundAuthForTest(now time.Time) *InboundAuth {
+	a := NewInboundAuth(InboundAuthConfig{Keys: []ClientKey{
+		{Client: "publisher", Key: secretForTest},
+		{Client: "renderer", Key: "renderer-key"},
+	}}, nil)
+	a.now = func() time.Time { return now }
+	return a
+}
+
+var noncesForTest int64
+
+// signedRequestForTest signs the test body with a new nonce, and sends the given body.
+func signedRequestForTest(key Secret, client string, timestamp time.Time, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/content-preview", strings.NewReader(body))
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	nonce := strconv.FormatInt(atomic.AddInt64(&noncesForTest, 1), 10)
+	req.Header.Set(clientIDHeader, client)
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, hex.EncodeToString(SignRequest(key, http.MethodPost, "/content-preview", ts, nonce, []byte(signedBodyForTest))))
+	return req
+}
+
+func TestInboundAuth_Handler(t *testing.T) {
+	now := time.Now()
+	withAPIKey := func(path string, key string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(signedBodyForTest))
+		req.Header.Set("X-Api-Key", key)
+		return req
+	}
+
+	tests := map[string]struct {
+		req            *http.Request
+		expectedStatus int
+		expectedClient string
+	}{
+		"api key":                           {withAPIKey("/content-preview", secretForTest), http.StatusOK, "publisher"},
+		"invalid api key":                   {withAPIKey("/content", "wrong-key"), http.StatusUnauthorized, ""},
+		"no credentials for preview":        {httptest.NewRequest(http.MethodPost, "/content-preview", nil), http.StatusUnauthorized, ""},
+		"no credentials for content":        {httptest.NewRequest(http.MethodPost, "/content", nil), http.StatusOK, anonymousClient},
+		"not an unroll endpoint":            {httptest.NewRequest(http.MethodGet, "/__gtg", nil), http.StatusOK, anonymousClient},
+		"notifications with an api key":     {withAPIKey("/notifications", secretForTest), http.StatusOK, "publisher"},
+		"notifications without credentials": {httptest.NewRequest(http.MethodPost, "/notifications", nil), http.StatusUnauthorized, ""},
+		"signed request":                    {signedRequestForTest("renderer-key", "renderer", now.Add(-time.Minute), signedBodyForTest), http.StatusOK, "renderer"},
+		"signed with the key of another":    {signedRequestForTest(secretForTest, "renderer", now, signedBodyForTest), http.StatusUnauthorized, ""},
+		"signed request with a new body":    {signedRequestForTest("renderer-key", "renderer", now, `{"id":"changed"}`), http.StatusUnauthorized, ""},
+		"signed request with an old stamp":  {signedRequestForTest("renderer-key", "renderer", now.Add(-time.Hour), signedBodyForTest), http.StatusUnauthorized, ""},
+		"signed request from the future":    {signedRequestForTest("renderer-key", "renderer", now.Add(time.Hour), signedBodyForTest), http.StatusUnauthorized, ""},
+		"signed request without its client": {signedRequestForTest("renderer-key", "", now, signedBodyForTest), http.StatusUnauthorized, ""},
+		"signed request without its nonce":  {withoutHeader(signedRequestForTest("renderer-key", "renderer", now, signedBodyForTest), nonceHeader), http.StatusUnauthorized, ""},
+	}
+	for name, test := range tests {
+		var client, body string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client = clientID(r.Context())
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+		})
+		w := httptest.NewRecorder()
+		inboundAuthForTest(now).Handler(next).ServeHTTP(w, test.req)
+
+		assert.Equal(t, test.expectedStatus, w.Code, name)
+		assert.Equal(t, test.expectedClient, client, name)
+		if test.expectedStatus == http.StatusOK && test.req.Method == http.MethodPost && test.req.ContentLength != 0 {
+			assert.Equal(t, signedBodyForTest, body, "%s: the body should still be readable", name)
+		}
+	}
+}
+
+func withoutHeader(req *http.Request, header string) *http.Request {
+	req.Header.Del(header)
+	return req
+}
+
+func TestInboundAuth_ReplayedSignedRequest(t *testing.T) {
+	now := time.Now()
+	a := inboundAuthForTest(now)
+	signed := signedRequestForTest("renderer-key", "renderer", now, signedBodyForTest)
+	replayed := httptest.NewRequest(http.MethodPost, "/content-preview", strings.NewReader(signedBodyForTest))
+	replayed.Header = signed.Header.Clone()
+
+	w := httptest.NewRecorder()
+	a.Handler(http.NotFoundHandler()).ServeHTTP(w, signed)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	a.Handler(http.NotFoundHandler()).ServeHTTP(w, replayed)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "A nonce can't be used twice")
+	assert.Contains(t, w.Body.String(), "already used")
+
+	w = httptest.NewRecorder()
+	a.Handler(http.NotFoundHandler()).ServeHTTP(w, signedRequestForTest("renderer-key", "renderer", now, signedBodyForTest))
+	assert.Equal(t, http.StatusNotFound, w.Code, "Another nonce is accepted")
+}
+
+func TestSeenNonces_ForgetsTheExpiredNonces(t *testing.T) {
+	s := newSeenNonces()
+	now := time.Now()
+	assert.True(t, s.add("renderer", "1", now.Add(time.Minute), now))
+	assert.False(t, s.add("renderer", "1", now.Add(time.Minute), now))
+	assert.True(t, s.add("publisher", "1", now.Add(time.Minute), now), "The nonces are of a client")
+
+	now = now.Add(2 * nonceSweepInterval)
+	assert.True(t, s.add("renderer", "2", now.Add(time.Minute), now))
+	assert.Len(t, s.expires, 1, "The expired nonces should be forgotten")
+}
+
+func TestInboundAuth_NotificationsNeedKeys(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewInboundAuth(InboundAuthConfig{}, nil).Handler(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "The notifications can't be pushed without inboundAuth keys")
+}
+
+func TestInboundAuth_SignedBodyLargerThanTheLimit(t *testing.T) {
+	now := time.Now()
+	a := inboundAuthForTest(now)
+	a.limits = NewUnrollLimits(LimitsConfig{MaxBodySize: 16})
+	w := httptest.NewRecorder()
+	a.Handler(http.NotFoundHandler()).ServeHTTP(w, signedRequestForTest("renderer-key", "renderer", now, signedBodyForTest))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestInboundAuth_ProtectedEndpointsAndMetrics(t *testing.T) {
+	a := inboundAuthForTest(time.Now())
+	a.UpdateConfig(InboundAuthConfig{Keys: a.currentConfig().Keys, Protect: []string{contentEndpoint}})
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	rejected := authFailuresTotal.WithLabelValues(contentEndpoint, authFailureMissing)
+	anonymous := clientRequestsTotal.WithLabelValues(anonymousClient, contentPreviewEndpoint, "200")
+	beforeRejected, beforeAnonymous := testutil.ToFloat64(rejected), testutil.ToFloat64(anonymous)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/content", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/content-preview", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "Only the configured endpoints should be protected")
+
+	assert.Equal(t, beforeRejected+1, testutil.ToFloat64(rejected))
+	assert.Equal(t, beforeAnonymous+1, testutil.ToFloat64(anonymous))
+}
+
+func TestGet_SendsClientID(t *testing.T) {
+	received := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received[r.Header.Get(transactionidutils.TransactionIDHeader)] = r.Header.Get(clientIDHeader)
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{ContentStoreAppName: "content-source-app-name", ContentStoreHost: ts.URL}, http.DefaultClient)
+	_, err := cr.Get(withClientID(context.Background(), "publisher"), testData, "tid_1")
+	assert.NoError(t, err)
+	_, err = cr.Get(context.Background(), testData, "tid_2")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tid_1": "publisher", "tid_2": ""}, received, "The client should be sent when it is known")
+}
+
+func TestLoadConfig_InvalidInboundAuth(t *testing.T) {
+	_, err := parseConfig([]byte("inboundAuth:\n  keys:\n  - client: publisher\n"), defaultConfigForTest())
+	assert.Error(t, err, "A client needs a key")
+	_, err = parseConfig([]byte("inboundAuth:\n  protect: [content-preview]\n"), defaultConfigForTest())
+	assert.Error(t, err, "Endpoints can't be protected without keys")
+	_, err = parseConfig([]byte("inboundAuth:\n  keys:\n  - {client: publisher, key: k}\n  protect: [unknown]\n"), defaultConfigForTest())
+	assert.Error(t, err, "Only the unroll endpoints can be protected")
+}
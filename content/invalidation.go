@@ -0,0 +1,270 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	NotificationUpdate = "http://www.ft.com/thing/ThingChangeType/UPDATE"
+	NotificationDelete = "http://www.ft.com/thing/ThingChangeType/DELETE"
+
+	defaultNotificationsInterval = 10 * time.Second
+
+	invalidationSourcePush = "push"
+	invalidationSourcePoll = "poll"
+	invalidationUpdate     = "update"
+	invalidationDelete     = "delete"
+)
+
+// InvalidationConfig configures the polling of a notifications feed, whose published UUIDs are evicted
+// from the cache. The notifications can also be pushed to the invalidation endpoint.
+type InvalidationConfig struct {
+	// NotificationsURL is the feed polled, e.g. http://localhost:8080/content/notifications, it isn't polled when empty
+	NotificationsURL string        `yaml:"notificationsURL"`
+	Interval         time.Duration `yaml:"interval"`
+	Auth             AuthConfig    `yaml:"auth"`
+	// Refresh reads the updated models again straight away, instead of on the next request for them
+	Refresh bool `yaml:"refresh"`
+}
+
+func (c InvalidationConfig) validate() error {
+	if c.NotificationsURL != "" {
+		if u, err := url.Parse(c.NotificationsURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.Errorf("invalid invalidation.notificationsURL %q", c.NotificationsURL)
+		}
+	}
+	if c.Interval < 0 {
+		return errors.New("invalidation.interval can't be negative")
+	}
+	return c.Auth.validate("invalidation")
+}
+
+// Notification is a UPP publish notification, telling that the content of the id changed.
+type Notification struct {
+	Type             string `json:"type"`
+	ID               string `json:"id"`
+	APIURL           string `json:"apiUrl,omitempty"`
+	PublishReference string `json:"publishReference,omitempty"`
+	LastModified     string `json:"lastModified,omitempty"`
+}
+
+// notificationsPage is a page of a notifications feed, the next link polls the following notifications.
+type notificationsPage struct {
+	RequestURL    string              `json:"requestUrl,omitempty"`
+	Notifications []Notification      `json:"notifications"`
+	Links         []notificationsLink `json:"links,omitempty"`
+}
+
+type notificationsLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+// Invalidator evicts the models of the published content from the cache, with the memoized outputs
+// which expanded them, and refreshes the models if configured to.
+type Invalidator struct {
+	reader  *ContentReader
+	outputs *OutputCache
+	client  *http.Client
+
+	mu     sync.RWMutex
+	config InvalidationConfig
+	// since is where the poller is in the feed
+	since string
+}
+
+func NewInvalidator(cfg InvalidationConfig, reader *ContentReader, outputs *OutputCache, client *http.Client) *Invalidator {
+	return &Invalidator{reader: reader, outputs: outputs, client: client, config: cfg, since: time.Now().UTC().Format(time.RFC3339Nano)}
+}
+
+func (inv *Invalidator) UpdateConfig(cfg InvalidationConfig) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.config = cfg
+}
+
+func (inv *Invalidator) currentConfig() InvalidationConfig {
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	return inv.config
+}
+
+type invalidationResult struct {
+	Notifications int `json:"notifications"`
+	Purged        int `json:"purged"`
+	PurgedOutputs int `json:"purgedOutputs"`
+	Refreshed     int `json:"refreshed"`
+}
+
+// Invalidate evicts the models of the notified UUIDs from the cache, and the outputs which expanded them.
+// The updated models which were cached are read again if refresh is configured, the deleted ones never are.
+func (inv *Invalidator) Invalidate(ctx context.Context, notifications []Notification, source string) invalidationResult {
+	result := invalidationResult{Notifications: len(notifications)}
+	refresh := make(map[string][]string)
+	for _, n := range notifications {
+		uuid, err := extractUUIDFromString(n.ID)
+		if err != nil {
+			logger.log.WithFields(logrus.Fields{"tid": n.PublishReference, "id": n.ID}).Warn("Notification without a valid UUID ignored")
+			continue
+		}
+		// any other type of change is evicted like an update
+		event := invalidationUpdate
+		if n.Type == NotificationDelete {
+			event = invalidationDelete
+		}
+		cacheInvalidationsTotal.WithLabelValues(source, event).Inc()
+
+		for _, m := range inv.reader.cache.lookup(uuid) {
+			if event == invalidationUpdate {
+				refresh[m.Method] = append(refresh[m.Method], uuid)
+			}
+		}
+		result.Purged += inv.reader.cache.purge(uuid)
+		result.PurgedOutputs += inv.outputs.purge(uuid)
+	}
+
+	if inv.currentConfig().Refresh {
+		result.Refreshed = inv.reader.refresh(ctx, refresh)
+	}
+	return result
+}
+
+// refresh reads the models of the UUIDs again for each reader method, caching them.
+func (cr *ContentReader) refresh(ctx context.Context, uuidsByMethod map[string][]string) int {
+	cfg := cr.currentConfig()
+	tid := transactionidutils.NewTransactionID()
+	paths := map[string]string{readerGet: cfg.ContentPathEndpoint, readerGetInternal: cfg.InternalContentPathEndpoint}
+	refreshed := 0
+	for method, uuids := range uuidsByMethod {
+		cm := make(map[string]Content)
+		if err := cr.doGetCached(ctx, cfg, uuids, tid, paths[method], method, cm); err != nil {
+			logger.Errorf(tid, "Error refreshing the cached models: %v", err)
+		}
+		refreshed += len(cm)
+	}
+	return refreshed
+}
+
+// Handler evicts the UUIDs of the notifications posted: a notifications page, or a list of notifications.
+func (inv *Invalidator) Handler(w http.ResponseWriter, r *http.Request) {
+	body, err := readBodyWithin(r.Body, defaultMaxBodySize)
+	if err != nil {
+		writeAdminError(w, limitStatus(err, http.StatusBadRequest), "Unable to read the notifications: "+err.Error())
+		return
+	}
+	var notifications []Notification
+	if err = json.Unmarshal(body, &notifications); err != nil {
+		var page notificationsPage
+		if err = json.Unmarshal(body, &page); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "Invalid notifications: "+err.Error())
+			return
+		}
+		notifications = page.Notifications
+	}
+
+	result := inv.Invalidate(r.Context(), notifications, invalidationSourcePush)
+	logger.log.WithFields(logrus.Fields{"notifications": result.Notifications, "purged": result.Purged, "refreshed": result.Refreshed}).
+		Info("Cache invalidated by pushed notifications")
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+// Poll reads the notifications feed until the context is done, evicting the UUIDs notified. A page
+// with notifications is followed by the next one straight away, the others by a wait of the interval.
+func (inv *Invalidator) Poll(ctx context.Context) {
+	for {
+		cfg := inv.currentConfig()
+		wait := cfg.Interval
+		if wait == 0 {
+			wait = defaultNotificationsInterval
+		}
+		if cfg.NotificationsURL != "" {
+			more, err := inv.pollOnce(ctx, cfg)
+			if err != nil {
+				logger.log.WithError(err).Warn("Unable to poll the notifications")
+			} else if more {
+				wait = 0
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pollOnce reads a page of the feed and evicts its UUIDs, returning whether there may be more notifications:
+// the page had some, and the feed moved on to the next page.
+func (inv *Invalidator) pollOnce(ctx context.Context, cfg InvalidationConfig) (bool, error) {
+	inv.mu.RLock()
+	since := inv.since
+	inv.mu.RUnlock()
+
+	page, err := inv.readPage(ctx, cfg, since)
+	if err != nil {
+		return false, err
+	}
+	if len(page.Notifications) > 0 {
+		result := inv.Invalidate(ctx, page.Notifications, invalidationSourcePoll)
+		logger.log.WithFields(logrus.Fields{"notifications": result.Notifications, "purged": result.Purged, "refreshed": result.Refreshed}).
+			Info("Cache invalidated by polled notifications")
+	}
+
+	// the feed tells where the next page starts
+	next := since
+	for _, l := range page.Links {
+		if u, err := url.Parse(l.Href); err == nil && l.Rel == "next" && u.Query().Get("since") != "" {
+			next = u.Query().Get("since")
+		}
+	}
+	inv.mu.Lock()
+	inv.since = next
+	inv.mu.Unlock()
+	return len(page.Notifications) > 0 && next != since, nil
+}
+
+func (inv *Invalidator) readPage(ctx context.Context, cfg InvalidationConfig, since string) (notificationsPage, error) {
+	var page notificationsPage
+	u, err := url.Parse(cfg.NotificationsURL)
+	if err != nil {
+		return page, errors.Wrap(err, "Invalid notifications URL")
+	}
+	q := u.Query()
+	q.Set("since", since)
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := withTimeout(ctx, inv.reader.currentConfig().Timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err == nil {
+		err = cfg.Auth.authenticate(req)
+	}
+	if err != nil {
+		return page, errors.Wrap(err, "Error creating the notifications request")
+	}
+	req.Header.Set(transactionidutils.TransactionIDHeader, transactionidutils.NewTransactionID())
+	req.Header.Set(userAgent, userAgentValue)
+
+	resp, err := inv.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return page, errors.Wrap(err, "Notifications request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return page, errors.Errorf("Notifications request failed with status code %d", resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return page, errors.Wrap(err, "Invalid notifications response")
+	}
+	return page, nil
+}
@@ -0,0 +1,170 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	invalidatedUUID = "22c0d426-1466-11e7-b0c1-37e417ee6c76"
+	deletedUUID     = "639cd952-149f-11e7-2ea7-a07ecd9ac73f"
+)
+
+func notificationJSON(changeType string, uuid string) string {
+	return fmt.Sprintf(`{"type":"%s","id":"http://www.ft.com/thing/%s","apiUrl":"http://api.ft.com/content/%s","publishReference":"tid_test"}`, changeType, uuid, uuid)
+}
+
+// invalidatorForTest has the two UUIDs cached, and reads them again from the content store served by h.
+func invalidatorForTest(h http.HandlerFunc, cfg InvalidationConfig) (*Invalidator, *httptest.Server) {
+	ts := httptest.NewServer(h)
+	reader := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		ContentPathEndpoint: "/content",
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10},
+	}, http.DefaultClient)
+	for _, uuid := range []string{invalidatedUUID, deletedUUID} {
+		reader.cache.set(readerGet, uuid, Content{ID: "http://www.ft.com/thing/" + uuid})
+	}
+	return NewInvalidator(cfg, reader, nil, http.DefaultClient), ts
+}
+
+func TestInvalidator_Handler(t *testing.T) {
+	inv, contentStore := invalidatorForTest(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("The models shouldn't be read again without refresh: %s", r.URL)
+	}, InvalidationConfig{})
+	defer contentStore.Close()
+	deletes := cacheInvalidationsTotal.WithLabelValues(invalidationSourcePush, invalidationDelete)
+	before := testutil.ToFloat64(deletes)
+
+	body := `{"notifications":[` + notificationJSON(NotificationUpdate, invalidatedUUID) + `,` + notificationJSON(NotificationDelete, deletedUUID) + `]}`
+	w := httptest.NewRecorder()
+	inv.Handler(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"notifications":2,"purged":2,"purgedOutputs":0,"refreshed":0}`, w.Body.String())
+	assert.Empty(t, inv.reader.cache.lookup(invalidatedUUID))
+	assert.Empty(t, inv.reader.cache.lookup(deletedUUID))
+	assert.Equal(t, before+1, testutil.ToFloat64(deletes))
+
+	w = httptest.NewRecorder()
+	inv.Handler(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(`[`+notificationJSON(NotificationUpdate, invalidatedUUID)+`]`)))
+	assert.Equal(t, http.StatusOK, w.Code, "A list of notifications should be accepted")
+	assert.JSONEq(t, `{"notifications":1,"purged":0,"purgedOutputs":0,"refreshed":0}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	inv.Handler(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(`not json`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestInvalidator_PurgesTheOutputsWhichExpandedTheModels(t *testing.T) {
+	inv, contentStore := invalidatorForTest(func(w http.ResponseWriter, r *http.Request) {}, InvalidationConfig{})
+	defer contentStore.Close()
+	inv.outputs = NewOutputCache(OutputCacheConfig{Enabled: true})
+	inv.outputs.set(unrolledOutput{key: "article", uuids: map[string]bool{invalidatedUUID: true}})
+
+	result := inv.Invalidate(context.Background(), []Notification{{Type: NotificationUpdate, ID: "http://www.ft.com/thing/" + invalidatedUUID}}, invalidationSourcePush)
+	assert.Equal(t, 1, result.PurgedOutputs)
+	_, found := inv.outputs.get("article")
+	assert.False(t, found, "The memoized article embedding the republished model shouldn't be served anymore")
+}
+
+func TestInvalidator_RefreshesUpdatedModels(t *testing.T) {
+	var requested []string
+	inv, contentStore := invalidatorForTest(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Query()["uuid"]...)
+		fmt.Fprintf(w, `[{"id":"http://www.ft.com/thing/%s","type":"http://www.ft.com/ontology/content/ImageSet"}]`, invalidatedUUID)
+	}, InvalidationConfig{Refresh: true})
+	defer contentStore.Close()
+
+	result := inv.Invalidate(context.Background(), []Notification{
+		{Type: NotificationUpdate, ID: "http://www.ft.com/thing/" + invalidatedUUID},
+		{Type: NotificationDelete, ID: "http://www.ft.com/thing/" + deletedUUID},
+		{Type: NotificationUpdate, ID: "http://www.ft.com/thing/d02886fc-58ff-11e8-9859-6668838a4c10"},
+	}, invalidationSourcePush)
+
+	assert.Equal(t, invalidationResult{Notifications: 3, Purged: 2, Refreshed: 1}, result)
+	assert.Equal(t, []string{invalidatedUUID}, requested, "Only the updated models which were cached should be read again")
+	cached := inv.reader.cache.lookup(invalidatedUUID)
+	if assert.Len(t, cached, 1) {
+		assert.Equal(t, "http://www.ft.com/ontology/content/ImageSet", cached[0].Content.Type)
+	}
+}
+
+func TestInvalidator_PollsNotifications(t *testing.T) {
+	var mu sync.Mutex
+	var sinces []string
+	var polls int32
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/content/notifications", r.URL.Path)
+		mu.Lock()
+		sinces = append(sinces, r.URL.Query().Get("since"))
+		mu.Unlock()
+		if atomic.AddInt32(&polls, 1) == 1 {
+			fmt.Fprintf(w, `{"requestUrl":"http://api.ft.com/content/notifications?since=start","notifications":[%s],"links":[{"href":"http://api.ft.com/content/notifications?since=2026-10-18T10:00:00.000Z","rel":"next"}]}`,
+				notificationJSON(NotificationUpdate, invalidatedUUID))
+			return
+		}
+		fmt.Fprint(w, `{"notifications":[],"links":[{"href":"http://api.ft.com/content/notifications?since=2026-10-18T10:00:00.000Z","rel":"next"}]}`)
+	}))
+	defer feed.Close()
+
+	inv, contentStore := invalidatorForTest(func(w http.ResponseWriter, r *http.Request) {}, InvalidationConfig{NotificationsURL: feed.URL + "/content/notifications", Interval: 10 * time.Millisecond})
+	defer contentStore.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inv.Poll(ctx)
+
+	for i := 0; i < 100 && atomic.LoadInt32(&polls) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	assert.Empty(t, inv.reader.cache.lookup(invalidatedUUID), "The notified UUID should have been evicted")
+	assert.NotEmpty(t, inv.reader.cache.lookup(deletedUUID))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.True(t, len(sinces) >= 3) {
+		assert.NotEmpty(t, sinces[0])
+		assert.Equal(t, "2026-10-18T10:00:00.000Z", sinces[1], "The feed should be followed from its next link")
+		assert.Equal(t, "2026-10-18T10:00:00.000Z", sinces[2])
+	}
+}
+
+func TestInvalidator_PollErrorsAreRetried(t *testing.T) {
+	var polls int32
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer feed.Close()
+
+	inv, contentStore := invalidatorForTest(func(w http.ResponseWriter, r *http.Request) {}, InvalidationConfig{NotificationsURL: feed.URL, Interval: 10 * time.Millisecond})
+	defer contentStore.Close()
+	_, err := inv.pollOnce(context.Background(), inv.currentConfig())
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inv.Poll(ctx)
+	for i := 0; i < 100 && atomic.LoadInt32(&polls) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, atomic.LoadInt32(&polls) >= 3, "The feed should be polled again after an error")
+}
+
+func TestLoadConfig_InvalidInvalidation(t *testing.T) {
+	_, err := parseConfig([]byte("invalidation:\n  notificationsURL: /content/notifications\n"), defaultConfigForTest())
+	assert.Error(t, err, "The feed needs an absolute URL")
+	_, err = parseConfig([]byte("invalidation:\n  auth:\n    type: api-key\n"), defaultConfigForTest())
+	assert.Error(t, err)
+}
@@ -0,0 +1,224 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxBodySize = 10 << 20
+	defaultMaxEmbeds   = 200
+	defaultMaxUUIDs    = 1000
+	defaultMaxDepth    = 2
+
+	limitMaxBodySize = "maxBodySize"
+	limitMaxEmbeds   = "maxEmbeds"
+	limitMaxUUIDs    = "maxUUIDs"
+	limitMaxDepth    = "maxDepth"
+	limitTimeout     = "timeout"
+
+	// depthMembers is the depth of the members of the image sets, the models of the article being at depth 1
+	depthMembers = 2
+)
+
+// LimitsConfig caps what a single unroll can cost. A request over the body size or embeds limits is
+// rejected, the other limits stop the expansion and are reported.
+type LimitsConfig struct {
+	// MaxBodySize is the size of the largest request body, in bytes
+	MaxBodySize int64 `yaml:"maxBodySize"`
+	// MaxEmbeds is the most embeds the body of an article can have
+	MaxEmbeds int `yaml:"maxEmbeds"`
+	// MaxUUIDs is the most models read for an article, members included
+	MaxUUIDs int `yaml:"maxUUIDs"`
+	// MaxDepth is 1 to leave the members of the image sets unexpanded
+	MaxDepth int `yaml:"maxDepth"`
+	// Timeout is the longest an unroll can take, 0 is only limited by the upstream timeouts
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// withDefaults returns the config with the defaults of the settings which aren't set.
+func (c LimitsConfig) withDefaults() LimitsConfig {
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaultMaxBodySize
+	}
+	if c.MaxEmbeds == 0 {
+		c.MaxEmbeds = defaultMaxEmbeds
+	}
+	if c.MaxUUIDs == 0 {
+		c.MaxUUIDs = defaultMaxUUIDs
+	}
+	if c.MaxDepth == 0 {
+		c.MaxDepth = defaultMaxDepth
+	}
+	return c
+}
+
+func (c LimitsConfig) validate() error {
+	if c.MaxBodySize < 0 || c.MaxEmbeds < 0 || c.MaxUUIDs < 0 || c.Timeout < 0 {
+		return errors.New("limits can't be negative")
+	}
+	if c.MaxDepth < 0 || c.MaxDepth > depthMembers {
+		return errors.Errorf("limits.maxDepth must be 1 or %d", depthMembers)
+	}
+	return nil
+}
+
+// UnrollLimits keeps the limits applied to the following unrolls.
+type UnrollLimits struct {
+	mu     sync.RWMutex
+	config LimitsConfig
+}
+
+func NewUnrollLimits(cfg LimitsConfig) *UnrollLimits {
+	return &UnrollLimits{config: cfg.withDefaults()}
+}
+
+func (l *UnrollLimits) UpdateConfig(cfg LimitsConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = cfg.withDefaults()
+}
+
+// currentConfig returns the default limits when there are none.
+func (l *UnrollLimits) currentConfig() LimitsConfig {
+	if l == nil {
+		return LimitsConfig{}.withDefaults()
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.config
+}
+
+// limitError is an unroll rejected for exceeding a limit: a 413 for the body size, a 503 for the timeout,
+// as the unroll may not run out of time when retried, and a 422 otherwise.
+type limitError struct {
+	limit string
+	msg   string
+}
+
+func (e limitError) Error() string {
+	return e.msg
+}
+
+// limitStatus returns the status of the limit exceeded, if the error is one.
+func limitStatus(err error, otherwise int) int {
+	le, ok := errors.Cause(err).(limitError)
+	switch {
+	case !ok:
+		return otherwise
+	case le.limit == limitMaxBodySize:
+		return http.StatusRequestEntityTooLarge
+	case le.limit == limitTimeout:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// readBodyWithin reads a request body of at most the max size, the larger ones are a limitError.
+func readBodyWithin(body io.Reader, maxBodySize int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxBodySize {
+		return nil, limitError{limitMaxBodySize, fmt.Sprintf("The request body is larger than the limit of %d bytes", maxBodySize)}
+	}
+	return b, nil
+}
+
+// unrollBudget is what is left of the limits of an unroll, shared by the reads made for it.
+type unrollBudget struct {
+	limits LimitsConfig
+
+	mu        sync.Mutex
+	taken     map[string]bool
+	rejection error
+}
+
+func newUnrollBudget(limits LimitsConfig) *unrollBudget {
+	return &unrollBudget{limits: limits, taken: make(map[string]bool)}
+}
+
+type unrollBudgetKey struct{}
+
+func withUnrollBudget(ctx context.Context, b *unrollBudget) context.Context {
+	return context.WithValue(ctx, unrollBudgetKey{}, b)
+}
+
+// budgetOf returns the budget of the unroll, nil when it isn't limited.
+func budgetOf(ctx context.Context) *unrollBudget {
+	b, _ := ctx.Value(unrollBudgetKey{}).(*unrollBudget)
+	return b
+}
+
+// take returns the UUIDs which can still be read, reporting the others. A UUID already read is free.
+func (b *unrollBudget) take(ctx context.Context, uuids []string) []string {
+	if b == nil {
+		return uuids
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	allowed := make([]string, 0, len(uuids))
+	skipped := 0
+	for _, uuid := range uuids {
+		if !b.taken[uuid] && len(b.taken) >= b.limits.MaxUUIDs {
+			skipped++
+			continue
+		}
+		b.taken[uuid] = true
+		allowed = append(allowed, uuid)
+	}
+	if skipped > 0 {
+		expansionReport(ctx).addLimit(limitMaxUUIDs, strconv.Itoa(b.limits.MaxUUIDs), "the models of the other UUIDs weren't read")
+	}
+	return allowed
+}
+
+// allowsDepth tells whether the models at the depth can be read, reporting it otherwise.
+func (b *unrollBudget) allowsDepth(ctx context.Context, depth int) bool {
+	if b == nil || depth <= b.limits.MaxDepth {
+		return true
+	}
+	expansionReport(ctx).addLimit(limitMaxDepth, strconv.Itoa(b.limits.MaxDepth), "the image set members weren't expanded")
+	return false
+}
+
+// checkEmbeds rejects the unroll if the body has too many embeds.
+func (b *unrollBudget) checkEmbeds(count int) bool {
+	if b == nil || count <= b.limits.MaxEmbeds {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rejection = limitError{limitMaxEmbeds, fmt.Sprintf("The body has %d embeds, more than the limit of %d", count, b.limits.MaxEmbeds)}
+	return false
+}
+
+// uuids returns the UUIDs of the models read for the unroll.
+func (b *unrollBudget) uuids() map[string]bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	uuids := make(map[string]bool, len(b.taken))
+	for uuid := range b.taken {
+		uuids[uuid] = true
+	}
+	return uuids
+}
+
+func (b *unrollBudget) rejected() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rejection
+}
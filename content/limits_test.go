@@ -0,0 +1,65 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsConfig_Validate(t *testing.T) {
+	assert.NoError(t, LimitsConfig{}.validate())
+	assert.NoError(t, LimitsConfig{MaxDepth: 1}.validate())
+	assert.Error(t, LimitsConfig{MaxDepth: 3}.validate())
+	assert.Error(t, LimitsConfig{MaxUUIDs: -1}.validate())
+	assert.Error(t, LimitsConfig{Timeout: -1}.validate())
+}
+
+func TestUnrollBudget_Take(t *testing.T) {
+	report := newExpansionReport()
+	ctx := withExpansionReport(context.Background(), report)
+	b := newUnrollBudget(LimitsConfig{MaxUUIDs: 3})
+
+	assert.Equal(t, []string{"a", "b"}, b.take(ctx, []string{"a", "b"}))
+	assert.Empty(t, report.limitHits())
+	assert.Equal(t, []string{"a", "c"}, b.take(ctx, []string{"a", "c", "d"}), "A UUID already read shouldn't count twice")
+	assert.Equal(t, []LimitHit{{Limit: limitMaxUUIDs, Max: "3", Detail: "the models of the other UUIDs weren't read"}}, report.limitHits())
+	assert.Empty(t, b.take(ctx, []string{"e"}))
+	assert.Len(t, report.limitHits(), 1, "A limit should only be reported once")
+}
+
+func TestUnrollBudget_AllowsDepth(t *testing.T) {
+	report := newExpansionReport()
+	ctx := withExpansionReport(context.Background(), report)
+
+	assert.True(t, newUnrollBudget(LimitsConfig{}.withDefaults()).allowsDepth(ctx, depthMembers))
+	assert.False(t, newUnrollBudget(LimitsConfig{MaxDepth: 1}).allowsDepth(ctx, depthMembers))
+	assert.Equal(t, limitMaxDepth, report.limitHits()[0].Limit)
+	assert.True(t, report.degraded())
+}
+
+func TestUnrollBudget_CheckEmbeds(t *testing.T) {
+	b := newUnrollBudget(LimitsConfig{MaxEmbeds: 2})
+	assert.True(t, b.checkEmbeds(2))
+	assert.NoError(t, b.rejected())
+	assert.False(t, b.checkEmbeds(3))
+	assert.Equal(t, http.StatusUnprocessableEntity, limitStatus(b.rejected(), http.StatusInternalServerError))
+}
+
+func TestUnrollBudget_Unlimited(t *testing.T) {
+	var b *unrollBudget
+	ctx := context.Background()
+	assert.Equal(t, []string{"a"}, b.take(ctx, []string{"a"}))
+	assert.True(t, b.allowsDepth(ctx, depthMembers))
+	assert.True(t, b.checkEmbeds(1000))
+	assert.NoError(t, b.rejected())
+}
+
+func TestLimitStatus(t *testing.T) {
+	assert.Equal(t, http.StatusRequestEntityTooLarge, limitStatus(limitError{limit: limitMaxBodySize}, http.StatusBadRequest))
+	assert.Equal(t, http.StatusServiceUnavailable, limitStatus(errors.Wrap(limitError{limit: limitTimeout}, "unrolling"), http.StatusInternalServerError))
+	assert.Equal(t, http.StatusUnprocessableEntity, limitStatus(limitError{limit: limitMaxEmbeds}, http.StatusInternalServerError))
+	assert.Equal(t, http.StatusBadRequest, limitStatus(errors.New("invalid"), http.StatusBadRequest))
+}
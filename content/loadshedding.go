@@ -0,0 +1,181 @@
+package content
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultInitialConcurrency = 50
+	defaultMinConcurrency     = 5
+	defaultMaxConcurrency     = 500
+	defaultTargetLatency      = 2 * time.Second
+	defaultConcurrencyBackoff = 0.9
+)
+
+// LoadSheddingConfig configures the adaptive limit of the unroll requests served concurrently.
+// The limit grows by one for each limit's worth of requests faster than the target latency, and
+// is multiplied by the backoff when a request is slower, at most once per target latency.
+type LoadSheddingConfig struct {
+	Enabled       bool          `yaml:"enabled" json:"enabled"`
+	InitialLimit  int           `yaml:"initialLimit" json:"initialLimit"`
+	MinLimit      int           `yaml:"minLimit" json:"minLimit"`
+	MaxLimit      int           `yaml:"maxLimit" json:"maxLimit"`
+	TargetLatency time.Duration `yaml:"targetLatency" json:"targetLatency"`
+	Backoff       float64       `yaml:"backoff" json:"backoff"`
+}
+
+// withDefaults returns the config with the defaults of the settings which aren't set.
+func (c LoadSheddingConfig) withDefaults() LoadSheddingConfig {
+	if c.InitialLimit == 0 {
+		c.InitialLimit = defaultInitialConcurrency
+	}
+	if c.MinLimit == 0 {
+		c.MinLimit = defaultMinConcurrency
+	}
+	if c.MaxLimit == 0 {
+		c.MaxLimit = defaultMaxConcurrency
+	}
+	if c.TargetLatency == 0 {
+		c.TargetLatency = defaultTargetLatency
+	}
+	if c.Backoff == 0 {
+		c.Backoff = defaultConcurrencyBackoff
+	}
+	return c
+}
+
+func (c LoadSheddingConfig) validate() error {
+	if c.InitialLimit < 0 || c.MinLimit < 0 || c.MaxLimit < 0 || c.TargetLatency < 0 {
+		return errors.New("loadShedding limits and targetLatency can't be negative")
+	}
+	d := c.withDefaults()
+	if d.MinLimit > d.MaxLimit || d.InitialLimit < d.MinLimit || d.InitialLimit > d.MaxLimit {
+		return errors.New("loadShedding.initialLimit must be between loadShedding.minLimit and loadShedding.maxLimit")
+	}
+	if c.Backoff < 0 || c.Backoff >= 1 {
+		return errors.New("loadShedding.backoff must be between 0 and 1")
+	}
+	return nil
+}
+
+// LoadShedder rejects the unroll requests over the concurrency limit with a 503, instead of letting
+// them queue behind slow upstream calls. The limit adapts to the latency of the requests served.
+type LoadShedder struct {
+	mu           sync.Mutex
+	config       LoadSheddingConfig
+	limit        float64
+	inFlight     int
+	lastDecrease time.Time
+	now          func() time.Time
+}
+
+func NewLoadShedder(cfg LoadSheddingConfig) *LoadShedder {
+	cfg = cfg.withDefaults()
+	s := &LoadShedder{config: cfg, limit: float64(cfg.InitialLimit), now: time.Now}
+	concurrencyLimit.Set(s.limit)
+	return s
+}
+
+// UpdateConfig keeps the current limit within the new bounds, it is reset when load shedding is turned on.
+func (s *LoadShedder) UpdateConfig(cfg LoadSheddingConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg = cfg.withDefaults()
+	if cfg.Enabled && !s.config.Enabled {
+		s.limit = float64(cfg.InitialLimit)
+	}
+	s.config = cfg
+	s.limit = math.Max(float64(cfg.MinLimit), math.Min(float64(cfg.MaxLimit), s.limit))
+	concurrencyLimit.Set(s.limit)
+}
+
+// Handler is a middleware limiting the unroll requests served concurrently, the other
+// endpoints such as the healthchecks and the GTG are never shed.
+func (s *LoadShedder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/")
+		if !contains(unrollEndpoints, endpoint) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !s.acquire() {
+			tid := transactionidutils.GetTransactionIDFromRequest(r)
+			logger.log.WithFields(logrus.Fields{"tid": tid, "request_url": r.RequestURI}).
+				Warn("Request shed, too many requests in flight")
+			shedRequestsTotal.WithLabelValues(endpoint).Inc()
+
+			msg, _ := json.Marshal(ErrorMessage{Message: "Service overloaded, try again later"})
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(msg)
+			return
+		}
+
+		start := s.now()
+		defer func() {
+			s.release(s.now().Sub(start))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *LoadShedder) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.config.Enabled && s.inFlight >= int(s.limit) {
+		return false
+	}
+	s.inFlight++
+	inFlightRequests.Set(float64(s.inFlight))
+	return true
+}
+
+// release adapts the limit to the latency of a request which has been served.
+func (s *LoadShedder) release(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	inFlightRequests.Set(float64(s.inFlight))
+	if !s.config.Enabled {
+		return
+	}
+
+	now := s.now()
+	if latency > s.config.TargetLatency {
+		// the requests in flight when the latency rose would decrease the limit again and again
+		if now.Sub(s.lastDecrease) >= s.config.TargetLatency {
+			s.limit = math.Max(float64(s.config.MinLimit), s.limit*s.config.Backoff)
+			s.lastDecrease = now
+		}
+	} else {
+		s.limit = math.Min(float64(s.config.MaxLimit), s.limit+1/s.limit)
+	}
+	concurrencyLimit.Set(s.limit)
+}
+
+type loadSheddingState struct {
+	Config   LoadSheddingConfig `json:"config"`
+	Limit    float64            `json:"limit"`
+	InFlight int                `json:"inFlight"`
+}
+
+// StateHandler responds with the current concurrency limit and the requests in flight.
+func (s *LoadShedder) StateHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	state := loadSheddingState{Config: s.config, Limit: s.limit, InFlight: s.inFlight}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(state)
+}
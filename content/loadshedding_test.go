@@ -0,0 +1,99 @@
+package content
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShedder_ShedsRequestsOverTheLimit(t *testing.T) {
+	s := NewLoadShedder(LoadSheddingConfig{Enabled: true, InitialLimit: 2, MinLimit: 1, MaxLimit: 10})
+	started, release := make(chan struct{}), make(chan struct{})
+	h := s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/content", nil))
+		}()
+		<-started
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/internalcontent", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "The requests over the limit should be shed")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	exempt := s.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	for _, path := range []string{"/__health", "/__gtg"} {
+		w = httptest.NewRecorder()
+		exempt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, "%s should never be shed", path)
+	}
+
+	close(release)
+	wg.Wait()
+	w = httptest.NewRecorder()
+	exempt.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/content", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestLoadShedder_LimitAdaptsToLatency(t *testing.T) {
+	now := time.Now()
+	s := NewLoadShedder(LoadSheddingConfig{Enabled: true, InitialLimit: 10, MinLimit: 5, MaxLimit: 11, TargetLatency: time.Second, Backoff: 0.5})
+	s.now = func() time.Time { return now }
+	serve := func(latency time.Duration) {
+		assert.True(t, s.acquire())
+		s.release(latency)
+	}
+
+	for i := 0; i < 10; i++ {
+		serve(100 * time.Millisecond)
+	}
+	assert.InDelta(t, 10.95, s.limit, 0.05, "The limit should grow by one for each limit's worth of fast requests")
+	for i := 0; i < 10; i++ {
+		serve(100 * time.Millisecond)
+	}
+	assert.Equal(t, 11.0, s.limit, "The limit should stay below the max")
+
+	serve(2 * time.Second)
+	serve(2 * time.Second)
+	assert.Equal(t, 5.5, s.limit, "The limit should be decreased once per target latency")
+	now = now.Add(time.Second)
+	serve(2 * time.Second)
+	assert.Equal(t, 5.0, s.limit, "The limit should stay above the min")
+
+	s.UpdateConfig(LoadSheddingConfig{})
+	assert.True(t, s.acquire(), "Nothing should be shed when load shedding is off")
+}
+
+func TestLoadSheddingConfig_Validate(t *testing.T) {
+	assert.NoError(t, LoadSheddingConfig{}.validate())
+	assert.NoError(t, LoadSheddingConfig{Enabled: true, InitialLimit: 20, MinLimit: 10, MaxLimit: 30, Backoff: 0.8}.validate())
+	assert.Error(t, LoadSheddingConfig{MinLimit: 10, MaxLimit: 5}.validate())
+	assert.Error(t, LoadSheddingConfig{InitialLimit: 1000}.validate())
+	assert.Error(t, LoadSheddingConfig{Backoff: 1.5}.validate())
+}
+
+func TestLoadShedder_StateHandler(t *testing.T) {
+	s := NewLoadShedder(LoadSheddingConfig{Enabled: true, InitialLimit: 20})
+	s.acquire()
+
+	w := httptest.NewRecorder()
+	s.StateHandler(w, httptest.NewRequest(http.MethodGet, "/load-shedding", nil))
+	var state loadSheddingState
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Equal(t, float64(20), state.Limit)
+	assert.Equal(t, 1, state.InFlight)
+}
@@ -0,0 +1,259 @@
+package content
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "content_unroller"
+
+	contentEndpoint                = "content"
+	internalContentEndpoint        = "internalcontent"
+	contentPreviewEndpoint         = "content-preview"
+	internalContentPreviewEndpoint = "internalcontent-preview"
+	notificationsEndpoint          = "notifications"
+
+	readerGet                = "Get"
+	readerGetInternal        = "GetInternal"
+	readerGetPreview         = "GetPreview"
+	readerGetInternalPreview = "GetInternalPreview"
+
+	outcomeSuccess          = "success"
+	outcomeRequestError     = "request-error"
+	outcomeUnexpectedStatus = "unexpected-status"
+	outcomeInvalidResponse  = "invalid-response"
+	outcomeBulkheadRejected = "bulkhead-rejected"
+	outcomeNotModified      = "not-modified"
+
+	revalidationNotModified = "not-modified"
+	revalidationModified    = "modified"
+
+	staleRevalidating  = "revalidating"
+	staleUpstreamError = "upstream-error"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "Number of unroll requests, by endpoint and response status.",
+	}, []string{"endpoint", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of unroll requests, by endpoint and response status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+
+	upstreamRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_requests_total",
+		Help:      "Number of requests to upstream apps, by app, reader method and outcome.",
+	}, []string{"app", "method", "outcome"})
+
+	upstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of requests to upstream apps, by app, reader method and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"app", "method", "outcome"})
+
+	embedsExpanded = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "embeds_expanded",
+		Help:      "Number of embedded items expanded per request, by endpoint.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50},
+	}, []string{"endpoint"})
+
+	upstreamHostEjectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_host_ejections_total",
+		Help:      "Number of times a host of an upstream app was ejected after consecutive failures, by app and host.",
+	}, []string{"app", "host"})
+
+	expansionFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "expansion_failures_total",
+		Help:      "Number of fields which couldn't be expanded, by endpoint and reason.",
+	}, []string{"endpoint", "reason"})
+
+	clientRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "client_requests_total",
+		Help:      "Number of unroll requests, by client, endpoint and response status.",
+	}, []string{"client", "endpoint", "status"})
+
+	authFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "inbound_auth_failures_total",
+		Help:      "Number of unroll requests rejected for missing or invalid credentials, by endpoint and reason.",
+	}, []string{"endpoint", "reason"})
+
+	rateLimitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of unroll requests rejected with a 429 as the client was over its rate limit, by endpoint and client.",
+	}, []string{"endpoint", "client"})
+
+	shedRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "shed_requests_total",
+		Help:      "Number of unroll requests rejected with a 503 as the concurrency limit was reached, by endpoint.",
+	}, []string{"endpoint"})
+
+	concurrencyLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "concurrency_limit",
+		Help:      "Current adaptive limit of the unroll requests served concurrently.",
+	})
+
+	inFlightRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "in_flight_requests",
+		Help:      "Number of unroll requests being served.",
+	})
+
+	upstreamInFlightRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_in_flight_requests",
+		Help:      "Number of requests in flight to upstream apps, by app.",
+	}, []string{"app"})
+
+	upstreamQueuedRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_queued_requests",
+		Help:      "Number of requests to upstream apps waiting for their bulkhead, by app.",
+	}, []string{"app"})
+
+	bulkheadRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_bulkhead_rejections_total",
+		Help:      "Number of requests to upstream apps which failed as their bulkhead was saturated, by app and reason.",
+	}, []string{"app", "reason"})
+
+	cacheInvalidationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_invalidations_total",
+		Help:      "Number of UUIDs notified as published and evicted from the cache, by source (push or poll) and event (update or delete).",
+	}, []string{"source", "event"})
+
+	staleModelsServedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_stale_models_served_total",
+		Help:      "Number of models served from the cache past their soft TTL (revalidating) or their TTL (upstream-error), by reader method and reason.",
+	}, []string{"method", "reason"})
+
+	revalidationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_revalidations_total",
+		Help:      "Number of conditional requests to upstream apps, by reader method and result: not-modified responses were served from the validated response.",
+	}, []string{"method", "result"})
+
+	outputCacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "output_cache_lookups_total",
+		Help:      "Number of lookups of unrolled responses in the output cache, by whether they were found.",
+	}, []string{"hit"})
+
+	compressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "compression_ratio",
+		Help:      "Ratio of the uncompressed to the compressed size of the unrolled responses and of the upstream responses, by direction and encoding.",
+		Buckets:   []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16},
+	}, []string{"direction", "encoding"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		requestsTotal,
+		requestDuration,
+		upstreamRequestsTotal,
+		upstreamRequestDuration,
+		upstreamHostEjectionsTotal,
+		embedsExpanded,
+		expansionFailuresTotal,
+		clientRequestsTotal,
+		authFailuresTotal,
+		rateLimitedTotal,
+		shedRequestsTotal,
+		concurrencyLimit,
+		inFlightRequests,
+		upstreamInFlightRequests,
+		upstreamQueuedRequests,
+		bulkheadRejectionsTotal,
+		cacheInvalidationsTotal,
+		staleModelsServedTotal,
+		revalidationsTotal,
+		outputCacheLookupsTotal,
+		compressionRatio,
+	)
+}
+
+// statusRecorder keeps the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w}
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+// recordedStatus returns the status written, nothing written means the handler panicked
+// and RecoveryHandler responds with a 500.
+func (sr *statusRecorder) recordedStatus() string {
+	if sr.status == 0 {
+		return strconv.Itoa(http.StatusInternalServerError)
+	}
+	return strconv.Itoa(sr.status)
+}
+
+func observeRequest(endpoint string, start time.Time, sr *statusRecorder) {
+	status := sr.recordedStatus()
+	requestsTotal.WithLabelValues(endpoint, status).Inc()
+	requestDuration.WithLabelValues(endpoint, status).Observe(time.Since(start).Seconds())
+}
+
+func observeClientRequest(client string, endpoint string, sr *statusRecorder) {
+	clientRequestsTotal.WithLabelValues(client, endpoint, sr.recordedStatus()).Inc()
+}
+
+// observeExpansion records how many embeds were expanded and why the other fields weren't.
+func observeExpansion(endpoint string, res UnrollResult) {
+	if res.uc != nil {
+		embedsExpanded.WithLabelValues(endpoint).Observe(float64(res.expandedEmbeds()))
+	}
+	for _, p := range res.report.problems() {
+		expansionFailuresTotal.WithLabelValues(endpoint, p.Reason).Inc()
+	}
+}
+
+// observeCompression records the ratio of the uncompressed to the compressed size of a body.
+func observeCompression(direction string, encoding string, uncompressed int, compressed int) {
+	if compressed > 0 {
+		compressionRatio.WithLabelValues(direction, encoding).Observe(float64(uncompressed) / float64(compressed))
+	}
+}
+
+// observeUpstreamRequest is deferred by the reader, with the outcome set as the request progresses.
+func observeUpstreamRequest(appName string, method string, outcome *string, start time.Time) {
+	upstreamRequestsTotal.WithLabelValues(appName, method, *outcome).Inc()
+	upstreamRequestDuration.WithLabelValues(appName, method, *outcome).Observe(time.Since(start).Seconds())
+}
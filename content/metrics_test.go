@@ -0,0 +1,69 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContent_RequestMetrics(t *testing.T) {
+	cu := ContentUnrollerMock{
+		mockUnrollContent: func(req UnrollEvent) UnrollResult {
+			report := newExpansionReport()
+			report.add(mainImage, reasonMissingModel, "639cd952-149f-11e7-2ea7-a07ecd9ac73f")
+			uc := req.c.clone()
+			uc.Embeds = []Embedded{&DynamicContent{}, nil}
+			return UnrollResult{uc, nil, report}
+		},
+	}
+	h := Handler{Service: &cu}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+
+	ok := requestsTotal.WithLabelValues(contentEndpoint, "200")
+	badRequest := requestsTotal.WithLabelValues(contentEndpoint, "400")
+	missingModel := expansionFailuresTotal.WithLabelValues(contentEndpoint, reasonMissingModel)
+	okBefore, badRequestBefore, missingModelBefore := testutil.ToFloat64(ok), testutil.ToFloat64(badRequest), testutil.ToFloat64(missingModel)
+
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	http.HandlerFunc(h.GetContent).ServeHTTP(httptest.NewRecorder(), req)
+
+	req, err = http.NewRequest(http.MethodPost, "/content", strings.NewReader("sample body"))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	http.HandlerFunc(h.GetContent).ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, okBefore+1, testutil.ToFloat64(ok))
+	assert.Equal(t, badRequestBefore+1, testutil.ToFloat64(badRequest))
+	assert.Equal(t, missingModelBefore+1, testutil.ToFloat64(missingModel))
+}
+
+func TestGet_UpstreamRequestMetrics(t *testing.T) {
+	ts := errorContentServerMock(t, http.StatusServiceUnavailable)
+	defer ts.Close()
+	cr := readerForTest(ts.URL, "")
+
+	unexpectedStatus := upstreamRequestsTotal.WithLabelValues("content-source-app-name", readerGet, outcomeUnexpectedStatus)
+	before := testutil.ToFloat64(unexpectedStatus)
+
+	_, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.Error(t, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(unexpectedStatus))
+}
+
+func TestStatusRecorder_PanicIsRecordedAs500(t *testing.T) {
+	sr := newStatusRecorder(httptest.NewRecorder())
+	failed := requestsTotal.WithLabelValues(internalContentEndpoint, "500")
+	before := testutil.ToFloat64(failed)
+
+	observeRequest(internalContentEndpoint, time.Now(), sr)
+	assert.Equal(t, before+1, testutil.ToFloat64(failed))
+}
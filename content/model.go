@@ -0,0 +1,624 @@
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// object is a JSON object whose members are kept as raw JSON, so that every field the
+// unroller doesn't interpret is written back as it was received, only in its canonical form.
+// An object is immutable once decoded: the methods changing its fields return a copy, so
+// decoded content can be shared between concurrent unrolls.
+type object struct {
+	// fields are sorted by key
+	fields []field
+	// invalid holds the verbatim value when it isn't a JSON object at all
+	invalid json.RawMessage
+}
+
+type field struct {
+	key   string
+	value json.RawMessage
+}
+
+// model is content decoded from JSON: the raw fields of its object, then the ones it interprets.
+type model interface {
+	raw() *object
+	decodeFields() error
+}
+
+func (o *object) raw() *object {
+	return o
+}
+
+// unmarshalModel decodes the JSON b into the model, writing its values canonically once so that
+// neither the model nor the models in its fields scan them again to decode or to write them.
+func unmarshalModel(b []byte, m model) error {
+	if err := m.raw().unmarshal(b); err != nil {
+		return err
+	}
+	return m.decodeFields()
+}
+
+// decodeModel decodes a model from the value of a field, which is already canonical.
+func decodeModel(b []byte, m model) error {
+	if err := m.raw().decodeCanonical(b); err != nil {
+		return err
+	}
+	return m.decodeFields()
+}
+
+// unmarshal writes the values of the fields canonically, one after the other in a single buffer,
+// and sorts the fields. A duplicate key keeps its last value, as when it's decoded.
+func (o *object) unmarshal(b []byte) error {
+	*o = object{}
+	buf := make([]byte, 0, len(b))
+	if jsonKind(b) != '{' {
+		invalid, err := appendCanonical(buf, b)
+		o.invalid = invalid
+		return err
+	}
+	n := 0
+	if err := eachMember(b, func([]byte, []byte) error { n++; return nil }); err != nil {
+		return err
+	}
+	o.fields = make([]field, 0, n)
+	// the values written before the buffer grows stay where they are, in the buffer it was
+	if _, err := appendCanonicalMembers(buf, b, func(key []byte, value []byte) {
+		o.fields = append(o.fields, field{string(key), value})
+	}); err != nil {
+		return err
+	}
+	slices.SortStableFunc(o.fields, func(a, b field) int { return strings.Compare(a.key, b.key) })
+	unique := o.fields[:0]
+	for i, f := range o.fields {
+		if i+1 < len(o.fields) && o.fields[i+1].key == f.key {
+			continue
+		}
+		unique = append(unique, f)
+	}
+	o.fields = unique
+	return nil
+}
+
+// decodeCanonical keeps the members of the canonical JSON b, whose keys are already sorted.
+func (o *object) decodeCanonical(b []byte) error {
+	*o = object{}
+	if jsonKind(b) != '{' {
+		o.invalid = b
+		return nil
+	}
+	n := 0
+	if err := eachMember(b, func([]byte, []byte) error { n++; return nil }); err != nil {
+		return err
+	}
+	o.fields = make([]field, 0, n)
+	return eachMember(b, func(key []byte, value []byte) error {
+		o.fields = append(o.fields, field{string(key), value})
+		return nil
+	})
+}
+
+// override is a field written from its model rather than from its raw JSON.
+type override struct {
+	key   string
+	value interface{}
+}
+
+// write writes the raw fields to buf, replacing the ones given in overrides. Keys are sorted, as
+// encoding/json does for maps, so the same content is always written the same: the overrides
+// must be given sorted by key.
+func (o object) write(buf *bytes.Buffer, overrides ...override) error {
+	if o.invalid != nil {
+		buf.Write(o.invalid)
+		return nil
+	}
+
+	buf.WriteByte('{')
+	for i, j := 0, 0; i < len(o.fields) || j < len(overrides); {
+		if i+j > 0 {
+			buf.WriteByte(',')
+		}
+		if j == len(overrides) || i < len(o.fields) && o.fields[i].key < overrides[j].key {
+			if err := writeKey(buf, o.fields[i].key); err != nil {
+				return err
+			}
+			buf.Write(o.fields[i].value)
+			i++
+			continue
+		}
+		if i < len(o.fields) && o.fields[i].key == overrides[j].key {
+			i++
+		}
+		if err := writeKey(buf, overrides[j].key); err != nil {
+			return err
+		}
+		if err := writeValue(buf, overrides[j].value); err != nil {
+			return err
+		}
+		j++
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// size is the length of the raw fields, to size the buffer the object is written to.
+func (o object) size() int {
+	if o.invalid != nil {
+		return len(o.invalid)
+	}
+	size := 2
+	for _, f := range o.fields {
+		size += len(f.key) + len(f.value) + 4
+	}
+	return size
+}
+
+func (o object) isObject() bool {
+	return o.invalid == nil
+}
+
+func (o object) has(key string) bool {
+	_, found := o.get(key)
+	return found
+}
+
+func (o object) get(key string) (json.RawMessage, bool) {
+	i, found := slices.BinarySearchFunc(o.fields, key, func(f field, key string) int { return strings.Compare(f.key, key) })
+	if !found {
+		return nil, false
+	}
+	return o.fields[i].value, true
+}
+
+func (o object) decodeString(key string) (string, bool) {
+	raw, found := o.field(key, '"')
+	if !found {
+		return "", false
+	}
+	s, err := unquote(raw)
+	return s, err == nil
+}
+
+// field returns the field only if it has the expected JSON kind ('{', '[' or '"').
+// A field of any other kind is left as it is, to be written back verbatim.
+func (o object) field(key string, kind byte) (json.RawMessage, bool) {
+	raw, found := o.get(key)
+	if !found || jsonKind(raw) != kind {
+		return nil, false
+	}
+	return raw, true
+}
+
+// with returns a copy of the object with the given fields set, sorted by key.
+func (o object) with(fields []field) object {
+	c := object{fields: make([]field, 0, len(o.fields)+len(fields))}
+	i, j := 0, 0
+	for i < len(o.fields) && j < len(fields) {
+		switch strings.Compare(o.fields[i].key, fields[j].key) {
+		case -1:
+			c.fields = append(c.fields, o.fields[i])
+			i++
+		case 0:
+			i++
+		case 1:
+			c.fields = append(c.fields, fields[j])
+			j++
+		}
+	}
+	c.fields = append(append(c.fields, o.fields[i:]...), fields[j:]...)
+	return c
+}
+
+// Content is a piece of content received from an upstream app. Only its id and type are decoded,
+// they are read-only views of the raw fields.
+type Content struct {
+	object
+	ID   string
+	Type string
+}
+
+func (c *Content) UnmarshalJSON(b []byte) error {
+	return unmarshalModel(b, c)
+}
+
+func (c *Content) decodeFields() error {
+	c.decodeRef()
+	return nil
+}
+
+func (c Content) MarshalJSON() ([]byte, error) {
+	return marshalJSON(c, c.size())
+}
+
+func (c Content) writeJSON(buf *bytes.Buffer) error {
+	return c.write(buf)
+}
+
+func (c *Content) decodeRef() {
+	c.ID, _ = c.decodeString(id)
+	c.Type, _ = c.decodeString(contentType)
+}
+
+// merged returns a copy of c with all the fields of src copied over its own.
+func (c Content) merged(src Content) Content {
+	m := Content{object: c.with(src.fields)}
+	m.decodeRef()
+	return m
+}
+
+func (c Content) asImageSet() *ImageSet {
+	is := &ImageSet{Content: c}
+	is.decodeMembers()
+	return is
+}
+
+func (c Content) asMediaResource() *MediaResource {
+	return &MediaResource{c}
+}
+
+func (c Content) asDynamicContent() *DynamicContent {
+	return &DynamicContent{c}
+}
+
+// Embedded is a model which can be embedded in the body of an article.
+type Embedded interface {
+	json.Marshaler
+	jsonWriter
+	embedded()
+}
+
+// MediaResource is a single image: a member of an ImageSet, a lead image or a promotional image.
+type MediaResource struct {
+	Content
+}
+
+// DynamicContent is an interactive piece of content embedded in the body of an article.
+type DynamicContent struct {
+	Content
+}
+
+func (dc *DynamicContent) embedded() {}
+
+func (mr *MediaResource) content() *Content {
+	if mr == nil {
+		return nil
+	}
+	return &mr.Content
+}
+
+// ImageSet is a set of alternative MediaResources for the same image.
+type ImageSet struct {
+	Content
+	Members []*MediaResource
+}
+
+func newImageSetPlaceholder(imageSetID string) *ImageSet {
+	raw, _ := json.Marshal(imageSetID)
+	is := &ImageSet{}
+	is.object = is.with([]field{{id, raw}})
+	is.ID = imageSetID
+	return is
+}
+
+func (is *ImageSet) embedded() {}
+
+func (is *ImageSet) content() *Content {
+	if is == nil {
+		return nil
+	}
+	return &is.Content
+}
+
+func (is *ImageSet) UnmarshalJSON(b []byte) error {
+	return unmarshalModel(b, is)
+}
+
+func (is *ImageSet) decodeFields() error {
+	is.decodeRef()
+	is.decodeMembers()
+	return nil
+}
+
+func (is ImageSet) MarshalJSON() ([]byte, error) {
+	return marshalJSON(is, is.size())
+}
+
+func (is ImageSet) writeJSON(buf *bytes.Buffer) error {
+	if is.Members == nil {
+		return is.write(buf)
+	}
+	return is.write(buf, override{members, is.Members})
+}
+
+func (is *ImageSet) decodeMembers() {
+	is.Members = nil
+	raw, found := is.field(members, '[')
+	if !found {
+		return
+	}
+	ms := []*MediaResource{}
+	err := eachElement(raw, func(elem []byte) error {
+		var mr *MediaResource
+		if jsonKind(elem) != 'n' {
+			mr = &MediaResource{}
+			if err := decodeModel(elem, mr); err != nil {
+				return err
+			}
+		}
+		ms = append(ms, mr)
+		return nil
+	})
+	if err == nil {
+		is.Members = ms
+	}
+}
+
+func (is *ImageSet) memberUUIDs() []string {
+	uuids := []string{}
+	for _, m := range is.Members {
+		if m == nil || m.ID == "" {
+			continue
+		}
+		u, err := extractUUIDFromString(m.ID)
+		if err != nil {
+			continue
+		}
+		uuids = append(uuids, u)
+	}
+	return uuids
+}
+
+// LeadImage is an image of the article, as listed in its leadImages.
+type LeadImage struct {
+	Content
+	Image *MediaResource
+}
+
+func (li *LeadImage) content() *Content {
+	if li == nil {
+		return nil
+	}
+	return &li.Content
+}
+
+func (li *LeadImage) UnmarshalJSON(b []byte) error {
+	return unmarshalModel(b, li)
+}
+
+func (li *LeadImage) decodeFields() error {
+	li.decodeRef()
+	if raw, found := li.field(image, '{'); found {
+		li.Image = &MediaResource{}
+		return decodeModel(raw, li.Image)
+	}
+	return nil
+}
+
+func (li LeadImage) MarshalJSON() ([]byte, error) {
+	return marshalJSON(li, li.size())
+}
+
+func (li LeadImage) writeJSON(buf *bytes.Buffer) error {
+	if li.Image == nil {
+		return li.write(buf)
+	}
+	return li.write(buf, override{image, li.Image})
+}
+
+// AlternativeImages holds the images of an article used outside of its page, like the promotional image.
+type AlternativeImages struct {
+	object
+	PromotionalImage *MediaResource
+}
+
+func (ai *AlternativeImages) UnmarshalJSON(b []byte) error {
+	return unmarshalModel(b, ai)
+}
+
+func (ai *AlternativeImages) decodeFields() error {
+	if raw, found := ai.field(promotionalImage, '{'); found {
+		ai.PromotionalImage = &MediaResource{}
+		return decodeModel(raw, ai.PromotionalImage)
+	}
+	return nil
+}
+
+func (ai AlternativeImages) MarshalJSON() ([]byte, error) {
+	return marshalJSON(ai, ai.size())
+}
+
+func (ai AlternativeImages) writeJSON(buf *bytes.Buffer) error {
+	if ai.PromotionalImage == nil {
+		return ai.write(buf)
+	}
+	return ai.write(buf, override{promotionalImage, ai.PromotionalImage})
+}
+
+// Article is the content supplied for unrolling. The fields that can be expanded are decoded
+// when they have the expected shape, all the others are kept verbatim.
+type Article struct {
+	Content
+	BodyXML           *string
+	MainImage         *ImageSet
+	AlternativeImages *AlternativeImages
+	LeadImages        []*LeadImage
+	Embeds            []Embedded
+}
+
+func (a *Article) UnmarshalJSON(b []byte) error {
+	return unmarshalModel(b, a)
+}
+
+func (a *Article) decodeFields() error {
+	a.decodeRef()
+	if body, ok := a.decodeString(bodyXML); ok {
+		a.BodyXML = &body
+	}
+	if raw, found := a.field(mainImage, '{'); found {
+		a.MainImage = &ImageSet{}
+		if err := decodeModel(raw, a.MainImage); err != nil {
+			return err
+		}
+	}
+	if raw, found := a.field(altImages, '{'); found {
+		a.AlternativeImages = &AlternativeImages{}
+		if err := decodeModel(raw, a.AlternativeImages); err != nil {
+			return err
+		}
+	}
+	if raw, found := a.field(leadImages, '['); found {
+		a.LeadImages = []*LeadImage{}
+		return eachElement(raw, func(elem []byte) error {
+			var li *LeadImage
+			if jsonKind(elem) != 'n' {
+				li = &LeadImage{}
+				if err := decodeModel(elem, li); err != nil {
+					return err
+				}
+			}
+			a.LeadImages = append(a.LeadImages, li)
+			return nil
+		})
+	}
+	return nil
+}
+
+func (a Article) MarshalJSON() ([]byte, error) {
+	return marshalJSON(a, a.size())
+}
+
+// writeJSON writes the expanded fields over the raw ones, in the order of their keys.
+func (a Article) writeJSON(buf *bytes.Buffer) error {
+	overrides := make([]override, 0, 4)
+	if a.AlternativeImages != nil {
+		overrides = append(overrides, override{altImages, a.AlternativeImages})
+	}
+	if a.Embeds != nil {
+		overrides = append(overrides, override{embeds, a.Embeds})
+	}
+	if a.LeadImages != nil {
+		overrides = append(overrides, override{leadImages, a.LeadImages})
+	}
+	if a.MainImage != nil {
+		overrides = append(overrides, override{mainImage, a.MainImage})
+	}
+	return a.write(buf, overrides...)
+}
+
+// clone returns a copy of the article whose expanded fields can be replaced. The raw fields and
+// the nested models are shared, as they are never modified.
+func (a *Article) clone() *Article {
+	clone := *a
+	return &clone
+}
+
+// writeKey writes the key as canonicalJSON does, without escaping HTML.
+func writeKey(buf *bytes.Buffer, k string) error {
+	for i := 0; i < len(k); i++ {
+		if c := k[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			b, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			if b, err = appendCanonicalString(nil, b); err != nil {
+				return err
+			}
+			buf.Write(b)
+			buf.WriteByte(':')
+			return nil
+		}
+	}
+	buf.WriteByte('"')
+	buf.WriteString(k)
+	buf.WriteString(`":`)
+	return nil
+}
+
+// jsonWriter is a model written straight to the buffer of the model it's in, as its raw fields
+// are valid JSON which needs no validation.
+type jsonWriter interface {
+	writeJSON(buf *bytes.Buffer) error
+}
+
+// marshalJSON writes the model to a buffer of the size of its raw fields.
+func marshalJSON(m jsonWriter, size int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if err := m.writeJSON(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeValue writes the models and the lists of models of the overrides. Anything else is written
+// by encoding/json without escaping HTML, as the raw fields are.
+func writeValue(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case jsonWriter:
+		return v.writeJSON(buf)
+	case []*MediaResource:
+		return writeList(buf, len(v), func(i int) jsonWriter {
+			if v[i] == nil {
+				return nil
+			}
+			return v[i]
+		})
+	case []*LeadImage:
+		return writeList(buf, len(v), func(i int) jsonWriter {
+			if v[i] == nil {
+				return nil
+			}
+			return v[i]
+		})
+	case []Embedded:
+		return writeList(buf, len(v), func(i int) jsonWriter {
+			if v[i] == nil || reflect.ValueOf(v[i]).IsNil() {
+				return nil
+			}
+			return v[i]
+		})
+	}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// writeList writes the n models given by model, null for a nil one.
+func writeList(buf *bytes.Buffer, n int, model func(i int) jsonWriter) error {
+	buf.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		m := model(i)
+		if m == nil {
+			buf.WriteString("null")
+			continue
+		}
+		if err := m.writeJSON(buf); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+// jsonKind returns the first character of a JSON value, which identifies its kind.
+func jsonKind(b []byte) byte {
+	for _, c := range b {
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		default:
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+package content
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArticle_RoundTripPreservesUnknownFields(t *testing.T) {
+	body := `{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","type":"http://www.ft.com/ontology/content/Article","title":"Title é","standout":{"scoop":false,"editorsChoice":true},"mainImage":{"id":"http://api.ft.com/content/639cd952-149f-11e7-2ea7-a07ecd9ac73f","extra":[1,2.50,null]},"alternativeImages":{"promotionalImage":{"id":"http://api.ft.com/content/4723cb4e-027c-11e7-ace0-1ce02ef0def9"},"other":"value"},"leadImages":[{"id":"http://api.ft.com/content/89f194c8-13bc-11e7-80f4-13e067d5072c","type":"square"}]}`
+
+	var a Article
+	err := json.Unmarshal([]byte(body), &a)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76", a.ID)
+	assert.NotNil(t, a.MainImage)
+	assert.NotNil(t, a.AlternativeImages.PromotionalImage)
+	assert.Len(t, a.LeadImages, 1)
+
+	actual, err := json.Marshal(a)
+	assert.NoError(t, err)
+	assert.JSONEq(t, body, string(actual))
+	assert.Contains(t, string(actual), `"title":"Title é"`)
+	assert.Contains(t, string(actual), `"extra":[1,2.50,null]`)
+}
+
+func TestArticle_MarshalIsCanonical(t *testing.T) {
+	body := "{\n  \"title\": \"<b>T</b>\",\n  \"id\": \"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76\",\n  \"standout\": { \"scoop\": false, \"editorsChoice\": true }\n}"
+
+	var a Article
+	assert.NoError(t, json.Unmarshal([]byte(body), &a))
+	actual, err := a.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","standout":{"editorsChoice":true,"scoop":false},"title":"<b>T</b>"}`, string(actual))
+}
+
+func TestArticle_MarshalIsTheCanonicalJSONOfWhatWasDecoded(t *testing.T) {
+	docs := []string{
+		`{"b":1,"a":{"d":[{"f":null,"e":"\u003c"}],"c":2},"b":3}`,
+		`{"mainImage":{"members":[null,{"id":"x","b":1},"y"],"id":"z"},"leadImages":[{"image":{"b":1,"a":2}},null]}`,
+		`{"alternativeImages":{"promotionalImage":{"z":1,"a":2}},"bodyXML":"\"<p>\"\n"}`,
+	}
+	for _, fixture := range benchmarkFixtures {
+		body, err := ioutil.ReadFile("../test-resources/" + fixture)
+		assert.NoError(t, err, fixture)
+		docs = append(docs, string(body))
+	}
+
+	for _, doc := range docs {
+		var a Article
+		if !assert.NoError(t, json.Unmarshal([]byte(doc), &a), doc) {
+			continue
+		}
+		actual, err := a.MarshalJSON()
+		assert.NoError(t, err, doc)
+		expected, err := canonicalJSON([]byte(doc))
+		assert.NoError(t, err, doc)
+		assert.Equal(t, string(expected), string(actual), doc)
+	}
+}
+
+func TestArticle_ExpandedModelsAreCanonical(t *testing.T) {
+	var is ImageSet
+	assert.NoError(t, json.Unmarshal([]byte(`{"id":"http://api.ft.com/content/639cd952-149f-11e7-2ea7-a07ecd9ac73f","title":"a < b","members":[{"id":"http://api.ft.com/content/0261ea4a-1474-11e7-1e92-847abda1ac65"}]}`), &is))
+	var member MediaResource
+	assert.NoError(t, json.Unmarshal([]byte(`{"title":"\u003ci>", "id":"http://api.ft.com/content/0261ea4a-1474-11e7-1e92-847abda1ac65"}`), &member))
+	is.Members = []*MediaResource{&member}
+
+	actual, err := is.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"http://api.ft.com/content/639cd952-149f-11e7-2ea7-a07ecd9ac73f","members":[{"id":"http://api.ft.com/content/0261ea4a-1474-11e7-1e92-847abda1ac65","title":"<i>"}],"title":"a < b"}`, string(actual))
+}
+
+func TestArticle_MalformedFieldsAreKeptVerbatim(t *testing.T) {
+	body := `{"id":"http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76","bodyXML":42,"mainImage":"not an object","alternativeImages":[],"leadImages":{"id":"x"}}`
+
+	var a Article
+	err := json.Unmarshal([]byte(body), &a)
+	assert.NoError(t, err)
+	assert.Nil(t, a.BodyXML)
+	assert.Nil(t, a.MainImage)
+	assert.Nil(t, a.AlternativeImages)
+	assert.Nil(t, a.LeadImages)
+
+	actual, err := json.Marshal(a)
+	assert.NoError(t, err)
+	assert.JSONEq(t, body, string(actual))
+}
+
+func TestImageSet_NonObjectIsKeptVerbatim(t *testing.T) {
+	var is ImageSet
+	err := json.Unmarshal([]byte(`"not an object"`), &is)
+	assert.NoError(t, err)
+	assert.False(t, is.isObject())
+
+	actual, err := json.Marshal(is)
+	assert.NoError(t, err)
+	assert.Equal(t, `"not an object"`, string(actual))
+}
+
+var benchmarkFixtures = []string{
+	"content-valid-request.json",
+	"content-valid-response.json",
+	"internalcontent-valid-request.json",
+	"internalcontentpreview-valid-response.json",
+}
+
+// BenchmarkArticleRoundTrip compares decoding and re-encoding the fixtures as generic maps,
+// as the unroller used to, with the raw passthrough of Article.
+func BenchmarkArticleRoundTrip(b *testing.B) {
+	for _, fixture := range benchmarkFixtures {
+		body, err := ioutil.ReadFile("../test-resources/" + fixture)
+		if err != nil {
+			b.Fatalf("Cannot read fixture %s: %v", fixture, err)
+		}
+
+		b.Run(fixture+"/map", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				var m map[string]interface{}
+				if err := json.Unmarshal(body, &m); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := json.Marshal(m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fixture+"/article", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				var a Article
+				if err := json.Unmarshal(body, &a); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := a.MarshalJSON(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package content
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultOutputCacheTTL        = 30 * time.Second
+	defaultOutputCacheMaxEntries = 1000
+)
+
+// OutputCacheConfig configures the short-lived cache of the unrolled responses, by endpoint and
+// canonical input, so that the same article posted again isn't unrolled again.
+type OutputCacheConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"maxEntries"`
+}
+
+// withDefaults returns the config with the defaults of the settings which aren't set.
+func (c OutputCacheConfig) withDefaults() OutputCacheConfig {
+	if c.TTL == 0 {
+		c.TTL = defaultOutputCacheTTL
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = defaultOutputCacheMaxEntries
+	}
+	return c
+}
+
+func (c OutputCacheConfig) validate() error {
+	if c.TTL < 0 || c.MaxEntries < 0 {
+		return errors.New("outputCache.ttl and outputCache.maxEntries can't be negative")
+	}
+	return nil
+}
+
+// unrolledOutput is an unrolled response, as it was written, with the UUIDs of the models it expanded.
+type unrolledOutput struct {
+	key    string
+	body   []byte
+	etag   string
+	report string
+	uuids  map[string]bool
+	stored time.Time
+}
+
+// OutputCache keeps the unrolled responses for the TTL, evicting the least recently used ones when it is full.
+// The responses depend on the rest of the config too, so any config change empties it.
+type OutputCache struct {
+	mu      sync.Mutex
+	config  OutputCacheConfig
+	outputs map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+func NewOutputCache(cfg OutputCacheConfig) *OutputCache {
+	return &OutputCache{config: cfg.withDefaults(), outputs: make(map[string]*list.Element), lru: list.New(), now: time.Now}
+}
+
+func (c *OutputCache) UpdateConfig(cfg OutputCacheConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = cfg.withDefaults()
+	c.outputs = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// key identifies the input of an endpoint by the hash of its canonical JSON, it is empty when the cache is disabled.
+func (c *OutputCache) key(endpoint string, input []byte) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	enabled := c.config.Enabled
+	c.mu.Unlock()
+	if !enabled {
+		return ""
+	}
+	canonical, err := canonicalJSON(input)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(endpoint + "\n"))
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *OutputCache) get(key string) (unrolledOutput, bool) {
+	if c == nil || key == "" {
+		return unrolledOutput{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.outputs[key]
+	if !found {
+		return unrolledOutput{}, false
+	}
+	o := e.Value.(*unrolledOutput)
+	if c.now().Sub(o.stored) >= c.config.TTL {
+		c.remove(e)
+		return unrolledOutput{}, false
+	}
+	c.lru.MoveToFront(e)
+	return *o, true
+}
+
+func (c *OutputCache) set(o unrolledOutput) {
+	if c == nil || o.key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.config.Enabled {
+		return
+	}
+	if e, found := c.outputs[o.key]; found {
+		c.remove(e)
+	}
+	o.stored = c.now()
+	c.outputs[o.key] = c.lru.PushFront(&o)
+	for c.lru.Len() > c.config.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// purge removes the outputs which expanded the models of the UUID, returning how many there were.
+func (c *OutputCache) purge(uuid string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := 0
+	for _, e := range c.outputs {
+		if e.Value.(*unrolledOutput).uuids[uuid] {
+			c.remove(e)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (c *OutputCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.outputs, e.Value.(*unrolledOutput).key)
+}
+
+// outputETag is a strong ETag of the response, the same output always has the same one.
+func outputETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified tells whether the If-None-Match of the request has the ETag, weak or not, of any encoding.
+func notModified(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = decodedETag(strings.TrimPrefix(strings.TrimSpace(tag), "W/"))
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
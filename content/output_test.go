@@ -0,0 +1,70 @@
+package content
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputCache_EntriesExpireAfterTTL(t *testing.T) {
+	now := time.Now()
+	c := NewOutputCache(OutputCacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 1})
+	c.now = func() time.Time { return now }
+	key := c.key(contentEndpoint, []byte(`{"id":"a"}`))
+	assert.NotEqual(t, key, c.key(internalContentEndpoint, []byte(`{"id":"a"}`)), "The endpoints should be cached separately")
+	c.set(unrolledOutput{key: key, body: []byte("{}")})
+
+	_, found := c.get(key)
+	assert.True(t, found)
+	now = now.Add(time.Minute)
+	_, found = c.get(key)
+	assert.False(t, found)
+
+	c.set(unrolledOutput{key: key, body: []byte("{}")})
+	c.set(unrolledOutput{key: "other", body: []byte("{}")})
+	_, found = c.get(key)
+	assert.False(t, found, "The least recently used output should be evicted")
+
+	c.UpdateConfig(OutputCacheConfig{Enabled: true})
+	_, found = c.get("other")
+	assert.False(t, found, "A config change should empty the cache")
+	c.UpdateConfig(OutputCacheConfig{})
+	assert.Empty(t, c.key(contentEndpoint, []byte(`{"id":"a"}`)), "A disabled cache has no keys")
+}
+
+func TestOutputCache_Purge(t *testing.T) {
+	c := NewOutputCache(OutputCacheConfig{Enabled: true})
+	c.set(unrolledOutput{key: "a", uuids: map[string]bool{"image": true, "member": true}})
+	c.set(unrolledOutput{key: "b", uuids: map[string]bool{"member": true}})
+	c.set(unrolledOutput{key: "c", uuids: map[string]bool{"other": true}})
+
+	assert.Equal(t, 2, c.purge("member"))
+	_, found := c.get("a")
+	assert.False(t, found)
+	_, found = c.get("c")
+	assert.True(t, found, "The outputs which didn't expand the UUID should be kept")
+	assert.Zero(t, c.purge("unknown"))
+
+	var disabled *OutputCache
+	assert.Zero(t, disabled.purge("member"))
+}
+
+func TestNotModified(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/content", nil)
+	assert.False(t, notModified(r, `"abc"`))
+	r.Header.Set("If-None-Match", `"xyz", W/"abc"`)
+	assert.True(t, notModified(r, `"abc"`))
+	r.Header.Set("If-None-Match", `*`)
+	assert.True(t, notModified(r, `"abc"`))
+}
+
+func TestLoadConfig_OutputCache(t *testing.T) {
+	cfg, err := parseConfig([]byte("outputCache:\n  enabled: true\n  ttl: 10s\n"), defaultConfigForTest())
+	assert.NoError(t, err)
+	assert.Equal(t, OutputCacheConfig{Enabled: true, TTL: 10 * time.Second}, cfg.OutputCache)
+	_, err = parseConfig([]byte("outputCache:\n  ttl: -1s\n"), defaultConfigForTest())
+	assert.Error(t, err)
+}
@@ -0,0 +1,234 @@
+package content
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const rateLimitSweepInterval = time.Minute
+
+// RateLimitConfig limits the requests of each client to the unroll endpoints. The identified clients
+// are limited by their identity and the others by their IP address.
+type RateLimitConfig struct {
+	// Default applies to the endpoints which have no limit of their own
+	Default   RateLimit            `yaml:"default" json:"default"`
+	Endpoints map[string]RateLimit `yaml:"endpoints" json:"endpoints,omitempty"`
+	// ClientIPHeader, e.g. X-Forwarded-For, has the address of the client when the requests come through a proxy
+	ClientIPHeader string `yaml:"clientIPHeader" json:"clientIPHeader,omitempty"`
+}
+
+// RateLimit is a token bucket, refilled with Rate tokens per second up to Burst tokens. A zero rate is no limit.
+type RateLimit struct {
+	Rate float64 `yaml:"rate" json:"rate"`
+	// Burst is the rate rounded up by default
+	Burst int `yaml:"burst" json:"burst"`
+}
+
+func (l RateLimit) limit() rate.Limit {
+	if l.Rate == 0 {
+		return rate.Inf
+	}
+	return rate.Limit(l.Rate)
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst == 0 {
+		return int(math.Ceil(l.Rate))
+	}
+	return l.Burst
+}
+
+func (c RateLimitConfig) forEndpoint(endpoint string) RateLimit {
+	if l, found := c.Endpoints[endpoint]; found {
+		return l
+	}
+	return c.Default
+}
+
+func (c RateLimitConfig) validate() error {
+	limits := map[string]RateLimit{"default": c.Default}
+	for endpoint, l := range c.Endpoints {
+		if !contains(unrollEndpoints, endpoint) {
+			return errors.Errorf("unknown endpoint %q in rateLimits.endpoints, it should be one of: %s", endpoint, strings.Join(unrollEndpoints, ", "))
+		}
+		limits["endpoints."+endpoint] = l
+	}
+	for name, l := range limits {
+		if l.Rate < 0 || l.Burst < 0 {
+			return errors.Errorf("rateLimits.%s can't be negative", name)
+		}
+	}
+	return nil
+}
+
+// RateLimiter rejects the requests of the clients which are over their limit with a 429.
+type RateLimiter struct {
+	mu        sync.Mutex
+	config    RateLimitConfig
+	buckets   map[bucketKey]*rate.Limiter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucketKey struct {
+	endpoint string
+	client   string
+}
+
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{config: cfg, buckets: make(map[bucketKey]*rate.Limiter), now: time.Now}
+}
+
+// UpdateConfig applies the new limits to the buckets of the clients, keeping their tokens.
+func (l *RateLimiter) UpdateConfig(cfg RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = cfg
+	now := l.now()
+	for key, bucket := range l.buckets {
+		limit := cfg.forEndpoint(key.endpoint)
+		if limit.Rate == 0 {
+			delete(l.buckets, key)
+			continue
+		}
+		bucket.SetLimitAt(now, limit.limit())
+		bucket.SetBurstAt(now, limit.burst())
+	}
+}
+
+// Handler is a middleware limiting the requests to the unroll endpoints, it needs the client
+// identified by InboundAuth.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := strings.TrimPrefix(r.URL.Path, "/")
+		if !contains(unrollEndpoints, endpoint) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := clientID(r.Context())
+		if retryAfter := l.reserve(endpoint, l.limitedClient(r, client)); retryAfter > 0 {
+			tid := transactionidutils.GetTransactionIDFromRequest(r)
+			logger.log.WithFields(logrus.Fields{"tid": tid, "request_url": r.RequestURI, "client_id": client}).
+				Warn("Request rejected, the client is over its rate limit")
+			rateLimitedTotal.WithLabelValues(endpoint, client).Inc()
+
+			msg, _ := json.Marshal(ErrorMessage{Message: "Too many requests"})
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write(msg)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitedClient returns the identity of the client, or its IP address if it is anonymous.
+func (l *RateLimiter) limitedClient(r *http.Request, client string) string {
+	if client != anonymousClient {
+		return client
+	}
+	l.mu.Lock()
+	header := l.config.ClientIPHeader
+	l.mu.Unlock()
+	if header != "" {
+		// the first address is the one of the client, the proxies add theirs after it
+		if ip := strings.TrimSpace(strings.Split(r.Header.Get(header), ",")[0]); ip != "" {
+			return "ip:" + ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// reserve takes a token from the bucket of the client, or returns how long the client should wait for one.
+func (l *RateLimiter) reserve(endpoint string, client string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	limit := l.config.forEndpoint(endpoint)
+	if limit.Rate == 0 {
+		return 0
+	}
+	key := bucketKey{endpoint, client}
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = rate.NewLimiter(limit.limit(), limit.burst())
+		l.buckets[key] = bucket
+	}
+
+	reservation := bucket.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// sweep removes the full buckets, which are the same as new ones, so that the clients which are gone are forgotten.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.TokensAt(now) >= float64(bucket.Burst()) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type rateLimitState struct {
+	Limits  RateLimitConfig   `json:"limits"`
+	Buckets []rateLimitBucket `json:"buckets"`
+}
+
+type rateLimitBucket struct {
+	Endpoint string  `json:"endpoint"`
+	Client   string  `json:"client"`
+	Tokens   float64 `json:"tokens"`
+	Rate     float64 `json:"rate"`
+	Burst    int     `json:"burst"`
+}
+
+// StateHandler responds with the limits and the buckets of the clients seen recently.
+func (l *RateLimiter) StateHandler(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	now := l.now()
+	state := rateLimitState{Limits: l.config, Buckets: []rateLimitBucket{}}
+	for key, bucket := range l.buckets {
+		state.Buckets = append(state.Buckets, rateLimitBucket{
+			Endpoint: key.endpoint,
+			Client:   key.client,
+			Tokens:   bucket.TokensAt(now),
+			Rate:     float64(bucket.Limit()),
+			Burst:    bucket.Burst(),
+		})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(state.Buckets, func(i, j int) bool {
+		a, b := state.Buckets[i], state.Buckets[j]
+		return a.Endpoint < b.Endpoint || a.Endpoint == b.Endpoint && a.Client < b.Client
+	})
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(state)
+}
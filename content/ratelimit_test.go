@@ -0,0 +1,104 @@
+package content
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rateLimiterForTest(cfg RateLimitConfig) (*RateLimiter, *time.Time) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(cfg)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func rateLimitedRequestForTest(h http.Handler, path string, client string, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.RemoteAddr = remoteAddr
+	if client != "" {
+		req = req.WithContext(withClientID(req.Context(), client))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimiter_RejectsClientsOverTheirLimit(t *testing.T) {
+	l, now := rateLimiterForTest(RateLimitConfig{
+		Default:   RateLimit{Rate: 1, Burst: 2},
+		Endpoints: map[string]RateLimit{contentPreviewEndpoint: {Rate: 0.5}},
+	})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content", "publisher", "10.0.0.1:1234").Code, "The burst should be allowed")
+	}
+	w := rateLimitedRequestForTest(h, "/content", "publisher", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content", "renderer", "10.0.0.1:1234").Code, "Each client should have its own limit")
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content", "", "10.0.0.1:1234").Code, "Anonymous clients should be limited by IP")
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/internalcontent", "publisher", "10.0.0.1:1234").Code, "Each endpoint should have its own limit")
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/__gtg", "publisher", "10.0.0.1:1234").Code, "Only the unroll endpoints should be limited")
+
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content-preview", "publisher", "10.0.0.1:1234").Code)
+	w = rateLimitedRequestForTest(h, "/content-preview", "publisher", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "The endpoint limit should replace the default one")
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	*now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content", "publisher", "10.0.0.1:1234").Code, "The bucket should be refilled")
+}
+
+func TestRateLimiter_ClientIPHeader(t *testing.T) {
+	l, _ := rateLimiterForTest(RateLimitConfig{Default: RateLimit{Rate: 1}, ClientIPHeader: "X-Forwarded-For"})
+	req := httptest.NewRequest(http.MethodPost, "/content", nil)
+	req.Header.Set("X-Forwarded-For", "192.0.2.1, 10.0.0.1")
+	assert.Equal(t, "ip:192.0.2.1", l.limitedClient(req, anonymousClient))
+	assert.Equal(t, "publisher", l.limitedClient(req, "publisher"))
+
+	l.UpdateConfig(RateLimitConfig{Default: RateLimit{Rate: 1}})
+	assert.Equal(t, "ip:192.0.2.1", l.limitedClient(req, anonymousClient), "The remote address of httptest requests is 192.0.2.1")
+	req.RemoteAddr = "198.51.100.7:4321"
+	assert.Equal(t, "ip:198.51.100.7", l.limitedClient(req, anonymousClient))
+}
+
+func TestRateLimiter_UpdateConfigAndState(t *testing.T) {
+	l, now := rateLimiterForTest(RateLimitConfig{Default: RateLimit{Rate: 1, Burst: 1}})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content", "publisher", "10.0.0.1:1234").Code)
+	assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequestForTest(h, "/content", "publisher", "10.0.0.1:1234").Code)
+
+	l.UpdateConfig(RateLimitConfig{Default: RateLimit{Rate: 10, Burst: 5}})
+	*now = now.Add(100 * time.Millisecond)
+	assert.Equal(t, http.StatusOK, rateLimitedRequestForTest(h, "/content", "publisher", "10.0.0.1:1234").Code, "The new limit should apply to the existing buckets")
+
+	w := httptest.NewRecorder()
+	l.StateHandler(w, httptest.NewRequest(http.MethodGet, "/rate-limits", nil))
+	var state rateLimitState
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 5}, state.Limits.Default)
+	if assert.Len(t, state.Buckets, 1) {
+		assert.Equal(t, rateLimitBucket{Endpoint: contentEndpoint, Client: "publisher", Tokens: 0, Rate: 10, Burst: 5}, state.Buckets[0])
+	}
+
+	*now = now.Add(time.Minute)
+	l.reserve(contentEndpoint, "renderer")
+	assert.NotContains(t, l.buckets, bucketKey{contentEndpoint, "publisher"}, "A full bucket should be forgotten")
+
+	l.UpdateConfig(RateLimitConfig{})
+	assert.Empty(t, l.buckets, "The buckets should be removed when there is no limit")
+}
+
+func TestLoadConfig_InvalidRateLimits(t *testing.T) {
+	_, err := parseConfig([]byte("rateLimits:\n  endpoints:\n    unknown: {rate: 1}\n"), defaultConfigForTest())
+	assert.Error(t, err)
+	_, err = parseConfig([]byte("rateLimits:\n  default: {rate: -1}\n"), defaultConfigForTest())
+	assert.Error(t, err)
+}
@@ -1,15 +1,18 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"sync"
+	"time"
 
 	"github.com/Financial-Times/transactionid-utils-go"
-	"github.com/Financial-Times/uuid-utils-go"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -18,13 +21,13 @@ const (
 )
 
 type Reader interface {
-	Get([]string, string) (map[string]Content, error)
-	GetInternal([]string, string) (map[string]Content, error)
-	GetPreview([]string, string) (map[string]Content, error)
-	GetInternalPreview([]string, string) (map[string]Content, error)
+	Get(context.Context, []string, string) (map[string]Content, error)
+	GetInternal(context.Context, []string, string) (map[string]Content, error)
+	GetPreview(context.Context, []string, string) (map[string]Content, error)
+	GetInternalPreview(context.Context, []string, string) (map[string]Content, error)
 }
 
-type ReaderFunc func([]string, string) (map[string]Content, error)
+type ReaderFunc func(context.Context, []string, string) (map[string]Content, error)
 
 type ReaderConfig struct {
 	ContentStoreAppName         string
@@ -33,35 +36,92 @@ type ReaderConfig struct {
 	ContentPreviewHost          string
 	ContentPathEndpoint         string
 	InternalContentPathEndpoint string
+	// ContentStoreHosts and ContentPreviewHosts replace the single hosts when they have a list of hosts
+	ContentStoreHosts   HostPoolConfig
+	ContentPreviewHosts HostPoolConfig
+	ContentStoreAuth    AuthConfig
+	ContentPreviewAuth  AuthConfig
+	// The bulkheads limit the requests to each app made concurrently by all the unroll requests
+	ContentStoreBulkhead   BulkheadConfig
+	ContentPreviewBulkhead BulkheadConfig
+	// Timeout limits each upstream request, on top of the timeout of the client
+	Timeout time.Duration
+	Cache   CacheConfig
+	// MaxBodySize limits the decompressed upstream responses, in bytes, 0 doesn't limit them
+	MaxBodySize int64
 }
 
 type ContentReader struct {
-	client *http.Client
-	config ReaderConfig
+	client          *http.Client
+	cache           *modelCache
+	validators      *responseValidators
+	storeHosts      *hostPool
+	previewHosts    *hostPool
+	storeBulkhead   *bulkhead
+	previewBulkhead *bulkhead
+	mu              sync.RWMutex
+	config          ReaderConfig
+	// background is the context of the revalidations, revalidating has the models being read again
+	background   context.Context
+	revalidating map[string]bool
 }
 
 func NewContentReader(rConfig ReaderConfig, client *http.Client) *ContentReader {
 	return &ContentReader{
-		client: client,
-		config: rConfig,
+		client:       client,
+		cache:        newModelCache(rConfig.Cache),
+		validators:   newResponseValidators(rConfig.Cache),
+		storeHosts:   newHostPool(rConfig.ContentStoreAppName, rConfig.ContentStoreHosts.withSingleHost(rConfig.ContentStoreHost)),
+		previewHosts: newHostPool(rConfig.ContentPreviewAppName, rConfig.ContentPreviewHosts.withSingleHost(rConfig.ContentPreviewHost)),
+		config:       rConfig,
+		background:   context.Background(),
+		revalidating: make(map[string]bool),
+
+		storeBulkhead:   newBulkhead(rConfig.ContentStoreAppName, rConfig.ContentStoreBulkhead),
+		previewBulkhead: newBulkhead(rConfig.ContentPreviewAppName, rConfig.ContentPreviewBulkhead),
 	}
 }
 
+// UpdateConfig applies a new config to the following requests, the in-flight ones keep the current config.
+func (cr *ContentReader) UpdateConfig(rConfig ReaderConfig) {
+	cr.mu.Lock()
+	cr.config = rConfig
+	cr.mu.Unlock()
+	cr.cache.configure(rConfig.Cache)
+	cr.validators.configure(rConfig.Cache)
+	cr.storeHosts.configure(rConfig.ContentStoreHosts.withSingleHost(rConfig.ContentStoreHost))
+	cr.previewHosts.configure(rConfig.ContentPreviewHosts.withSingleHost(rConfig.ContentPreviewHost))
+	cr.storeBulkhead.configure(rConfig.ContentStoreBulkhead)
+	cr.previewBulkhead.configure(rConfig.ContentPreviewBulkhead)
+}
+
+// SetBackgroundContext sets the context of the background revalidations, they are cancelled once it's done.
+func (cr *ContentReader) SetBackgroundContext(ctx context.Context) {
+	cr.mu.Lock()
+	cr.background = ctx
+	cr.mu.Unlock()
+}
+
+func (cr *ContentReader) currentConfig() ReaderConfig {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.config
+}
+
 // Get reads content from content-public-read
-func (cr *ContentReader) Get(uuids []string, tid string) (map[string]Content, error) {
+func (cr *ContentReader) Get(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
 	var cm = make(map[string]Content)
-	requestURL := fmt.Sprintf("%s%s", cr.config.ContentStoreHost, cr.config.ContentPathEndpoint)
+	cfg := cr.currentConfig()
 
-	contentBatch, err := cr.doGet(uuids, tid, requestURL, cr.config.ContentStoreAppName)
+	err := cr.doGetCached(ctx, cfg, uuids, tid, cfg.ContentPathEndpoint, readerGet, cm)
 	if err != nil {
 		return cm, err
 	}
 
 	var imgModelUUIDs []string
-	for _, c := range contentBatch {
-		cr.addItemToMap(c, cm)
-		if _, foundMembers := c[members]; foundMembers {
-			imgModelUUIDs = append(imgModelUUIDs, c.getMembersUUID()...)
+	for _, uuid := range uuids {
+		if c, found := cm[uuid]; found && c.has(members) {
+			imgModelUUIDs = append(imgModelUUIDs, c.asImageSet().memberUUIDs()...)
 		}
 	}
 
@@ -69,56 +129,59 @@ func (cr *ContentReader) Get(uuids []string, tid string) (map[string]Content, er
 		return cm, nil
 	}
 
-	imgModelsList, err := cr.doGet(imgModelUUIDs, tid, requestURL, cr.config.ContentStoreAppName)
-	if err != nil {
-		return cm, err
-	}
-
-	for _, i := range imgModelsList {
-		cr.addItemToMap(i, cm)
+	if !budgetOf(ctx).allowsDepth(ctx, depthMembers) {
+		return cm, nil
 	}
-
-	return cm, nil
+	err = cr.doGetCached(ctx, cfg, imgModelUUIDs, tid, cfg.ContentPathEndpoint, readerGet, cm)
+	return cm, err
 }
 
 // GetInternal reads internal components from content-public-read
-func (cr *ContentReader) GetInternal(uuids []string, tid string) (map[string]Content, error) {
+func (cr *ContentReader) GetInternal(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
 	var cm = make(map[string]Content)
-	requestURL := fmt.Sprintf("%s%s", cr.config.ContentStoreHost, cr.config.InternalContentPathEndpoint)
-
-	internalContent, err := cr.doGet(uuids, tid, requestURL, cr.config.ContentStoreAppName)
-	if err != nil {
-		return cm, err
-	}
-
-	for _, c := range internalContent {
-		cr.addItemToMap(c, cm)
-	}
+	cfg := cr.currentConfig()
 
-	return cm, nil
+	err := cr.doGetCached(ctx, cfg, uuids, tid, cfg.InternalContentPathEndpoint, readerGetInternal, cm)
+	return cm, err
 }
 
 // GetPreview reads content from Content-Preview API
-func (cr *ContentReader) GetPreview(uuids []string, tid string) (map[string]Content, error) {
-	return cr.getPreviewAsync(uuids, tid, false)
+func (cr *ContentReader) GetPreview(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	return cr.getPreviewAsync(ctx, uuids, tid, false)
 }
 
 // GetInternalPreview reads internalcomponents from Internal-Content-Preview API
-func (cr *ContentReader) GetInternalPreview(uuids []string, tid string) (map[string]Content, error) {
-	return cr.getPreviewAsync(uuids, tid, true)
+func (cr *ContentReader) GetInternalPreview(ctx context.Context, uuids []string, tid string) (map[string]Content, error) {
+	return cr.getPreviewAsync(ctx, uuids, tid, true)
 }
 
-func (cr *ContentReader) getPreviewAsync(uuids []string, tid string, isInternalPreview bool) (map[string]Content, error) {
+func (cr *ContentReader) getPreviewAsync(ctx context.Context, uuids []string, tid string, isInternalPreview bool) (map[string]Content, error) {
 	cm := make(map[string]Content)
+	cfg := cr.currentConfig()
+	uuids = budgetOf(ctx).take(ctx, uuids)
 	ch := make(chan Content, len(uuids))
+	method := readerGetPreview
+	if isInternalPreview {
+		method = readerGetInternalPreview
+	}
+
+	// the bulkhead is shared by all the requests, a gallery shouldn't take all of its slots
+	var perRequest chan struct{}
+	if cfg.ContentPreviewBulkhead.MaxPerRequest > 0 {
+		perRequest = make(chan struct{}, cfg.ContentPreviewBulkhead.MaxPerRequest)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(len(uuids))
 
 	for _, uuid := range uuids {
 		go func(uuid string, tid string, cr *ContentReader) {
-			requestURL := cr.createPreviewRequestURL(uuid, isInternalPreview)
-			content, err := cr.doGetPreview(uuid, tid, requestURL, cr.config.ContentPreviewAppName)
+			if perRequest != nil {
+				perRequest <- struct{}{}
+				defer func() { <-perRequest }()
+			}
+			path := createPreviewRequestPath(cfg, uuid, isInternalPreview)
+			content, err := cr.doGetPreview(ctx, cfg, uuid, tid, path, method)
 
 			if err != nil {
 				logger.Errorf(tid, "Error while expanding content %s", err.Error())
@@ -137,99 +200,300 @@ func (cr *ContentReader) getPreviewAsync(uuids []string, tid string, isInternalP
 		if !ok {
 			break
 		}
-		cr.addItemToMap(content, cm)
+		if uuid, ok := contentUUID(content); ok {
+			cm[uuid] = content
+		}
 	}
 
 	return cm, nil
 }
 
-func (cr *ContentReader) doGet(uuids []string, tid string, reqURL string, appName string) ([]Content, error) {
-	var cb []Content
+// doGetCached adds the cached models to the map and reads the others, caching them. The models past their
+// soft TTL are read again in the background, and the expired ones are served if the content store fails.
+// All of them are read when the context bypasses the cache.
+func (cr *ContentReader) doGetCached(ctx context.Context, cfg ReaderConfig, uuids []string, tid string, path string, method string, cm map[string]Content) error {
+	bypass := bypassesModelCache(ctx)
+	var missing, refresh []string
+	for _, uuid := range budgetOf(ctx).take(ctx, uuids) {
+		if _, done := cm[uuid]; done {
+			continue
+		}
+		if bypass {
+			missing = append(missing, uuid)
+			continue
+		}
+		l, found := cr.cache.get(method, uuid)
+		if !found {
+			missing = append(missing, uuid)
+			continue
+		}
+		cm[uuid] = l.content
+		if l.stale {
+			serveStale(ctx, method, uuid, l.age, staleRevalidating)
+		}
+		if l.refresh {
+			refresh = append(refresh, uuid)
+		}
+	}
+	if ctx, uuids := cr.startRevalidating(method, refresh); len(uuids) > 0 {
+		go cr.revalidate(ctx, cfg, uuids, path, method)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
 
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	contentBatch, err := cr.doGet(ctx, cfg, missing, tid, path, method)
+	if err != nil && bypass {
+		return err
+	}
 	if err != nil {
-		return cb, errors.Wrapf(err, "Error creating request to %v", appName)
+		return cr.serveStaleOnError(ctx, missing, tid, method, cm, err)
+	}
+	for _, c := range contentBatch {
+		if uuid, ok := contentUUID(c); ok {
+			cm[uuid] = c
+			cr.cache.set(method, uuid, c)
+		}
 	}
+	return nil
+}
 
-	req.Header.Add(transactionidutils.TransactionIDHeader, tid)
-	req.Header.Set(userAgent, userAgentValue)
-	q := req.URL.Query()
+// serveStaleOnError adds the expired models which are within the max staleness to the map. The error
+// is only returned if none of them is cached, the others are then reported as missing.
+func (cr *ContentReader) serveStaleOnError(ctx context.Context, uuids []string, tid string, method string, cm map[string]Content, err error) error {
+	served := 0
 	for _, uuid := range uuids {
-		if err = uuidutils.ValidateUUID(uuid); err == nil {
-			q.Add("uuid", uuid)
+		if l, found := cr.cache.getStale(method, uuid); found {
+			cm[uuid] = l.content
+			serveStale(ctx, method, uuid, l.age, staleUpstreamError)
+			served++
 		}
 	}
-	req.URL.RawQuery = q.Encode()
-	res, err := cr.client.Do(req)
-	if err != nil {
-		return cb, errors.Wrapf(err, "Request to %v failed.", appName)
+	if served == 0 {
+		return err
 	}
-	defer res.Body.Close()
+	logger.Warnf(tid, "", "Serving %d of %d models from the cache after they expired: %v", served, len(uuids), err)
+	return nil
+}
 
-	if res.StatusCode != http.StatusOK {
-		return cb, errors.Errorf("Request to %v failed with status code %d", appName, res.StatusCode)
+// startRevalidating returns the background context and the UUIDs which aren't already read again, marking
+// them as read again. None are returned once the background context is done.
+func (cr *ContentReader) startRevalidating(method string, uuids []string) (context.Context, []string) {
+	if len(uuids) == 0 {
+		return nil, nil
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	var started, skipped []string
+	for _, uuid := range uuids {
+		key := cacheKey(method, uuid)
+		if cr.revalidating[key] || cr.background.Err() != nil {
+			skipped = append(skipped, uuid)
+			continue
+		}
+		cr.revalidating[key] = true
+		started = append(started, uuid)
 	}
+	cr.cache.refreshDone(method, skipped)
+	return cr.background, started
+}
 
-	body, err := ioutil.ReadAll(res.Body)
+// revalidate reads the models again to cache them, the requests which served them aren't waiting for it.
+func (cr *ContentReader) revalidate(ctx context.Context, cfg ReaderConfig, uuids []string, path string, method string) {
+	defer func() {
+		cr.mu.Lock()
+		for _, uuid := range uuids {
+			delete(cr.revalidating, cacheKey(method, uuid))
+		}
+		cr.mu.Unlock()
+		cr.cache.refreshDone(method, uuids)
+	}()
+	tid := transactionidutils.NewTransactionID()
+	contentBatch, err := cr.doGet(ctx, cfg, uuids, tid, path, method)
 	if err != nil {
-		return cb, errors.Wrapf(err, "Error reading response received from %v", appName)
+		logger.Warnf(tid, "", "Unable to refresh %d cached models: %v", len(uuids), err)
+		return
+	}
+	for _, c := range contentBatch {
+		if uuid, ok := contentUUID(c); ok {
+			cr.cache.set(method, uuid, c)
+		}
 	}
+}
 
-	err = json.Unmarshal(body, &cb)
-	if err != nil {
-		return cb, errors.Wrapf(err, "Error unmarshalling response from %v", appName)
+// serveStale reports a stale model in the report of the unroll, and counts it.
+func serveStale(ctx context.Context, method string, uuid string, age time.Duration, reason string) {
+	expansionReport(ctx).addStale(uuid, age)
+	staleModelsServedTotal.WithLabelValues(method, reason).Inc()
+}
+
+func (cr *ContentReader) doGet(ctx context.Context, cfg ReaderConfig, uuids []string, tid string, path string, method string) (cb []Content, err error) {
+	appName := cfg.ContentStoreAppName
+	outcome := outcomeRequestError
+	defer observeUpstreamRequest(appName, method, &outcome, time.Now())
+	ctx, span := startClientSpan(ctx, appName, method)
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.StringSlice("content.uuids", uuids), attribute.Int("content.uuids.count", len(uuids)))
+
+	q := url.Values{}
+	for _, uuid := range uuids {
+		if validateUUID(uuid) == nil {
+			q.Add("uuid", uuid)
+		}
 	}
-	return cb, nil
+
+	if err = cr.storeBulkhead.acquire(ctx); err != nil {
+		outcome = outcomeBulkheadRejected
+		return nil, err
+	}
+	defer cr.storeBulkhead.release()
+
+	err = cr.storeHosts.do(ctx, func(host string) (bool, error) {
+		body, o, hostFailed, err := cr.doRequest(ctx, host, path+"?"+q.Encode(), method, tid, appName, cfg.Timeout, cfg.ContentStoreAuth)
+		outcome = o
+		if err != nil {
+			return hostFailed, err
+		}
+
+		cb = nil
+		err = json.Unmarshal(body, &cb)
+		if err != nil {
+			outcome = outcomeInvalidResponse
+			return false, errors.Wrapf(err, "Error unmarshalling response from %v", appName)
+		}
+		return false, nil
+	})
+	return cb, err
 }
 
-func (cr *ContentReader) doGetPreview(uuid string, tid string, reqURL string, appName string) (Content, error) {
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+func (cr *ContentReader) doGetPreview(ctx context.Context, cfg ReaderConfig, uuid string, tid string, path string, method string) (content Content, err error) {
+	appName := cfg.ContentPreviewAppName
+	outcome := outcomeRequestError
+	defer observeUpstreamRequest(appName, method, &outcome, time.Now())
+	ctx, span := startClientSpan(ctx, appName, method)
+	defer func() { endSpan(span, err) }()
+	span.SetAttributes(attribute.String("content.uuid", uuid))
+
+	if err = cr.previewBulkhead.acquire(ctx); err != nil {
+		outcome = outcomeBulkheadRejected
+		return content, errors.Wrapf(err, "Request for uuid %s failed", uuid)
+	}
+	defer cr.previewBulkhead.release()
+
+	err = cr.previewHosts.do(ctx, func(host string) (bool, error) {
+		body, o, hostFailed, err := cr.doRequest(ctx, host, path, method, tid, appName, cfg.Timeout, cfg.ContentPreviewAuth)
+		outcome = o
+		if err != nil {
+			return hostFailed, errors.Wrapf(err, "Request for uuid %s failed", uuid)
+		}
+
+		content = Content{}
+		err = json.Unmarshal(body, &content)
+		if err != nil {
+			outcome = outcomeInvalidResponse
+			return false, errors.Wrapf(err, "Error unmarshalling response from %v", appName)
+		}
+		return false, nil
+	})
+	return content, err
+}
+
+// doRequest reads the response of a host of an upstream app. The host failed if it couldn't
+// be reached or it responded with a server error. A response which had validators is requested
+// conditionally, and read from the validators when it didn't change.
+func (cr *ContentReader) doRequest(ctx context.Context, host string, pathAndQuery string, method string, tid string, appName string, timeout time.Duration, auth AuthConfig) (body []byte, outcome string, hostFailed bool, err error) {
+	span := trace.SpanFromContext(ctx)
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, host+pathAndQuery, nil)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Error creating request to %v for uuid: %s", appName, uuid)
+		return nil, outcomeRequestError, false, errors.Wrapf(err, "Error creating request to %v", appName)
 	}
 
 	req.Header.Set(transactionidutils.TransactionIDHeader, tid)
-	req.Header.Set("User-Agent", userAgentValue)
+	req.Header.Set(userAgent, userAgentValue)
+	req.Header.Set("Accept-Encoding", encodingGzip)
+	if client := clientID(ctx); client != anonymousClient {
+		req.Header.Set(clientIDHeader, client)
+	}
+	if err = auth.authenticate(req); err != nil {
+		return nil, outcomeRequestError, false, errors.Wrapf(err, "Error authenticating request to %v", appName)
+	}
+	key := validatorsKey(method, pathAndQuery)
+	validated, conditional := cr.validators.conditional(key, req)
+	req = req.WithContext(ctx)
+	injectTraceContext(ctx, req)
+	span.SetAttributes(attribute.String("server.address", req.URL.Host))
 
 	res, err := cr.client.Do(req)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Request to %v failed for uuid: %s", appName, uuid)
+		return nil, outcomeRequestError, true, errors.Wrapf(err, "Request to %v failed.", appName)
 	}
 	defer res.Body.Close()
 
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	if res.StatusCode == http.StatusNotModified && conditional {
+		cr.cache.revalidated()
+		revalidationsTotal.WithLabelValues(method, revalidationNotModified).Inc()
+		return validated.body, outcomeNotModified, false, nil
+	}
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("Request to %v failed for uuid: %s with status code %d", appName, uuid, res.StatusCode)
+		return nil, outcomeUnexpectedStatus, res.StatusCode >= http.StatusInternalServerError,
+			errors.Errorf("Request to %v failed with status code %d", appName, res.StatusCode)
 	}
 
-	body, err := ioutil.ReadAll(res.Body)
+	body, err = readBody(res, cr.currentConfig().MaxBodySize)
+	if _, tooLarge := err.(limitError); tooLarge {
+		return nil, outcomeInvalidResponse, false, errors.Wrapf(err, "Error reading response received from %v", appName)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "Error reading response received from %v", appName)
+		return nil, outcomeInvalidResponse, true, errors.Wrapf(err, "Error reading response received from %v", appName)
+	}
+	if conditional {
+		revalidationsTotal.WithLabelValues(method, revalidationModified).Inc()
 	}
+	cr.validators.store(key, res.Header, body)
+	return body, outcomeSuccess, false, nil
+}
 
-	var content Content
-	err = json.Unmarshal(body, &content)
-	if err != nil {
-		return content, errors.Wrapf(err, "Error unmarshalling response from %v", appName)
+// withTimeout limits the context to the timeout, if there is one.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	return content, nil
+	return context.WithTimeout(ctx, timeout)
 }
 
-func (cr *ContentReader) addItemToMap(c Content, cm map[string]Content) {
-	id, ok := c[id].(string)
-	if !ok {
-		return
+func contentUUID(c Content) (string, bool) {
+	if c.ID == "" {
+		return "", false
 	}
-	uuid, err := extractUUIDFromString(id)
+	uuid, err := extractUUIDFromString(c.ID)
 	if err != nil {
-		return
+		return "", false
 	}
-	cm[uuid] = c
+	return uuid, true
 }
 
-func (cr *ContentReader) createPreviewRequestURL(uuid string, isInternalPreview bool) string {
+func createPreviewRequestPath(cfg ReaderConfig, uuid string, isInternalPreview bool) string {
 	if isInternalPreview {
-		return fmt.Sprintf("%s%s/%s", cr.config.ContentPreviewHost, cr.config.InternalContentPathEndpoint, uuid)
+		return fmt.Sprintf("%s/%s", cfg.InternalContentPathEndpoint, uuid)
 	}
 
-	return fmt.Sprintf("%s%s/%s", cr.config.ContentPreviewHost, cr.config.ContentPathEndpoint, uuid)
+	return fmt.Sprintf("%s/%s", cfg.ContentPathEndpoint, uuid)
+}
+
+type upstreamState struct {
+	App      string        `json:"app"`
+	Hosts    []hostState   `json:"hosts"`
+	Bulkhead bulkheadState `json:"bulkhead"`
+}
+
+// upstreamsState returns the hosts of the upstream apps, with the ones ejected, and their bulkheads.
+func (cr *ContentReader) upstreamsState() []upstreamState {
+	return []upstreamState{
+		{App: cr.storeHosts.appName, Hosts: cr.storeHosts.state(), Bulkhead: cr.storeBulkhead.state()},
+		{App: cr.previewHosts.appName, Hosts: cr.previewHosts.state(), Bulkhead: cr.previewBulkhead.state()},
+	}
 }
@@ -1,6 +1,7 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,8 +9,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -56,6 +60,15 @@ func readerForTest(contentStoreHost string, contentPreviewHost string) *ContentR
 	return NewContentReader(cfg, http.DefaultClient)
 }
 
+// assertContentMapsEqual compares the models as JSON, as the raw fields keep the whitespace of the source.
+func assertContentMapsEqual(t *testing.T, expected map[string]Content, actual map[string]Content) {
+	expectedJSON, err := json.Marshal(expected)
+	assert.NoError(t, err)
+	actualJSON, err := json.Marshal(actual)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expectedJSON), string(actualJSON))
+}
+
 func TestGet(t *testing.T) {
 	ts := successfulContentServerMock(t, "../test-resources/source-content-valid-response.json")
 	defer ts.Close()
@@ -68,9 +81,230 @@ func TestGet(t *testing.T) {
 	err = json.Unmarshal(body, &expected)
 	assert.NoError(t, err, "Cannot read expected response for test case.")
 
-	actual, err := cr.Get(testData, "tid_1")
+	actual, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
-	assert.Equal(t, expected, actual)
+	assertContentMapsEqual(t, expected, actual)
+}
+
+func TestGet_CachedModelsAreNotReadAgain(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL, "")
+	cr.UpdateConfig(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 100},
+	})
+
+	first, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	requestsAfterFirst := atomic.LoadInt32(&requests)
+
+	second, err := cr.Get(context.Background(), testData, "tid_2")
+	assert.NoError(t, err)
+	assert.Equal(t, requestsAfterFirst, atomic.LoadInt32(&requests), "The cached models should not be read again")
+	for _, uuid := range testData {
+		assert.Contains(t, second, uuid)
+	}
+	for uuid := range second {
+		assertContentMapsEqual(t, map[string]Content{uuid: first[uuid]}, map[string]Content{uuid: second[uuid]})
+	}
+}
+
+func TestGet_CachedModelsAreReadAgainWithoutTheCache(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL, "")
+	cr.UpdateConfig(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 100},
+	})
+
+	_, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	requestsAfterFirst := atomic.LoadInt32(&requests)
+
+	second, err := cr.Get(withoutModelCache(context.Background()), testData, "tid_2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*requestsAfterFirst, atomic.LoadInt32(&requests), "The cached models should be read again")
+	for _, uuid := range testData {
+		assert.Contains(t, second, uuid)
+	}
+}
+
+func TestGet_MembersAreNotReadBeyondTheMaxDepth(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL, "")
+	report := newExpansionReport()
+	ctx := withUnrollBudget(withExpansionReport(context.Background(), report), newUnrollBudget(LimitsConfig{MaxDepth: 1}.withDefaults()))
+
+	_, err := cr.Get(ctx, testData, "tid_1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "The members of the image sets shouldn't be read")
+	assert.Equal(t, limitMaxDepth, report.limitHits()[0].Limit)
+}
+
+// cacheModelsForTest caches the models of the UUIDs as if they were read age ago.
+func cacheModelsForTest(cr *ContentReader, age time.Duration, uuids ...string) {
+	stored := time.Now().Add(-age)
+	cr.cache.now = func() time.Time { return stored }
+	for _, uuid := range uuids {
+		cr.cache.set(readerGet, uuid, Content{ID: "http://www.ft.com/thing/" + uuid})
+	}
+	cr.cache.now = time.Now
+}
+
+func TestGet_StaleModelsAreRefreshedInTheBackground(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, SoftTTL: 10 * time.Second, MaxEntries: 100},
+	}, http.DefaultClient)
+	cacheModelsForTest(cr, 20*time.Second, testData...)
+	revalidating := staleModelsServedTotal.WithLabelValues(readerGet, staleRevalidating)
+	before := testutil.ToFloat64(revalidating)
+
+	report := newExpansionReport()
+	actual, err := cr.Get(withExpansionReport(context.Background(), report), testData, "tid_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.ft.com/thing/"+testData[0], actual[testData[0]].ID, "The stale model should be served")
+	assert.Len(t, report.staleModels(), 3)
+	assert.Equal(t, before+3, testutil.ToFloat64(revalidating))
+
+	for i := 0; i < 100 && atomic.LoadInt32(&requests) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "The stale models should be read again once")
+	for i := 0; i < 100; i++ {
+		if l, _ := cr.cache.get(readerGet, testData[0]); !l.stale {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	l, found := cr.cache.get(readerGet, testData[0])
+	assert.True(t, found)
+	assert.False(t, l.stale, "The refreshed model should be fresh")
+}
+
+func TestGet_StaleModelsAreReadAgainOnceWhileRevalidating(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, SoftTTL: 10 * time.Second, MaxEntries: 100},
+	}, http.DefaultClient)
+	cacheModelsForTest(cr, 20*time.Second, testData[0])
+
+	_, err := cr.Get(context.Background(), testData[:1], "tid_1")
+	assert.NoError(t, err)
+	for i := 0; i < 100 && atomic.LoadInt32(&requests) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the model is cached again while it's read again, so the cache doesn't know it's revalidating
+	cacheModelsForTest(cr, 20*time.Second, testData[0])
+	for i := 0; i < 10; i++ {
+		_, err := cr.Get(context.Background(), testData[:1], "tid_2")
+		assert.NoError(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "The stale model should be read again once")
+}
+
+func TestGet_StaleModelsAreNotReadAgainOnceTheBackgroundContextIsDone(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeFile(w, r, "../test-resources/source-content-valid-response.json")
+	}))
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, SoftTTL: 10 * time.Second, MaxEntries: 100},
+	}, http.DefaultClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	cr.SetBackgroundContext(ctx)
+	cancel()
+	cacheModelsForTest(cr, 20*time.Second, testData[0])
+
+	actual, err := cr.Get(context.Background(), testData[:1], "tid_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.ft.com/thing/"+testData[0], actual[testData[0]].ID, "The stale model should be served")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests), "The stale model shouldn't be read again")
+	l, _ := cr.cache.get(readerGet, testData[0])
+	assert.True(t, l.refresh, "The model should be left to be refreshed")
+}
+
+func TestGet_StaleModelsAreServedWhenTheContentStoreFails(t *testing.T) {
+	ts := errorContentServerMock(t, http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, MaxStale: time.Hour, MaxEntries: 100},
+	}, http.DefaultClient)
+	cacheModelsForTest(cr, 2*time.Minute, testData[0])
+	cacheModelsForTest(cr, 2*time.Hour, testData[2])
+
+	report := newExpansionReport()
+	actual, err := cr.Get(withExpansionReport(context.Background(), report), testData, "tid_1")
+	assert.NoError(t, err)
+	assert.Len(t, actual, 1, "Only the model within the max staleness should be served")
+	assert.Contains(t, actual, testData[0])
+	if assert.Len(t, report.staleModels(), 1) {
+		assert.Equal(t, StaleModel{UUID: testData[0], Age: "2m0s"}, report.staleModels()[0])
+	}
+
+	_, err = cr.Get(context.Background(), testData[2:], "tid_2")
+	assert.Error(t, err, "The model beyond the max staleness shouldn't be served")
+}
+
+func TestGet_UpstreamTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	cr := readerForTest(ts.URL, "")
+	cr.UpdateConfig(ReaderConfig{ContentStoreAppName: "content-source-app-name", ContentStoreHost: ts.URL, Timeout: 50 * time.Millisecond})
+	_, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.Error(t, err, "The request should time out")
 }
 
 func TestGet_ContentSourceReturns500(t *testing.T) {
@@ -78,7 +312,7 @@ func TestGet_ContentSourceReturns500(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL, "")
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -87,19 +321,19 @@ func TestGet_ContentSourceReturns404(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL, "")
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGet_ContentSourceCannotBeResolved(t *testing.T) {
 	cr := readerForTest(unresolvedHostURL, "")
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGet_ContentSourceHasInvalidURL(t *testing.T) {
 	cr := readerForTest(invalidHostURL, "")
-	_, err := cr.Get(testData, "tid_1")
+	_, err := cr.Get(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -115,9 +349,9 @@ func TestGetInternal(t *testing.T) {
 	err = json.Unmarshal(body, &expected)
 	assert.NoError(t, err, "Cannot read expected response for test case.")
 
-	actual, err := cr.GetInternal(testData, "tid_1")
+	actual, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
-	assert.Equal(t, expected, actual)
+	assertContentMapsEqual(t, expected, actual)
 }
 
 func TestGetInternal_ContentSourceReturns500(t *testing.T) {
@@ -125,7 +359,7 @@ func TestGetInternal_ContentSourceReturns500(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL, "")
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -134,19 +368,19 @@ func TestGetInternal_ContentSourceReturns404(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest(ts.URL, "")
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGetInternal_ContentSourceCannotBeResolved(t *testing.T) {
 	cr := readerForTest(unresolvedHostURL, "")
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
 func TestGetInternal_ContentSourceHasInvalidURL(t *testing.T) {
 	cr := readerForTest(invalidHostURL, "")
-	_, err := cr.GetInternal(testData, "tid_1")
+	_, err := cr.GetInternal(context.Background(), testData, "tid_1")
 	assert.Error(t, err, "There should an error thrown")
 }
 
@@ -162,9 +396,71 @@ func TestGetPreview(t *testing.T) {
 	err = json.Unmarshal(body, &expected)
 	assert.NoError(t, err, "Cannot read expected response for test case.")
 
-	actual, err := cr.GetPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
-	assert.Equal(t, expected, actual)
+	assertContentMapsEqual(t, expected, actual)
+}
+
+// conditionalServerMock serves the resource with an ETag, and a 304 to the requests which have it.
+func conditionalServerMock(t *testing.T, resource string, notModified *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeFile(w, r, resource)
+	}))
+}
+
+func TestGetPreview_NotModifiedResponsesAreRevalidated(t *testing.T) {
+	var notModified int32
+	ts := conditionalServerMock(t, "../test-resources/source-contentpreview-valid-response.json", &notModified)
+	defer ts.Close()
+	cr := NewContentReader(ReaderConfig{
+		ContentPreviewAppName: "content-preview-app-name",
+		ContentPreviewHost:    ts.URL,
+		Cache:                 CacheConfig{Revalidate: true, MaxEntries: 10},
+	}, http.DefaultClient)
+	revalidations := revalidationsTotal.WithLabelValues(readerGetPreview, revalidationNotModified)
+	before := testutil.ToFloat64(revalidations)
+
+	first, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_1")
+	assert.NoError(t, err)
+	second, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_2")
+	assert.NoError(t, err)
+	assertContentMapsEqual(t, first, second)
+	assert.Len(t, second, 1)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified), "The second request should be conditional")
+	assert.Equal(t, before+1, testutil.ToFloat64(revalidations))
+	assert.Equal(t, uint64(1), cr.cache.stats().Revalidations)
+
+	cr.UpdateConfig(ReaderConfig{ContentPreviewAppName: "content-preview-app-name", ContentPreviewHost: ts.URL})
+	_, err = cr.GetPreview(context.Background(), dynamicContentTestData, "tid_3")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified), "The requests shouldn't be conditional without revalidation")
+}
+
+func TestGet_NotModifiedResponsesAreRevalidated(t *testing.T) {
+	var notModified int32
+	ts := conditionalServerMock(t, "../test-resources/source-content-valid-response.json", &notModified)
+	defer ts.Close()
+	cr := NewContentReader(ReaderConfig{
+		ContentStoreAppName: "content-source-app-name",
+		ContentStoreHost:    ts.URL,
+		Cache:               CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 100, Revalidate: true},
+	}, http.DefaultClient)
+
+	first, err := cr.Get(context.Background(), testData, "tid_1")
+	assert.NoError(t, err)
+	for _, uuid := range testData {
+		cr.cache.purge(uuid)
+	}
+	second, err := cr.Get(context.Background(), testData, "tid_2")
+	assert.NoError(t, err)
+	assertContentMapsEqual(t, first, second)
+	assert.True(t, atomic.LoadInt32(&notModified) > 0, "The expired models should be requested conditionally")
 }
 
 func TestGetPreview_ContentSourceReturns500(t *testing.T) {
@@ -172,7 +468,7 @@ func TestGetPreview_ContentSourceReturns500(t *testing.T) {
 	defer ts.Close()
 	cr := readerForTest("", ts.URL)
 
-	actual, err := cr.GetPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -184,7 +480,7 @@ func TestGetPreview_ContentSourceReturns404(t *testing.T) {
 	defer ts.Close()
 	cr := readerForTest("", ts.URL)
 
-	actual, err := cr.GetPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -193,7 +489,7 @@ func TestGetPreview_ContentSourceReturns404(t *testing.T) {
 
 func TestGetPreview_ContentSourceCannotBeResolved(t *testing.T) {
 	cr := readerForTest("", unresolvedHostURL)
-	actual, err := cr.GetPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -202,7 +498,7 @@ func TestGetPreview_ContentSourceCannotBeResolved(t *testing.T) {
 
 func TestGetPreview_ContentSourceHasInvalidURL(t *testing.T) {
 	cr := readerForTest("", invalidHostURL)
-	actual, err := cr.GetPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -221,9 +517,9 @@ func TestGetInternalPreview(t *testing.T) {
 	err = json.Unmarshal(body, &expected)
 	assert.NoError(t, err, "Cannot read expected response for test case.")
 
-	actual, err := cr.GetInternalPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetInternalPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
-	assert.Equal(t, expected, actual)
+	assertContentMapsEqual(t, expected, actual)
 }
 
 func TestGetInternalPreview_ContentSourceReturns500(t *testing.T) {
@@ -231,7 +527,7 @@ func TestGetInternalPreview_ContentSourceReturns500(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest("", ts.URL)
-	actual, err := cr.GetInternalPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetInternalPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -243,7 +539,7 @@ func TestGetInternalPreview_ContentSourceReturns404(t *testing.T) {
 	defer ts.Close()
 
 	cr := readerForTest("", ts.URL)
-	actual, err := cr.GetInternalPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetInternalPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -252,7 +548,7 @@ func TestGetInternalPreview_ContentSourceReturns404(t *testing.T) {
 
 func TestGetInternalPreview_ContentSourceCannotBeResolved(t *testing.T) {
 	cr := readerForTest(unresolvedHostURL, "")
-	actual, err := cr.GetInternalPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetInternalPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -261,7 +557,7 @@ func TestGetInternalPreview_ContentSourceCannotBeResolved(t *testing.T) {
 
 func TestGetInternalPreview_ContentSourceHasInvalidURL(t *testing.T) {
 	cr := readerForTest("", invalidHostURL)
-	actual, err := cr.GetInternalPreview(dynamicContentTestData, "tid_1")
+	actual, err := cr.GetInternalPreview(context.Background(), dynamicContentTestData, "tid_1")
 	assert.NoError(t, err, "Error while getting content data")
 
 	var expected = make(map[string]Content)
@@ -0,0 +1,29 @@
+package content
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/Financial-Times/transactionid-utils-go"
+)
+
+// RecoveryHandler converts a panic raised while serving a request into a logged 500 response
+// with a JSON error body, instead of letting the server drop the connection.
+func RecoveryHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				tid := transactionidutils.GetTransactionIDFromRequest(r)
+				logger.Errorf(tid, "Recovered from panic while serving %s: %v\n%s", r.RequestURI, rec, debug.Stack())
+				logger.TransactionFinishedEvent(r.RequestURI, tid, http.StatusInternalServerError, "", "panic while serving request")
+
+				msg, _ := json.Marshal(ErrorMessage{Message: "Internal error while expanding content"})
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write(msg)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,39 @@
+package content
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryHandler_PanicReturns500(t *testing.T) {
+	h := RecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c map[string]string
+		c[bodyXML] = "writing to a nil map panics"
+	}))
+
+	req, err := http.NewRequest(http.MethodPost, "/content", nil)
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	rr := httptest.NewRecorder()
+
+	assert.NotPanics(t, func() { h.ServeHTTP(rr, req) })
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, "application/json; charset=UTF-8", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"message":"Internal error while expanding content"}`, rr.Body.String())
+}
+
+func TestRecoveryHandler_NoPanic(t *testing.T) {
+	h := RecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req, err := http.NewRequest(http.MethodGet, "/__gtg", nil)
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	rr := httptest.NewRecorder()
+
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Body.String())
+}
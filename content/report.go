@@ -0,0 +1,169 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const expansionReportHeader = "X-Expansion-Report"
+
+// Reasons for which a field of the supplied content could not be expanded.
+const (
+	reasonInvalidType   = "invalid-type"
+	reasonMissingID     = "missing-id"
+	reasonInvalidUUID   = "invalid-uuid"
+	reasonInvalidBody   = "invalid-body"
+	reasonUpstreamError = "upstream-error"
+	reasonMissingModel  = "missing-model"
+)
+
+// ExpansionProblem describes a field that was left unexpanded and why.
+type ExpansionProblem struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// StaleModel is a model served from the cache past its soft TTL, or after it expired as the content store failed.
+type StaleModel struct {
+	UUID string `json:"uuid"`
+	Age  string `json:"age"`
+}
+
+// LimitHit is a limit of the unroll which stopped the expansion.
+type LimitHit struct {
+	Limit  string `json:"limit"`
+	Max    string `json:"max"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ExpansionReport collects the problems found while unrolling a single piece of content.
+// A shape deviation in the supplied content never fails the whole request, it is reported here instead.
+type ExpansionReport struct {
+	mu       sync.Mutex
+	Problems []ExpansionProblem `json:"problems,omitempty"`
+	Stale    []StaleModel       `json:"stale,omitempty"`
+	Limits   []LimitHit         `json:"limits,omitempty"`
+}
+
+func newExpansionReport() *ExpansionReport {
+	return &ExpansionReport{}
+}
+
+func (r *ExpansionReport) add(field string, reason string, detail string) {
+	if r == nil {
+		return
+	}
+	p := ExpansionProblem{Field: field, Reason: reason, Detail: detail}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.Problems {
+		if existing == p {
+			return
+		}
+	}
+	r.Problems = append(r.Problems, p)
+}
+
+func (r *ExpansionReport) addStale(uuid string, age time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.Stale {
+		if existing.UUID == uuid {
+			return
+		}
+	}
+	r.Stale = append(r.Stale, StaleModel{UUID: uuid, Age: age.Round(time.Second).String()})
+}
+
+func (r *ExpansionReport) addLimit(limit string, max string, detail string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.Limits {
+		if existing.Limit == limit {
+			return
+		}
+	}
+	r.Limits = append(r.Limits, LimitHit{Limit: limit, Max: max, Detail: detail})
+}
+
+func (r *ExpansionReport) isEmpty() bool {
+	if r == nil {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.Problems) == 0 && len(r.Stale) == 0 && len(r.Limits) == 0
+}
+
+// degraded tells whether the unroll may expand more when it is made again: stale models were
+// served, models couldn't be read, or a limit stopped the expansion.
+func (r *ExpansionReport) degraded() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.Problems {
+		if p.Reason == reasonUpstreamError || p.Reason == reasonMissingModel {
+			return true
+		}
+	}
+	return len(r.Stale) > 0 || len(r.Limits) > 0
+}
+
+func (r *ExpansionReport) problems() []ExpansionProblem {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ExpansionProblem{}, r.Problems...)
+}
+
+func (r *ExpansionReport) staleModels() []StaleModel {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]StaleModel{}, r.Stale...)
+}
+
+func (r *ExpansionReport) limitHits() []LimitHit {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]LimitHit{}, r.Limits...)
+}
+
+func (r *ExpansionReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Problems []ExpansionProblem `json:"problems,omitempty"`
+		Stale    []StaleModel       `json:"stale,omitempty"`
+		Limits   []LimitHit         `json:"limits,omitempty"`
+	}{r.problems(), r.staleModels(), r.limitHits()})
+}
+
+type reportKey struct{}
+
+// withExpansionReport lets the reader add the stale models it serves to the report of the unroll.
+func withExpansionReport(ctx context.Context, report *ExpansionReport) context.Context {
+	return context.WithValue(ctx, reportKey{}, report)
+}
+
+func expansionReport(ctx context.Context) *ExpansionReport {
+	report, _ := ctx.Value(reportKey{}).(*ExpansionReport)
+	return report
+}
@@ -0,0 +1,316 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/pkg/errors"
+)
+
+// selfTestArticleUUID is the uuid of the synthetic article, which isn't published.
+const selfTestArticleUUID = "5e1f7e57-c0de-4a11-8000-000000000000"
+
+// minSelfTestReuse is how long /__self-test serves the result of the last run when there's no interval.
+const minSelfTestReuse = 10 * time.Second
+
+// maxSelfTestIntervals is how many intervals the result of the last background self-test is served,
+// the background self-tests have stopped when it's older.
+const maxSelfTestIntervals = 2
+
+// SelfTestConfig configures the article unrolled end to end by the self-test: a canary article
+// read from a file, or a synthetic one with the configured image set as main image and embedded
+// in its body along with the configured dynamic content.
+type SelfTestConfig struct {
+	CanaryFile         string `yaml:"canaryFile"`
+	ImageSetUUID       string `yaml:"imageSetUUID"`
+	DynamicContentUUID string `yaml:"dynamicContentUUID"`
+	// Interval of the background self-tests, whose results are served by the healthcheck.
+	// The self-test only runs when requested when it is 0.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (c SelfTestConfig) enabled() bool {
+	return c.CanaryFile != "" || c.ImageSetUUID != "" || c.DynamicContentUUID != ""
+}
+
+func (c SelfTestConfig) validate() error {
+	for name, uuid := range map[string]string{"imageSetUUID": c.ImageSetUUID, "dynamicContentUUID": c.DynamicContentUUID} {
+		if uuid != "" && validateUUID(uuid) != nil {
+			return errors.Errorf("selfTest.%s %q isn't a valid UUID", name, uuid)
+		}
+	}
+	if c.CanaryFile != "" && (c.ImageSetUUID != "" || c.DynamicContentUUID != "") {
+		return errors.New("selfTest.canaryFile replaces the synthetic article, it can't be set with selfTest.imageSetUUID or selfTest.dynamicContentUUID")
+	}
+	if c.Interval < 0 {
+		return errors.New("selfTest.interval can't be negative")
+	}
+	return nil
+}
+
+// article returns the article to unroll, reading the canary file again so that it can be changed.
+func (c SelfTestConfig) article() (*Article, error) {
+	var b []byte
+	if c.CanaryFile != "" {
+		var err error
+		if b, err = ioutil.ReadFile(c.CanaryFile); err != nil {
+			return nil, errors.Wrap(err, "Unable to read the canary article")
+		}
+	} else {
+		b, _ = json.Marshal(c.syntheticArticle())
+	}
+
+	var article Article
+	if err := json.Unmarshal(b, &article); err != nil {
+		return nil, errors.Wrap(err, "Invalid canary article")
+	}
+	if article.ID == "" {
+		return nil, errors.New("The canary article has no id")
+	}
+	return &article, nil
+}
+
+func (c SelfTestConfig) syntheticArticle() map[string]interface{} {
+	a := map[string]interface{}{
+		"id":   "http://www.ft.com/thing/" + selfTestArticleUUID,
+		"type": "http://www.ft.com/ontology/content/Article",
+	}
+	var body strings.Builder
+	body.WriteString("<body>")
+	if c.ImageSetUUID != "" {
+		a[mainImage] = map[string]string{id: "http://api.ft.com/content/" + c.ImageSetUUID}
+		fmt.Fprintf(&body, `<ft-content type="%s" url="http://api.ft.com/content/%s" data-embedded="true"></ft-content>`, ImageSetType, c.ImageSetUUID)
+	}
+	if c.DynamicContentUUID != "" {
+		fmt.Fprintf(&body, `<ft-content type="%s" url="http://api.ft.com/content/%s" data-embedded="true"></ft-content>`, DynamicContentType, c.DynamicContentUUID)
+	}
+	body.WriteString("<p>Self-test</p></body>")
+	a[bodyXML] = body.String()
+	return a
+}
+
+type selfTestResult struct {
+	Passed    bool      `json:"passed"`
+	UUID      string    `json:"uuid,omitempty"`
+	Failures  []string  `json:"failures,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Duration  string    `json:"duration"`
+}
+
+// message summarises the result for the healthcheck.
+func (r selfTestResult) message() string {
+	status := "Passed."
+	if !r.Passed {
+		status = "Failed: " + strings.Join(r.Failures, "; ") + "."
+	}
+	return fmt.Sprintf("%s Last run at %s in %s.", status, r.CheckedAt.UTC().Format(time.RFC3339), r.Duration)
+}
+
+// SelfTest unrolls an article end to end, through the upstream apps, and checks its expandable
+// fields were expanded. The healthchecks only prove the upstream apps respond. It unrolls within
+// the limits of the requests, without the model cache.
+type SelfTest struct {
+	unroll func(UnrollEvent) UnrollResult
+	limits *UnrollLimits
+	now    func() time.Time
+	// running serialises the runs requested, so that concurrent requests share a run
+	running sync.Mutex
+
+	mu     sync.RWMutex
+	config SelfTestConfig
+	last   *selfTestResult
+}
+
+// NewSelfTest makes a self-test unrolling the article with the given unroll method, e.g. the one of a flow served.
+func NewSelfTest(unroll func(UnrollEvent) UnrollResult, cfg SelfTestConfig, limits *UnrollLimits) *SelfTest {
+	return &SelfTest{unroll: unroll, limits: limits, now: time.Now, config: cfg}
+}
+
+func (s *SelfTest) UpdateConfig(cfg SelfTestConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = cfg
+}
+
+func (s *SelfTest) currentConfig() SelfTestConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// Poll runs the self-test every interval until the context is done.
+func (s *SelfTest) Poll(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if s.currentConfig().enabled() {
+			s.Run(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run unrolls the article and keeps the result for the healthcheck.
+func (s *SelfTest) Run(ctx context.Context) selfTestResult {
+	tid := transactionidutils.NewTransactionID()
+	start := s.now()
+	result := selfTestResult{CheckedAt: start}
+	uuid, failures := s.run(ctx, tid)
+	result.UUID, result.Failures, result.Passed = uuid, failures, len(failures) == 0
+	result.Duration = s.now().Sub(start).Round(time.Millisecond).String()
+
+	if result.Passed {
+		logger.Infof(tid, uuid, "Self-test passed in %s", result.Duration)
+	} else {
+		logger.Warnf(tid, uuid, "Self-test failed: %s", strings.Join(failures, "; "))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = &result
+	return result
+}
+
+func (s *SelfTest) run(ctx context.Context, tid string) (string, []string) {
+	article, err := s.currentConfig().article()
+	if err != nil {
+		return "", []string{err.Error()}
+	}
+	uuid, err := extractUUIDFromString(article.ID)
+	if err != nil {
+		return "", []string{err.Error()}
+	}
+
+	res, _ := unrollWithin(UnrollEvent{article, tid, uuid, withoutModelCache(ctx)}, s.limits.currentConfig(), s.unroll)
+	if res.err != nil {
+		return uuid, []string{res.err.Error()}
+	}
+	return uuid, verifyExpanded(article, res, tid, uuid)
+}
+
+// verifyExpanded returns what wasn't expanded in the unrolled article: the main image and
+// the image sets and dynamic content embedded in its body, or the limits it hit.
+func verifyExpanded(article *Article, res UnrollResult, tid string, uuid string) []string {
+	var failures []string
+	for _, p := range res.report.problems() {
+		failures = append(failures, fmt.Sprintf("%s: %s %s", p.Field, p.Reason, p.Detail))
+	}
+	for _, l := range res.report.limitHits() {
+		failures = append(failures, fmt.Sprintf("the %s limit of %s was hit: %s", l.Limit, l.Max, l.Detail))
+	}
+
+	if article.MainImage != nil && !isExpandedImageSet(res.uc.MainImage) {
+		failures = append(failures, "the main image wasn't expanded")
+	}
+
+	if article.BodyXML != nil {
+		embedded, err := getEmbedded(*article.BodyXML, []string{ImageSetType, DynamicContentType}, tid, uuid)
+		if err != nil {
+			return append(failures, errors.Wrap(err, "Invalid body").Error())
+		}
+		expanded := 0
+		for _, e := range res.uc.Embeds {
+			switch e := e.(type) {
+			case *ImageSet:
+				if isExpandedImageSet(e) {
+					expanded++
+				}
+			case *DynamicContent:
+				if e != nil && e.Type != "" {
+					expanded++
+				}
+			}
+		}
+		if expanded < len(embedded) {
+			failures = append(failures, fmt.Sprintf("%d of %d embeds were expanded", expanded, len(embedded)))
+		}
+	}
+	return failures
+}
+
+// isExpandedImageSet tells an image set read from the upstream app, with its members, from a placeholder.
+func isExpandedImageSet(is *ImageSet) bool {
+	return is != nil && is.Type != "" && len(is.Members) > 0
+}
+
+func (s *SelfTest) lastResult() *selfTestResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last
+}
+
+// recentResult returns the last result when it's more recent than the interval, or than minSelfTestReuse
+// without an interval, and otherwise runs the self-test.
+func (s *SelfTest) recentResult(ctx context.Context) selfTestResult {
+	s.running.Lock()
+	defer s.running.Unlock()
+	reuse := s.currentConfig().Interval
+	if reuse < minSelfTestReuse {
+		reuse = minSelfTestReuse
+	}
+	if last := s.lastResult(); last != nil && s.now().Sub(last.CheckedAt) < reuse {
+		return *last
+	}
+	return s.Run(ctx)
+}
+
+// Handler responds with the result of a recent self-test, and a 503 when it failed.
+func (s *SelfTest) Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if !s.currentConfig().enabled() {
+		msg, _ := json.Marshal(ErrorMessage{Message: "The self-test isn't configured"})
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(msg)
+		return
+	}
+
+	result := s.recentResult(r.Context())
+	if !result.Passed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *SelfTest) check() (string, error) {
+	cfg := s.currentConfig()
+	if !cfg.enabled() {
+		return "The self-test isn't configured", nil
+	}
+	last := s.lastResult()
+	if last == nil {
+		return "The self-test hasn't run yet", nil
+	}
+	if cfg.Interval > 0 && s.now().Sub(last.CheckedAt) > maxSelfTestIntervals*cfg.Interval {
+		msg := fmt.Sprintf("The background self-tests have stopped. %s", last.message())
+		return msg, errors.New(msg)
+	}
+	if !last.Passed {
+		return last.message(), errors.New(last.message())
+	}
+	return last.message(), nil
+}
+
+// Check reports the last self-test, it has a low severity as the upstream apps have their own checks.
+func (s *SelfTest) Check() fthealth.Check {
+	return fthealth.Check{
+		ID:               "check-self-test",
+		Name:             "Check content can be unrolled end to end",
+		Severity:         3,
+		BusinessImpact:   "Images and dynamic content might not be expanded in the content",
+		TechnicalSummary: "The self-test article wasn't fully unrolled. Run /__self-test for the details.",
+		PanicGuide:       "https://dewey.in.ft.com/runbooks/content-unroller",
+		Checker:          s.check,
+	}
+}
@@ -0,0 +1,210 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	selfTestImageSetUUID       = "639cd952-149f-11e7-2ea7-a07ecd9ac73f"
+	selfTestMemberUUID         = "0261ea4a-1474-11e7-1e92-847abda1ac65"
+	selfTestDynamicContentUUID = "d02886fc-58ff-11e8-9859-6668838a4c10"
+)
+
+func selfTestModelsForTest(t *testing.T) map[string]Content {
+	models := map[string]string{
+		selfTestImageSetUUID:       `{"id":"http://api.ft.com/content/` + selfTestImageSetUUID + `","type":"` + ImageSetType + `","members":[{"id":"http://api.ft.com/content/` + selfTestMemberUUID + `"}]}`,
+		selfTestMemberUUID:         `{"id":"http://api.ft.com/content/` + selfTestMemberUUID + `","type":"http://www.ft.com/ontology/content/MediaResource"}`,
+		selfTestDynamicContentUUID: `{"id":"http://api.ft.com/content/` + selfTestDynamicContentUUID + `","type":"` + DynamicContentType + `"}`,
+	}
+	contents := make(map[string]Content)
+	for uuid, m := range models {
+		var c Content
+		assert.NoError(t, json.Unmarshal([]byte(m), &c))
+		contents[uuid] = c
+	}
+	return contents
+}
+
+// selfTestForTest unrolls with a reader returning the given models, whatever was asked for.
+func selfTestForTest(models map[string]Content, cfg SelfTestConfig) *SelfTest {
+	u := NewContentUnroller(&ReaderMock{
+		mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			return models, nil
+		},
+	}, "test.api.ft.com")
+	return NewSelfTest(u.UnrollContent, cfg, NewUnrollLimits(LimitsConfig{}))
+}
+
+func TestSelfTest_SyntheticArticle(t *testing.T) {
+	models := selfTestModelsForTest(t)
+	s := selfTestForTest(models, SelfTestConfig{ImageSetUUID: selfTestImageSetUUID, DynamicContentUUID: selfTestDynamicContentUUID})
+
+	result := s.Run(context.Background())
+	assert.True(t, result.Passed, "Unexpected failures: %v", result.Failures)
+	assert.Equal(t, selfTestArticleUUID, result.UUID)
+
+	delete(models, selfTestDynamicContentUUID)
+	result = s.Run(context.Background())
+	assert.False(t, result.Passed, "The dynamic content wasn't expanded")
+	assert.Contains(t, result.Failures, "1 of 2 embeds were expanded")
+
+	delete(models, selfTestImageSetUUID)
+	result = s.Run(context.Background())
+	assert.False(t, result.Passed)
+	assert.Contains(t, result.Failures, "the main image wasn't expanded")
+}
+
+func TestSelfTest_CanaryFile(t *testing.T) {
+	s := selfTestForTest(selfTestModelsForTest(t), SelfTestConfig{CanaryFile: "../test-resources/content-valid-request.json"})
+	result := s.Run(context.Background())
+	assert.NotEmpty(t, result.Failures, "The models of the canary article aren't all returned")
+	assert.Equal(t, "22c0d426-1466-11e7-b0c1-37e417ee6c76", result.UUID)
+
+	s.UpdateConfig(SelfTestConfig{CanaryFile: "missing.json"})
+	result = s.Run(context.Background())
+	assert.False(t, result.Passed)
+	assert.Contains(t, result.Failures[0], "Unable to read the canary article")
+}
+
+func TestSelfTest_Handler(t *testing.T) {
+	models := selfTestModelsForTest(t)
+	s := selfTestForTest(models, SelfTestConfig{})
+
+	w := httptest.NewRecorder()
+	s.Handler(w, httptest.NewRequest(http.MethodGet, "/__self-test", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "The self-test isn't configured")
+
+	s.UpdateConfig(SelfTestConfig{ImageSetUUID: selfTestImageSetUUID})
+	w = httptest.NewRecorder()
+	s.Handler(w, httptest.NewRequest(http.MethodGet, "/__self-test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"passed":true`)
+
+	delete(models, selfTestMemberUUID)
+	delete(models, selfTestImageSetUUID)
+	s.now = func() time.Time { return time.Now().Add(minSelfTestReuse) }
+	w = httptest.NewRecorder()
+	s.Handler(w, httptest.NewRequest(http.MethodGet, "/__self-test", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"passed":false`)
+}
+
+func TestSelfTest_HandlerServesTheRecentResult(t *testing.T) {
+	runs := 0
+	u := NewContentUnroller(&ReaderMock{
+		mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			runs++
+			return selfTestModelsForTest(t), nil
+		},
+	}, "test.api.ft.com")
+	s := NewSelfTest(u.UnrollContent, SelfTestConfig{DynamicContentUUID: selfTestDynamicContentUUID, Interval: time.Minute}, NewUnrollLimits(LimitsConfig{}))
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		s.Handler(w, httptest.NewRequest(http.MethodGet, "/__self-test", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, 1, runs, "The result should be served within the interval")
+
+	now = now.Add(time.Minute)
+	w := httptest.NewRecorder()
+	s.Handler(w, httptest.NewRequest(http.MethodGet, "/__self-test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, runs, "The self-test should run again after the interval")
+}
+
+func TestSelfTest_Check(t *testing.T) {
+	models := selfTestModelsForTest(t)
+	s := selfTestForTest(models, SelfTestConfig{ImageSetUUID: selfTestImageSetUUID})
+	check := s.Check()
+	assert.Equal(t, uint8(3), check.Severity)
+
+	msg, err := check.Checker()
+	assert.NoError(t, err)
+	assert.Equal(t, "The self-test hasn't run yet", msg)
+
+	s.Run(context.Background())
+	msg, err = check.Checker()
+	assert.NoError(t, err)
+	assert.Contains(t, msg, "Passed. Last run at")
+
+	delete(models, selfTestImageSetUUID)
+	s.Run(context.Background())
+	_, err = check.Checker()
+	assert.Error(t, err)
+}
+
+func TestSelfTest_CheckFailsWhenTheResultIsStale(t *testing.T) {
+	s := selfTestForTest(selfTestModelsForTest(t), SelfTestConfig{ImageSetUUID: selfTestImageSetUUID, Interval: time.Minute})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	s.Run(context.Background())
+
+	now = now.Add(2 * time.Minute)
+	_, err := s.Check().Checker()
+	assert.NoError(t, err)
+
+	now = now.Add(time.Second)
+	msg, err := s.Check().Checker()
+	assert.Error(t, err, "The background self-tests have stopped")
+	assert.Contains(t, msg, "have stopped")
+}
+
+func TestSelfTest_RunsWithinTheLimits(t *testing.T) {
+	u := NewContentUnroller(&ReaderMock{
+		mockGet: func(uuids []string, tid string) (map[string]Content, error) {
+			time.Sleep(50 * time.Millisecond)
+			return selfTestModelsForTest(t), nil
+		},
+	}, "test.api.ft.com")
+	s := NewSelfTest(u.UnrollContent, SelfTestConfig{ImageSetUUID: selfTestImageSetUUID}, NewUnrollLimits(LimitsConfig{Timeout: 10 * time.Millisecond}))
+	result := s.Run(context.Background())
+	assert.False(t, result.Passed)
+	assert.Contains(t, result.Failures, "the timeout limit of 10ms was hit: the unroll ran out of time")
+
+	s = NewSelfTest(u.UnrollContent, SelfTestConfig{ImageSetUUID: selfTestImageSetUUID, DynamicContentUUID: selfTestDynamicContentUUID}, NewUnrollLimits(LimitsConfig{MaxEmbeds: 1}))
+	result = s.Run(context.Background())
+	assert.False(t, result.Passed, "The synthetic article has 2 embeds")
+}
+
+func TestSelfTest_Poll(t *testing.T) {
+	s := selfTestForTest(selfTestModelsForTest(t), SelfTestConfig{DynamicContentUUID: selfTestDynamicContentUUID})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Poll(ctx, 10*time.Millisecond)
+
+	var last *selfTestResult
+	for i := 0; i < 100 && last == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		last = s.lastResult()
+	}
+	if assert.NotNil(t, last, "The self-test should run in the background") {
+		assert.True(t, last.Passed, "Unexpected failures: %v", last.Failures)
+	}
+}
+
+func TestLoadConfig_SelfTest(t *testing.T) {
+	canary, err := ioutil.TempFile("", "canary")
+	assert.NoError(t, err)
+	defer os.Remove(canary.Name())
+
+	cfg, err := parseConfig([]byte("selfTest:\n  canaryFile: "+canary.Name()+"\n  interval: 1m\n"), defaultConfigForTest())
+	assert.NoError(t, err)
+	assert.Equal(t, SelfTestConfig{CanaryFile: canary.Name(), Interval: time.Minute}, cfg.SelfTest)
+
+	_, err = parseConfig([]byte("selfTest:\n  imageSetUUID: not-a-uuid\n"), defaultConfigForTest())
+	assert.Error(t, err)
+	_, err = parseConfig([]byte("selfTest:\n  canaryFile: canary.json\n  imageSetUUID: "+selfTestImageSetUUID+"\n"), defaultConfigForTest())
+	assert.Error(t, err, "The canary article replaces the synthetic one")
+}
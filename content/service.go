@@ -1,7 +1,12 @@
 package content
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -16,6 +21,7 @@ const (
 	bodyXML            = "bodyXML"
 	promotionalImage   = "promotionalImage"
 	image              = "image"
+	contentType        = "type"
 )
 
 type Unroller interface {
@@ -26,12 +32,12 @@ type Unroller interface {
 }
 
 type ContentUnroller struct {
-	reader  Reader
-	apiHost string
+	reader    Reader
+	mu        sync.RWMutex
+	apiHost   string
+	expansion ExpansionConfig
 }
 
-type Content map[string]interface{}
-
 type ContentSchema map[string][]string
 
 func NewContentUnroller(r Reader, apiHost string) *ContentUnroller {
@@ -41,82 +47,97 @@ func NewContentUnroller(r Reader, apiHost string) *ContentUnroller {
 	}
 }
 
+// UpdateConfig applies a new API host and expansion rules to the following unrolls.
+func (u *ContentUnroller) UpdateConfig(apiHost string, expansion ExpansionConfig) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.apiHost = apiHost
+	u.expansion = expansion
+}
+
+func (u *ContentUnroller) config() (string, ExpansionConfig) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.apiHost, u.expansion
+}
+
 func (u *ContentUnroller) UnrollContent(req UnrollEvent) UnrollResult {
 	//make a copy of the content
 	cc := req.c.clone()
+	report := newExpansionReport()
+	req.ctx = withExpansionReport(req.ctx, report)
 
-	schema := u.createContentSchema(cc, []string{ImageSetType, DynamicContentType}, req.tid, req.uuid)
+	_, expansion := u.config()
+	schema := u.createContentSchema(req.ctx, cc, expansion, expansion.acceptedTypes(ImageSetType, DynamicContentType), req.tid, req.uuid, report)
+	if err := budgetOf(req.ctx).rejected(); err != nil {
+		return UnrollResult{req.c, err, report}
+	}
 	if schema != nil {
-		contentMap, err := u.reader.Get(schema.toArray(), req.tid)
+		contentMap, err := u.reader.Get(req.ctx, schema.toArray(), req.tid)
 		if err != nil {
-			return UnrollResult{req.c, errors.Wrapf(err, "Error while getting expanded content for uuid: %v", req.uuid)}
+			return UnrollResult{req.c, errors.Wrapf(err, "Error while getting expanded content for uuid: %v", req.uuid), report}
 		}
-		u.resolveModelsForSetsMembers(schema, contentMap, req.tid, req.tid)
+		imageSets := u.resolveModelsForSetsMembers(schema, contentMap, req.tid, req.tid, report)
 
 		mainImageUUID := schema.get(mainImage)
 		if mainImageUUID != "" {
-			cc[mainImage] = contentMap[mainImageUUID]
+			cc.MainImage = imageSets[mainImageUUID]
 		}
 
 		embeddedContentUUIDs := schema.getAll(embeds)
 		if len(embeddedContentUUIDs) > 0 {
-			embedded := []Content{}
+			embedded := []Embedded{}
 			for _, emb := range embeddedContentUUIDs {
-				embedded = append(embedded, contentMap[emb])
+				embedded = append(embedded, u.resolveEmbedded(emb, imageSets, contentMap))
 			}
-			cc[embeds] = embedded
+			cc.Embeds = embedded
 		}
 
-		promImgUUID := schema.get(promotionalImage)
-		if promImgUUID != "" {
-			pi, found := contentMap[promImgUUID]
-			if found {
-				cc[altImages].(map[string]interface{})[promotionalImage] = pi
-			}
-		}
+		u.resolvePromotionalImage(cc, schema, contentMap, report)
 	}
 
-	return UnrollResult{cc, nil}
+	return UnrollResult{cc, nil, report}
 }
 
 func (u *ContentUnroller) UnrollContentPreview(req UnrollEvent) UnrollResult {
 	//make a copy of the content
 	cc := req.c.clone()
-	unrolledEmbedded := []Content{}
+	report := newExpansionReport()
+	req.ctx = withExpansionReport(req.ctx, report)
+	unrolledEmbedded := []Embedded{}
+	_, expansion := u.config()
 
-	schema := u.createContentSchema(cc, []string{ImageSetType}, req.tid, req.uuid)
+	schema := u.createContentSchema(req.ctx, cc, expansion, expansion.acceptedTypes(ImageSetType), req.tid, req.uuid, report)
+	if err := budgetOf(req.ctx).rejected(); err != nil {
+		return UnrollResult{req.c, err, report}
+	}
 	if schema != nil {
-		contentMap, err := u.reader.Get(schema.toArray(), req.tid)
+		contentMap, err := u.reader.Get(req.ctx, schema.toArray(), req.tid)
 		if err != nil {
 			logger.Errorf(req.tid, "Error while getting expanded images: %s", err.Error())
+			schema.reportAll(report, reasonUpstreamError, err.Error())
 		} else {
-			u.resolveModelsForSetsMembers(schema, contentMap, req.tid, req.tid)
+			imageSets := u.resolveModelsForSetsMembers(schema, contentMap, req.tid, req.tid, report)
 
 			mainImageUUID := schema.get(mainImage)
 			if mainImageUUID != "" {
-				cc[mainImage] = contentMap[mainImageUUID]
+				cc.MainImage = imageSets[mainImageUUID]
 			}
 
 			// this is only for embedded images
 			embeddedContentUUIDs := schema.getAll(embeds)
 			if len(embeddedContentUUIDs) > 0 {
 				for _, emb := range embeddedContentUUIDs {
-					unrolledEmbedded = append(unrolledEmbedded, contentMap[emb])
+					unrolledEmbedded = append(unrolledEmbedded, u.resolveEmbedded(emb, imageSets, contentMap))
 				}
 			}
 
-			promImgUUID := schema.get(promotionalImage)
-			if promImgUUID != "" {
-				pi, found := contentMap[promImgUUID]
-				if found {
-					cc[altImages].(map[string]interface{})[promotionalImage] = pi
-				}
-			}
+			u.resolvePromotionalImage(cc, schema, contentMap, report)
 		}
 	}
 
 	// unroll dynamic content from Native content source
-	dynContents, foundDyn := u.unrollDynamicContent(cc, req.tid, req.uuid, u.reader.GetPreview)
+	dynContents, foundDyn := u.unrollDynamicContent(req.ctx, cc, expansion, req.tid, req.uuid, u.reader.GetPreview, report)
 	if foundDyn {
 		for _, dynC := range dynContents {
 			unrolledEmbedded = append(unrolledEmbedded, dynC)
@@ -124,88 +145,107 @@ func (u *ContentUnroller) UnrollContentPreview(req UnrollEvent) UnrollResult {
 	}
 
 	if len(unrolledEmbedded) > 0 {
-		cc[embeds] = unrolledEmbedded
+		cc.Embeds = unrolledEmbedded
 	}
 
-	return UnrollResult{cc, nil}
+	return UnrollResult{cc, nil, report}
 }
 
 func (u *ContentUnroller) UnrollInternalContent(req UnrollEvent) UnrollResult {
 	cc := req.c.clone()
-	expLeadImages, foundImages := u.unrollLeadImages(cc, req.tid, req.uuid)
-	if foundImages {
-		cc[leadImages] = expLeadImages
+	report := newExpansionReport()
+	req.ctx = withExpansionReport(req.ctx, report)
+	_, expansion := u.config()
+	// the embeds are checked first, an article with too many isn't read at all
+	dynContents, foundDyn := u.unrollDynamicContent(req.ctx, cc, expansion, req.tid, req.uuid, u.reader.GetInternal, report)
+	if err := budgetOf(req.ctx).rejected(); err != nil {
+		return UnrollResult{req.c, err, report}
 	}
-
-	dynContents, foundDyn := u.unrollDynamicContent(cc, req.tid, req.uuid, u.reader.GetInternal)
 	if foundDyn {
-		cc[embeds] = dynContents
+		cc.Embeds = dynContents
+	}
+
+	expLeadImages, foundImages := u.unrollLeadImages(req.ctx, cc, expansion, req.tid, req.uuid, report)
+	if foundImages {
+		cc.LeadImages = expLeadImages
 	}
 
-	return UnrollResult{cc, nil}
+	return UnrollResult{cc, nil, report}
 }
 
 func (u *ContentUnroller) UnrollInternalContentPreview(req UnrollEvent) UnrollResult {
 	cc := req.c.clone()
-	expLeadImages, foundImages := u.unrollLeadImages(cc, req.tid, req.uuid)
-	if foundImages {
-		cc[leadImages] = expLeadImages
+	report := newExpansionReport()
+	req.ctx = withExpansionReport(req.ctx, report)
+	_, expansion := u.config()
+	// the embeds are checked first, an article with too many isn't read at all
+	dynContents, foundDyn := u.unrollDynamicContent(req.ctx, cc, expansion, req.tid, req.uuid, u.reader.GetInternalPreview, report)
+	if err := budgetOf(req.ctx).rejected(); err != nil {
+		return UnrollResult{req.c, err, report}
 	}
-
-	dynContents, foundDyn := u.unrollDynamicContent(cc, req.tid, req.uuid, u.reader.GetInternalPreview)
 	if foundDyn {
-		cc[embeds] = dynContents
+		cc.Embeds = dynContents
+	}
+
+	expLeadImages, foundImages := u.unrollLeadImages(req.ctx, cc, expansion, req.tid, req.uuid, report)
+	if foundImages {
+		cc.LeadImages = expLeadImages
 	}
 
-	return UnrollResult{cc, nil}
+	return UnrollResult{cc, nil, report}
 }
 
-func (u *ContentUnroller) createContentSchema(cc Content, acceptedTypes []string, tid string, uuid string) ContentSchema {
+func (u *ContentUnroller) createContentSchema(ctx context.Context, cc *Article, expansion ExpansionConfig, acceptedTypes []string, tid string, uuid string, report *ExpansionReport) ContentSchema {
+	ctx, span := tracer.Start(ctx, "createContentSchema")
+	defer span.End()
+
 	//mainImage
 	schema := make(ContentSchema)
-	mi, foundMainImg := cc[mainImage].(map[string]interface{})
-	if foundMainImg {
-		u, err := extractUUIDFromString(mi[id].(string))
-		if err != nil {
-			logger.Infof(tid, uuid, "Cannot find main image: %v. Skipping expanding main image", err.Error())
-			foundMainImg = false
+	var foundMainImg bool
+	if !expansion.enabled(expandMainImage) {
+		logger.Info(tid, uuid, "Expanding the main image is turned off")
+	} else if cc.has(mainImage) {
+		if imgUUID, ok := extractRefUUID(cc.MainImage.content(), mainImage, report); ok {
+			schema.put(mainImage, imgUUID)
+			foundMainImg = true
 		} else {
-			schema.put(mainImage, u)
+			logger.Info(tid, uuid, "Cannot find a valid main image. Skipping expanding main image")
 		}
 	} else {
 		logger.Info(tid, uuid, "Cannot find main image. Skipping expanding main image")
 	}
 
 	//embedded - images and dynamic content
-	emContentUUIDs, foundEmbedded := u.extractEmbeddedContentByType(cc, acceptedTypes, tid, uuid)
+	emContentUUIDs, foundEmbedded := u.extractEmbeddedContentByType(ctx, cc, acceptedTypes, tid, uuid, report)
 	if foundEmbedded {
 		schema.putAll(embeds, emContentUUIDs)
 	}
 
 	//promotional image
 	var foundPromImg bool
-	altImg, found := cc[altImages].(map[string]interface{})
-	if found {
-		var promImg map[string]interface{}
-		promImg, foundPromImg = altImg[promotionalImage].(map[string]interface{})
-		if foundPromImg {
-			if id, ok := promImg[id].(string); ok {
-				u, err := extractUUIDFromString(id)
-				if err != nil {
-					logger.Infof(tid, uuid, "Cannot find promotional image: %v. Skipping expanding promotional image", err.Error())
-					foundPromImg = false
-				} else {
-					schema.put(promotionalImage, u)
-				}
+	if cc.has(altImages) && expansion.enabled(expandPromotionalImage) {
+		altImg := cc.AlternativeImages
+		if altImg == nil {
+			report.add(altImages, reasonInvalidType, "expected an object")
+		} else if altImg.has(promotionalImage) {
+			field := altImages + "." + promotionalImage
+			if imgUUID, ok := extractRefUUID(altImg.PromotionalImage.content(), field, report); ok {
+				schema.put(promotionalImage, imgUUID)
+				foundPromImg = true
 			} else {
-				logger.Info(tid, uuid, "Promotional image is missing the id field. Skipping expanding promotional image")
-				foundPromImg = false
+				logger.Info(tid, uuid, "Cannot find a valid promotional image. Skipping expanding promotional image")
 			}
 		} else {
 			logger.Info(tid, uuid, "Cannot find promotional image. Skipping expanding promotional image")
 		}
 	}
 
+	span.SetAttributes(
+		attribute.String("content.uuid", uuid),
+		attribute.Int("content.embeds.count", len(schema.getAll(embeds))),
+		attribute.Bool("content.mainImage", foundMainImg),
+		attribute.Bool("content.promotionalImage", foundPromImg),
+	)
 	if !foundMainImg && !foundEmbedded && !foundPromImg {
 		logger.Infof(tid, uuid, "No main image or promotional image or embedded content to expand for supplied content %s", uuid)
 		return nil
@@ -214,193 +254,242 @@ func (u *ContentUnroller) createContentSchema(cc Content, acceptedTypes []string
 	return schema
 }
 
-func (u *ContentUnroller) unrollLeadImages(cc Content, tid string, uuid string) ([]Content, bool) {
-	images, foundLeadImages := cc[leadImages].([]interface{})
-	if !foundLeadImages {
+func (u *ContentUnroller) resolvePromotionalImage(cc *Article, schema ContentSchema, contentMap map[string]Content, report *ExpansionReport) {
+	promImgUUID := schema.get(promotionalImage)
+	if promImgUUID == "" {
+		return
+	}
+
+	pi, found := u.resolveContent(promImgUUID, contentMap)
+	if !found {
+		report.add(altImages+"."+promotionalImage, reasonMissingModel, promImgUUID)
+		return
+	}
+
+	altImg := *cc.AlternativeImages
+	altImg.PromotionalImage = pi.asMediaResource()
+	cc.AlternativeImages = &altImg
+}
+
+func (u *ContentUnroller) unrollLeadImages(ctx context.Context, cc *Article, expansion ExpansionConfig, tid string, uuid string, report *ExpansionReport) ([]*LeadImage, bool) {
+	if !expansion.enabled(expandLeadImages) {
+		logger.Info(tid, uuid, "Expanding lead images is turned off")
+		return nil, false
+	}
+	if !cc.has(leadImages) {
 		logger.Info(tid, uuid, "No lead images to expand for supplied content")
 		return nil, false
 	}
 
+	images := cc.LeadImages
+	if images == nil {
+		report.add(leadImages, reasonInvalidType, "expected an array")
+		return nil, false
+	}
+
 	if len(images) == 0 {
 		logger.Info(tid, uuid, "No lead images to expand for supplied content")
 		return nil, false
 	}
+
 	schema := make(ContentSchema)
-	for _, item := range images {
-		li := item.(map[string]interface{})
-		uuid, err := extractUUIDFromString(li[id].(string))
-		if err != nil {
-			logger.Infof(tid, uuid, "Error while getting UUID for %s: %v", li[id].(string), err.Error())
+	imgUUIDs := make([]string, len(images))
+	for i, li := range images {
+		field := fmt.Sprintf("%s[%d]", leadImages, i)
+		imgUUID, ok := extractRefUUID(li.content(), field, report)
+		if !ok {
+			logger.Infof(tid, uuid, "Cannot get a valid UUID for %s. Skipping expanding it", field)
 			continue
 		}
-		li[image] = uuid
-		schema.put(leadImages, uuid)
+		imgUUIDs[i] = imgUUID
+		schema.put(leadImages, imgUUID)
 	}
 
-	imgMap, err := u.reader.Get(schema.toArray(), tid)
+	if len(schema) == 0 {
+		return nil, false
+	}
+
+	imgMap, err := u.reader.Get(ctx, schema.toArray(), tid)
 	if err != nil {
 		logger.Errorf(tid, "Error while getting content for expanded images %s", err.Error())
-
-		// couldn't get the images so we have to delete the additional uuid field (previously added)
-		for _, li := range images {
-			rawLi := li.(map[string]interface{})
-			delete(rawLi, image)
-		}
-
+		schema.reportAll(report, reasonUpstreamError, err.Error())
 		return nil, false
 	}
 
-	expLeadImages := []Content{}
-	for _, li := range images {
-		rawLi := li.(map[string]interface{})
-		rawLiUUID := rawLi[image].(string)
-		liContent := fromMap(rawLi)
-		imageData, found := u.resolveContent(rawLiUUID, imgMap)
+	expLeadImages := []*LeadImage{}
+	for i, li := range images {
+		if imgUUIDs[i] == "" {
+			expLeadImages = append(expLeadImages, li)
+			continue
+		}
+		imageData, found := u.resolveContent(imgUUIDs[i], imgMap)
 		if !found {
-			logger.Infof(tid, uuid, "Missing image model %s. Returning only the id.", rawLiUUID)
-			delete(liContent, image)
-			expLeadImages = append(expLeadImages, liContent)
+			logger.Infof(tid, uuid, "Missing image model %s. Returning only the id.", imgUUIDs[i])
+			report.add(fmt.Sprintf("%s[%d]", leadImages, i), reasonMissingModel, imgUUIDs[i])
+			expLeadImages = append(expLeadImages, li)
 			continue
 		}
-		liContent[image] = imageData
-		expLeadImages = append(expLeadImages, liContent)
+		expLeadImage := *li
+		expLeadImage.Image = imageData.asMediaResource()
+		expLeadImages = append(expLeadImages, &expLeadImage)
 	}
 
-	cc[leadImages] = expLeadImages
 	return expLeadImages, true
 }
 
-func (u *ContentUnroller) unrollDynamicContent(cc Content, tid string, uuid string, getContentFromSourceFn ReaderFunc) ([]Content, bool) {
-	emContentUUIDs, foundEmbedded := u.extractEmbeddedContentByType(cc, []string{DynamicContentType}, tid, uuid)
+func (u *ContentUnroller) unrollDynamicContent(ctx context.Context, cc *Article, expansion ExpansionConfig, tid string, uuid string, getContentFromSourceFn ReaderFunc, report *ExpansionReport) ([]Embedded, bool) {
+	acceptedTypes := expansion.acceptedTypes(DynamicContentType)
+	if len(acceptedTypes) == 0 {
+		return nil, false
+	}
+	emContentUUIDs, foundEmbedded := u.extractEmbeddedContentByType(ctx, cc, acceptedTypes, tid, uuid, report)
 	if !foundEmbedded {
 		return nil, false
 	}
 
-	contentMap, err := getContentFromSourceFn(emContentUUIDs, tid)
+	contentMap, err := getContentFromSourceFn(ctx, emContentUUIDs, tid)
 	if err != nil {
 		logger.Errorf(tid, "Error while getting embedded dynamic content %s", err.Error())
+		report.add(embeds, reasonUpstreamError, err.Error())
 		return nil, false
 	}
 
-	embedded := []Content{}
+	embedded := []Embedded{}
 	for _, ec := range emContentUUIDs {
-		embedded = append(embedded, contentMap[ec])
+		dc, found := u.resolveContent(ec, contentMap)
+		if !found {
+			report.add(embeds, reasonMissingModel, ec)
+			embedded = append(embedded, nil)
+			continue
+		}
+		embedded = append(embedded, dc.asDynamicContent())
 	}
 
 	return embedded, true
 }
 
-func (u *ContentUnroller) resolveModelsForSetsMembers(b ContentSchema, imgMap map[string]Content, tid string, uuid string) {
+// resolveModelsForSetsMembers returns the main and embedded image sets with all their members expanded.
+func (u *ContentUnroller) resolveModelsForSetsMembers(b ContentSchema, contentMap map[string]Content, tid string, uuid string, report *ExpansionReport) map[string]*ImageSet {
+	imageSets := make(map[string]*ImageSet)
 	mainImageUUID := b.get(mainImage)
-	u.resolveImageSet(mainImageUUID, imgMap, tid, uuid)
+	if mainImageUUID != "" {
+		imageSets[mainImageUUID] = u.resolveImageSet(mainImageUUID, mainImage, contentMap, tid, uuid, report)
+	}
 	for _, embeddedImgSet := range b.getAll(embeds) {
-		u.resolveImageSet(embeddedImgSet, imgMap, tid, uuid)
+		if is := u.resolveImageSet(embeddedImgSet, embeds, contentMap, tid, uuid, report); is != nil {
+			imageSets[embeddedImgSet] = is
+		}
 	}
+	return imageSets
 }
 
-func (u *ContentUnroller) resolveImageSet(imageSetUUID string, imgMap map[string]Content, tid string, uuid string) {
-	imageSet, found := u.resolveContent(imageSetUUID, imgMap)
+// resolveImageSet returns the image set with its members expanded, a placeholder with only the id
+// if the image set couldn't be read, or nil if the content isn't an image set.
+func (u *ContentUnroller) resolveImageSet(imageSetUUID string, field string, contentMap map[string]Content, tid string, uuid string, report *ExpansionReport) *ImageSet {
+	c, found := u.resolveContent(imageSetUUID, contentMap)
 	if !found {
-		imgMap[imageSetUUID] = Content{id: createID(u.apiHost, "content", imageSetUUID)}
-		return
+		report.add(field, reasonMissingModel, imageSetUUID)
+		apiHost, _ := u.config()
+		return newImageSetPlaceholder(createID(apiHost, "content", imageSetUUID))
+	}
+	if c.Type == DynamicContentType {
+		return nil
 	}
 
-	rawMembers, found := imageSet[members]
-	if found {
-		membList, ok := rawMembers.([]interface{})
+	imageSet := c.asImageSet()
+	if !imageSet.has(members) {
+		return imageSet
+	}
+	if imageSet.Members == nil {
+		report.add(field+"."+members, reasonInvalidType, imageSetUUID)
+		return imageSet
+	}
+
+	expMembers := []*MediaResource{}
+	for i, m := range imageSet.Members {
+		mField := fmt.Sprintf("%s.%s[%d]", field, members, i)
+		mUUID, ok := extractRefUUID(m.content(), mField, report)
 		if !ok {
-			return
+			logger.Infof(tid, uuid, "Cannot get a valid UUID for %s of image set %s", mField, imageSetUUID)
+			continue
 		}
-
-		expMembers := []Content{}
-		for _, m := range membList {
-			mData := fromMap(m.(map[string]interface{}))
-			mID := mData[id].(string)
-			mUUID, err := extractUUIDFromString(mID)
-			if err != nil {
-				logger.Infof(tid, uuid, "Error while extracting UUID from %s: %v", mID, err.Error())
-				continue
-			}
-			mContent, found := u.resolveContent(mUUID, imgMap)
-			if !found {
-				expMembers = append(expMembers, mData)
-				continue
-			}
-			mData.merge(mContent)
-			expMembers = append(expMembers, mData)
+		mContent, found := u.resolveContent(mUUID, contentMap)
+		if !found {
+			expMembers = append(expMembers, m)
+			continue
 		}
-		imageSet[members] = expMembers
+		expMembers = append(expMembers, &MediaResource{m.merged(mContent)})
 	}
+	imageSet.Members = expMembers
+	return imageSet
+}
 
+// resolveEmbedded returns the model of embedded content: an expanded image set or dynamic content.
+func (u *ContentUnroller) resolveEmbedded(emUUID string, imageSets map[string]*ImageSet, contentMap map[string]Content) Embedded {
+	if is, found := imageSets[emUUID]; found {
+		return is
+	}
+	return contentMap[emUUID].asDynamicContent()
 }
 
-func (u *ContentUnroller) resolveContent(uuid string, imgMap map[string]Content) (Content, bool) {
-	c, found := imgMap[uuid]
+func (u *ContentUnroller) resolveContent(uuid string, contentMap map[string]Content) (Content, bool) {
+	c, found := contentMap[uuid]
 	if !found {
 		return Content{}, false
 	}
 	return c, true
 }
 
-func (u *ContentUnroller) extractEmbeddedContentByType(cc Content, acceptedTypes []string, tid string, uuid string) ([]string, bool) {
-	body, foundBody := cc[bodyXML]
-	if !foundBody {
+func (u *ContentUnroller) extractEmbeddedContentByType(ctx context.Context, cc *Article, acceptedTypes []string, tid string, uuid string, report *ExpansionReport) ([]string, bool) {
+	if !cc.has(bodyXML) {
 		logger.Info(tid, uuid, "Missing body. Skipping expanding embedded content and images.")
 		return nil, false
 	}
 
-	bodyXML := body.(string)
-	emContentUUIDs, err := getEmbedded(bodyXML, acceptedTypes, tid, uuid)
+	if cc.BodyXML == nil {
+		report.add(bodyXML, reasonInvalidType, "expected a string")
+		return nil, false
+	}
+	_, span := tracer.Start(ctx, "parseBodyXML")
+	emContentUUIDs, err := getEmbedded(*cc.BodyXML, acceptedTypes, tid, uuid)
+	span.SetAttributes(attribute.Int("content.bodyXML.length", len(*cc.BodyXML)), attribute.Int("content.embeds.count", len(emContentUUIDs)))
+	endSpan(span, err)
 	if err != nil {
 		logger.Errorf(tid, "Cannot parse bodyXML for content %s", err.Error())
+		report.add(bodyXML, reasonInvalidBody, err.Error())
 		return nil, false
 	}
 
 	if len(emContentUUIDs) == 0 {
 		return nil, false
 	}
+	if !budgetOf(ctx).checkEmbeds(len(emContentUUIDs)) {
+		logger.Warnf(tid, uuid, "Not expanding the %d embeds of the body, there are too many", len(emContentUUIDs))
+		return nil, false
+	}
 
 	return emContentUUIDs, true
 }
 
-func (c Content) clone() Content {
-	clone := make(Content)
-	for k, v := range c {
-		clone[k] = v
+// extractRefUUID returns the UUID of a reference object such as {"id": "http://www.ft.com/thing/<uuid>"},
+// reporting the field if the object doesn't have the expected shape.
+func extractRefUUID(ref *Content, field string, report *ExpansionReport) (string, bool) {
+	if ref == nil || !ref.isObject() {
+		report.add(field, reasonInvalidType, "expected an object")
+		return "", false
 	}
-	return clone
-}
 
-func (c Content) getMembersUUID() []string {
-	uuids := []string{}
-	members, found := c[members]
-	if !found {
-		return uuids
+	if ref.ID == "" {
+		report.add(field, reasonMissingID, "")
+		return "", false
 	}
 
-	memList, ok := members.([]interface{})
-	if !ok {
-		return uuids
-	}
-	for _, m := range memList {
-		mData := m.(map[string]interface{})
-		url, found := mData[id].(string)
-		if !found {
-			continue
-		}
-		u, err := extractUUIDFromString(url)
-		if err != nil {
-			continue
-		}
-		uuids = append(uuids, u)
-	}
-	return uuids
-}
-
-func (c Content) merge(src Content) {
-	for k, v := range src {
-		c[k] = v
+	refUUID, err := extractUUIDFromString(ref.ID)
+	if err != nil {
+		report.add(field, reasonInvalidUUID, ref.ID)
+		return "", false
 	}
+	return refUUID, true
 }
 
 func (u ContentSchema) put(key string, value string) {
@@ -442,17 +531,18 @@ func (u ContentSchema) getAll(key string) []string {
 	return u[key]
 }
 
+// reportAll marks every field present in the schema as not expanded for the given reason.
+func (u ContentSchema) reportAll(report *ExpansionReport, reason string, detail string) {
+	for _, field := range []string{mainImage, embeds, promotionalImage, leadImages} {
+		if _, found := u[field]; found {
+			report.add(field, reason, detail)
+		}
+	}
+}
+
 func (u ContentSchema) toArray() (UUIDs []string) {
 	for _, v := range u {
 		UUIDs = append(UUIDs, v...)
 	}
 	return UUIDs
 }
-
-func fromMap(src map[string]interface{}) Content {
-	dest := Content{}
-	for k, v := range src {
-		dest[k] = v
-	}
-	return dest
-}
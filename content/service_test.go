@@ -1,8 +1,11 @@
 package content
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -21,19 +24,19 @@ type ReaderMock struct {
 	mockGetInternalPreview func([]string, string) (map[string]Content, error)
 }
 
-func (rm *ReaderMock) Get(c []string, tid string) (map[string]Content, error) {
+func (rm *ReaderMock) Get(ctx context.Context, c []string, tid string) (map[string]Content, error) {
 	return rm.mockGet(c, tid)
 }
 
-func (rm *ReaderMock) GetInternal(c []string, tid string) (map[string]Content, error) {
+func (rm *ReaderMock) GetInternal(ctx context.Context, c []string, tid string) (map[string]Content, error) {
 	return rm.mockGetInternal(c, tid)
 }
 
-func (rm *ReaderMock) GetPreview(c []string, tid string) (map[string]Content, error) {
+func (rm *ReaderMock) GetPreview(ctx context.Context, c []string, tid string) (map[string]Content, error) {
 	return rm.mockGetPreview(c, tid)
 }
 
-func (rm *ReaderMock) GetInternalPreview(c []string, tid string) (map[string]Content, error) {
+func (rm *ReaderMock) GetInternalPreview(ctx context.Context, c []string, tid string) (map[string]Content, error) {
 	return rm.mockGetInternalPreview(c, tid)
 }
 
@@ -55,12 +58,12 @@ func TestUnrollContent(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/content-valid-response.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContent(req)
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
 
@@ -70,11 +73,11 @@ func TestUnrollContent(t *testing.T) {
 
 func TestUnrollContent_NilSchema(t *testing.T) {
 	cu := ContentUnroller{reader: nil}
-	var c Content
+	var c Article
 	err := json.Unmarshal([]byte(InvalidBodyRequest), &c)
 	assert.NoError(t, err, "Cannot build json body")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContent(req)
 	actualJSON, err := json.Marshal(actual.uc)
 
@@ -91,12 +94,12 @@ func TestUnrollContent_ErrorExpandingFromContentStore(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContent(req)
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -105,11 +108,11 @@ func TestUnrollContent_ErrorExpandingFromContentStore(t *testing.T) {
 }
 
 func TestUnrollContent_SkipPromotionalImageWhenIdIsMissing(t *testing.T) {
-	expectedAltImages := map[string]interface{}{
-		"promotionalImage": map[string]interface{}{
-			"": "http://api.ft.com/content/4723cb4e-027c-11e7-ace0-1ce02ef0def9",
-		},
-	}
+	expectedAltImages := `{
+		"promotionalImage": {
+			"": "http://api.ft.com/content/4723cb4e-027c-11e7-ace0-1ce02ef0def9"
+		}
+	}`
 
 	cu := ContentUnroller{
 		reader: &ReaderMock{
@@ -125,24 +128,26 @@ func TestUnrollContent_SkipPromotionalImageWhenIdIsMissing(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/invalid-article-missing-promotionalImage-id.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContent(req)
 
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
-	assert.Equal(t, expectedAltImages, actual.uc[altImages])
+	actualAltImages, err := json.Marshal(actual.uc.AlternativeImages)
+	assert.NoError(t, err)
+	assert.JSONEq(t, expectedAltImages, string(actualAltImages))
 }
 
 func TestUnrollContent_SkipPromotionalImageWhenUUIDIsInvalid(t *testing.T) {
-	expectedAltImages := map[string]interface{}{
-		"promotionalImage": map[string]interface{}{
-			"id": "http://api.ft.com/content/not-uuid",
-		},
-	}
+	expectedAltImages := `{
+		"promotionalImage": {
+			"id": "http://api.ft.com/content/not-uuid"
+		}
+	}`
 
 	cu := ContentUnroller{
 		reader: &ReaderMock{
@@ -158,16 +163,18 @@ func TestUnrollContent_SkipPromotionalImageWhenUUIDIsInvalid(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/invalid-article-invalid-promotionalImage-uuid.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContent(req)
 
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
-	assert.Equal(t, expectedAltImages, actual.uc[altImages])
+	actualAltImages, err := json.Marshal(actual.uc.AlternativeImages)
+	assert.NoError(t, err)
+	assert.JSONEq(t, expectedAltImages, string(actualAltImages))
 }
 
 func TestUnrollContent_EmbeddedContentSkippedWhenMissingBodyXML(t *testing.T) {
@@ -185,16 +192,22 @@ func TestUnrollContent_EmbeddedContentSkippedWhenMissingBodyXML(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var raw map[string]interface{}
 	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read test file")
+	err = json.Unmarshal(fileBytes, &raw)
+	assert.NoError(t, err, "Cannot build json body")
+	raw[bodyXML] = "invalid body"
+	fileBytes, err = json.Marshal(raw)
+	assert.NoError(t, err, "Cannot build json body")
+	var c Article
 	err = json.Unmarshal(fileBytes, &c)
-	c[bodyXML] = "invalid body"
+	assert.NoError(t, err, "Cannot build json body")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	res := cu.UnrollContent(req)
 	assert.NoError(t, res.err, "Should not receive error when body cannot be parsed.")
-	assert.Nil(t, res.uc["embeds"], "Response should not contain embeds field")
+	assert.Nil(t, res.uc.Embeds, "Response should not contain embeds field")
 }
 
 func TestUnrollInternalContent(t *testing.T) {
@@ -220,7 +233,7 @@ func TestUnrollInternalContent(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "File necessary for building request body nod found")
 	err = json.Unmarshal(fileBytes, &c)
@@ -228,7 +241,7 @@ func TestUnrollInternalContent(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollInternalContent(req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
@@ -254,7 +267,7 @@ func TestUnrollInternalContent_LeadImagesSkippedWhenReadingError(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "File necessary for building request body nod found")
 	err = json.Unmarshal(fileBytes, &c)
@@ -262,7 +275,7 @@ func TestUnrollInternalContent_LeadImagesSkippedWhenReadingError(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response-no-lead-images.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollInternalContent(req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
@@ -288,7 +301,7 @@ func TestUnrollInternalContent_DynamicContentSkippedWhenReadingError(t *testing.
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "File necessary for building request body nod found")
 	err = json.Unmarshal(fileBytes, &c)
@@ -296,7 +309,7 @@ func TestUnrollInternalContent_DynamicContentSkippedWhenReadingError(t *testing.
 	expected, err := ioutil.ReadFile("../test-resources/internalcontent-valid-response-no-dynamic-content.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollInternalContent(req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
@@ -330,12 +343,12 @@ func TestUnrollContentPreview(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/contentpreview-valid-response.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContentPreview(req)
 	assert.NoError(t, actual.err, "Should not get an error when expanding images")
 
@@ -351,11 +364,11 @@ func TestUnrollContentPreview_NilSchema(t *testing.T) {
 			},
 		},
 	}
-	var c Content
+	var c Article
 	err := json.Unmarshal([]byte(InvalidBodyRequest), &c)
 	assert.NoError(t, err, "Cannot build json body")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContentPreview(req)
 	actualJSON, err := json.Marshal(actual.uc)
 
@@ -383,12 +396,12 @@ func TestUnrollContentPreview_ErrorExpandingImages(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/contentpreview-noimages-valid-response.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContentPreview(req)
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -408,12 +421,12 @@ func TestUnrollContentPreview_ErrorExpandingImagesAndDynamicContent(t *testing.T
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 	err = json.Unmarshal(fileBytes, &c)
 	assert.NoError(t, err, "Cannot build json body")
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollContentPreview(req)
 
 	actualJSON, err := json.Marshal(actual.uc)
@@ -443,7 +456,7 @@ func TestUnrollInternalContentPreview(t *testing.T) {
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "File necessary for building request body nod found")
 	err = json.Unmarshal(fileBytes, &c)
@@ -451,7 +464,7 @@ func TestUnrollInternalContentPreview(t *testing.T) {
 	expected, err := ioutil.ReadFile("../test-resources/internalcontentpreview-valid-response.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollInternalContentPreview(req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
@@ -477,7 +490,7 @@ func TestUnrollInternalContentPreview_LeadImagesSkippedWhenReadingError(t *testi
 		apiHost: "test.api.ft.com",
 	}
 
-	var c Content
+	var c Article
 	fileBytes, err := ioutil.ReadFile("../test-resources/internalcontent-valid-request.json")
 	assert.NoError(t, err, "File necessary for building request body nod found")
 	err = json.Unmarshal(fileBytes, &c)
@@ -485,7 +498,7 @@ func TestUnrollInternalContentPreview_LeadImagesSkippedWhenReadingError(t *testi
 	expected, err := ioutil.ReadFile("../test-resources/internalcontentpreview-valid-response-no-leadimages.json")
 	assert.NoError(t, err, "Cannot read necessary test file")
 
-	req := UnrollEvent{c, "tid_sample", "sample_uuid"}
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
 	actual := cu.UnrollInternalContentPreview(req)
 	assert.NoError(t, actual.err, "Should not receive error for expanding internal content")
 
@@ -493,8 +506,308 @@ func TestUnrollInternalContentPreview_LeadImagesSkippedWhenReadingError(t *testi
 	assert.JSONEq(t, string(actualJSON), string(expected))
 }
 
+func TestUnrollContent_DisabledExpansion(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				assert.Fail(t, "Nothing should be read when the expansion of all the fields is turned off")
+				return nil, nil
+			},
+		},
+	}
+	cu.UpdateConfig("test.api.ft.com", ExpansionConfig{Disabled: []string{expandMainImage, expandPromotionalImage, expandEmbeddedImages, expandDynamicContent}})
+
+	var c Article
+	fileBytes, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read necessary test file")
+	err = json.Unmarshal(fileBytes, &c)
+	assert.NoError(t, err, "Cannot build json body")
+
+	actual := cu.UnrollContent(UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()})
+	assert.NoError(t, actual.err)
+	actualJSON, err := json.Marshal(actual.uc)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(fileBytes), string(actualJSON))
+}
+
+func TestUnroll_TooManyEmbedsReadNothing(t *testing.T) {
+	noRead := func(c []string, tid string) (map[string]Content, error) {
+		assert.Fail(t, "Nothing should be read for an article with too many embeds")
+		return nil, nil
+	}
+	cu := NewContentUnroller(&ReaderMock{mockGet: noRead, mockGetInternal: noRead, mockGetPreview: noRead, mockGetInternalPreview: noRead}, "test.api.ft.com")
+	article := `{
+		"id": "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		"mainImage": {"id": "http://www.ft.com/thing/639cd952-149f-11e7-2ea7-a07ecd9ac73f"},
+		"leadImages": [{"id": "http://www.ft.com/thing/71231d3a-13c7-11e7-2ea7-a07ecd9ac73f"}],
+		"bodyXML": "<body><ft-content type=\"http://www.ft.com/ontology/content/ImageSet\" url=\"http://api.ft.com/content/71231d3a-13c7-11e7-2ea7-a07ecd9ac73f\" data-embedded=\"true\"></ft-content><ft-content type=\"http://www.ft.com/ontology/content/ImageSet\" url=\"http://api.ft.com/content/0261ea4a-1474-11e7-1e92-847abda1ac65\" data-embedded=\"true\"></ft-content><ft-content type=\"http://www.ft.com/ontology/content/DynamicContent\" url=\"http://api.ft.com/content/d02886fc-58ff-11e8-9859-6668838a4c10\" data-embedded=\"true\"></ft-content><ft-content type=\"http://www.ft.com/ontology/content/DynamicContent\" url=\"http://api.ft.com/content/e3a1bcb6-58ff-11e8-9859-6668838a4c10\" data-embedded=\"true\"></ft-content></body>"
+	}`
+
+	for name, unroll := range map[string]func(UnrollEvent) UnrollResult{
+		"content":                 cu.UnrollContent,
+		"content-preview":         cu.UnrollContentPreview,
+		"internalcontent":         cu.UnrollInternalContent,
+		"internalcontent-preview": cu.UnrollInternalContentPreview,
+	} {
+		var c Article
+		assert.NoError(t, json.Unmarshal([]byte(article), &c))
+		ctx := withUnrollBudget(context.Background(), newUnrollBudget(LimitsConfig{MaxEmbeds: 1}))
+
+		actual := unroll(UnrollEvent{&c, "tid_sample", "sample_uuid", ctx})
+		assert.Equal(t, http.StatusUnprocessableEntity, limitStatus(actual.err, http.StatusOK), name)
+	}
+}
+
+func TestUnrollContent_MalformedFieldsAreReported(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				assert.Fail(t, "Nothing should be read for content without any valid field to expand")
+				return nil, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	malformed := `{
+		"id": "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		"mainImage": {"id": 42},
+		"bodyXML": ["not", "a", "string"],
+		"alternativeImages": "not an object"
+	}`
+	var c Article
+	err := json.Unmarshal([]byte(malformed), &c)
+	assert.NoError(t, err, "Cannot build json body")
+
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
+	actual := cu.UnrollContent(req)
+	assert.NoError(t, actual.err, "Malformed fields should not fail the whole request")
+
+	actualJSON, err := json.Marshal(actual.uc)
+	assert.NoError(t, err)
+	assert.JSONEq(t, malformed, string(actualJSON))
+	assert.ElementsMatch(t, []ExpansionProblem{
+		{Field: mainImage, Reason: reasonMissingID},
+		{Field: bodyXML, Reason: reasonInvalidType, Detail: "expected a string"},
+		{Field: altImages, Reason: reasonInvalidType, Detail: "expected an object"},
+	}, actual.report.problems())
+}
+
+func TestUnrollContent_MalformedImageSetMembersAreReported(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				var res map[string]Content
+				err := json.Unmarshal([]byte(`{
+					"639cd952-149f-11e7-2ea7-a07ecd9ac73f": {
+						"id": "http://www.ft.com/thing/639cd952-149f-11e7-2ea7-a07ecd9ac73f",
+						"members": ["not an object", {"title": "no id"}]
+					}
+				}`), &res)
+				assert.NoError(t, err, "Cannot return valid response")
+				return res, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	var c Article
+	err := json.Unmarshal([]byte(`{
+		"id": "http://www.ft.com/thing/22c0d426-1466-11e7-b0c1-37e417ee6c76",
+		"mainImage": {"id": "http://api.ft.com/content/639cd952-149f-11e7-2ea7-a07ecd9ac73f"}
+	}`), &c)
+	assert.NoError(t, err, "Cannot build json body")
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
+	actual := cu.UnrollContent(req)
+	assert.NoError(t, actual.err)
+	assert.ElementsMatch(t, []ExpansionProblem{
+		{Field: "mainImage.members[0]", Reason: reasonInvalidType, Detail: "expected an object"},
+		{Field: "mainImage.members[1]", Reason: reasonMissingID},
+	}, actual.report.problems())
+}
+
+func TestUnrollInternalContent_MalformedLeadImagesAreReported(t *testing.T) {
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				assert.Equal(t, []string{"89f194c8-13bc-11e7-80f4-13e067d5072c"}, c)
+				b, err := ioutil.ReadFile("../test-resources/reader-internalcontent-valid-response.json")
+				assert.NoError(t, err, "Cannot open file necessary for test case")
+				var res map[string]Content
+				err = json.Unmarshal(b, &res)
+				assert.NoError(t, err, "Cannot return valid response")
+				return res, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	var c Article
+	err := json.Unmarshal([]byte(`{
+		"id": "http://www.ft.com/thing/5010e2e4-09bd-11e7-97d1-5e720a26771b",
+		"leadImages": [
+			"not an object",
+			{"type": "square"},
+			{"id": "http://api.ft.com/content/89f194c8-13bc-11e7-80f4-13e067d5072c", "type": "wide"}
+		]
+	}`), &c)
+	assert.NoError(t, err, "Cannot build json body")
+	req := UnrollEvent{&c, "tid_sample", "sample_uuid", context.Background()}
+	actual := cu.UnrollInternalContent(req)
+	assert.NoError(t, actual.err)
+
+	expLeadImages := actual.uc.LeadImages
+	assert.Len(t, expLeadImages, 3)
+	leadImagesJSON, err := json.Marshal(expLeadImages[:2])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `["not an object", {"type": "square"}]`, string(leadImagesJSON))
+	assert.NotNil(t, expLeadImages[2].Image, "The valid lead image should be expanded")
+	assert.ElementsMatch(t, []ExpansionProblem{
+		{Field: "leadImages[0]", Reason: reasonInvalidType, Detail: "expected an object"},
+		{Field: "leadImages[1]", Reason: reasonMissingID},
+	}, actual.report.problems())
+}
+
+func readContentMapForTest(t *testing.T, file string) map[string]Content {
+	b, err := ioutil.ReadFile(file)
+	assert.NoError(t, err, "Cannot open file necessary for test case")
+	var res map[string]Content
+	err = json.Unmarshal(b, &res)
+	assert.NoError(t, err, "Cannot return valid response")
+	return res
+}
+
+func TestUnroll_ConcurrentUnrollsDoNotMutateSharedContent(t *testing.T) {
+	// the same models are returned to every unroll, as a reader cache would do
+	images := readContentMapForTest(t, "../test-resources/reader-content-valid-response.json")
+	for uuid, c := range readContentMapForTest(t, "../test-resources/reader-internalcontent-valid-response.json") {
+		images[uuid] = c
+	}
+	dynamicContent := readContentMapForTest(t, "../test-resources/reader-internalcontent-dynamic-valid-response.json")
+	cu := ContentUnroller{
+		reader: &ReaderMock{
+			mockGet: func(c []string, tid string) (map[string]Content, error) {
+				return images, nil
+			},
+			mockGetInternal: func(c []string, tid string) (map[string]Content, error) {
+				return dynamicContent, nil
+			},
+		},
+		apiHost: "test.api.ft.com",
+	}
+
+	tests := []struct {
+		request  string
+		expected string
+		unroll   func(UnrollEvent) UnrollResult
+	}{
+		{"../test-resources/content-valid-request.json", "../test-resources/content-valid-response.json", cu.UnrollContent},
+		{"../test-resources/internalcontent-valid-request.json", "../test-resources/internalcontent-valid-response.json", cu.UnrollInternalContent},
+	}
+
+	snapshots := make(map[string][]byte)
+	for name, models := range map[string]map[string]Content{"images": images, "dynamicContent": dynamicContent} {
+		b, err := json.Marshal(models)
+		assert.NoError(t, err)
+		snapshots[name] = b
+	}
+
+	var wg sync.WaitGroup
+	for _, test := range tests {
+		var c Article
+		fileBytes, err := ioutil.ReadFile(test.request)
+		assert.NoError(t, err, "Cannot read necessary test file")
+		err = json.Unmarshal(fileBytes, &c)
+		assert.NoError(t, err, "Cannot build json body")
+		expected, err := ioutil.ReadFile(test.expected)
+		assert.NoError(t, err, "Cannot read necessary test file")
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(unroll func(UnrollEvent) UnrollResult, c *Article, expected []byte) {
+				defer wg.Done()
+				actual := unroll(UnrollEvent{c, "tid_sample", "sample_uuid", context.Background()})
+				assert.NoError(t, actual.err)
+				actualJSON, err := json.Marshal(actual.uc)
+				assert.NoError(t, err)
+				assert.JSONEq(t, string(expected), string(actualJSON))
+			}(test.unroll, &c, expected)
+		}
+		wg.Add(1)
+		go func(c *Article, original []byte) {
+			defer wg.Done()
+			actualJSON, err := json.Marshal(c)
+			assert.NoError(t, err)
+			assert.JSONEq(t, string(original), string(actualJSON), "The supplied content should not be modified")
+		}(&c, fileBytes)
+	}
+	wg.Wait()
+
+	for name, models := range map[string]map[string]Content{"images": images, "dynamicContent": dynamicContent} {
+		b, err := json.Marshal(models)
+		assert.NoError(t, err)
+		assert.JSONEq(t, string(snapshots[name]), string(b), "The %s read should not be modified", name)
+	}
+}
+
 func TestExtractIDFromURL(t *testing.T) {
 	actual, err := extractUUIDFromString(ID)
 	assert.NoError(t, err, "Test should not return error")
 	assert.Equal(t, expectedId, actual, "Response id should be equal")
 }
+
+// BenchmarkUnrollContent decodes, unrolls and encodes the articles of the fixtures. The models read
+// are decoded for every unroll, as they are when the model cache is disabled.
+func BenchmarkUnrollContent(b *testing.B) {
+	models := func(fixture string) func([]string, string) (map[string]Content, error) {
+		body, err := ioutil.ReadFile("../test-resources/" + fixture)
+		if err != nil {
+			b.Fatalf("Cannot read fixture %s: %v", fixture, err)
+		}
+		return func([]string, string) (map[string]Content, error) {
+			var m map[string]Content
+			err := json.Unmarshal(body, &m)
+			return m, err
+		}
+	}
+
+	for _, bm := range []struct {
+		fixture  string
+		reader   *ReaderMock
+		internal bool
+	}{
+		{"content-valid-request.json", &ReaderMock{mockGet: models("reader-content-valid-response.json")}, false},
+		{"internalcontent-valid-request.json", &ReaderMock{
+			mockGet:         models("reader-internalcontent-valid-response.json"),
+			mockGetInternal: models("reader-internalcontent-dynamic-valid-response.json"),
+		}, true},
+	} {
+		body, err := ioutil.ReadFile("../test-resources/" + bm.fixture)
+		if err != nil {
+			b.Fatalf("Cannot read fixture %s: %v", bm.fixture, err)
+		}
+		cu := NewContentUnroller(bm.reader, "test.api.ft.com")
+		unroll := cu.UnrollContent
+		if bm.internal {
+			unroll = cu.UnrollInternalContent
+		}
+
+		b.Run(bm.fixture, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				var a Article
+				if err := json.Unmarshal(body, &a); err != nil {
+					b.Fatal(err)
+				}
+				res := unroll(UnrollEvent{&a, "tid_bench", "sample_uuid", context.Background()})
+				if res.err != nil {
+					b.Fatal(res.err)
+				}
+				if _, err := res.uc.MarshalJSON(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
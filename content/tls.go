@@ -0,0 +1,211 @@
+package content
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// UpstreamTLSConfig configures the TLS connections to the upstream apps: the CA bundle which
+// signs their certificates, and the client certificate sent for mutual TLS.
+type UpstreamTLSConfig struct {
+	CAFile   string `yaml:"caFile"`
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// ServerTLSConfig configures serving HTTPS. The client certificates are verified with the
+// CA bundle in ClientCAFile, if they are sent or always when they are required.
+type ServerTLSConfig struct {
+	CertFile          string `yaml:"certFile"`
+	KeyFile           string `yaml:"keyFile"`
+	ClientCAFile      string `yaml:"clientCAFile"`
+	RequireClientCert bool   `yaml:"requireClientCert"`
+}
+
+func (c ServerTLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+func (c UpstreamTLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("upstreamTLS.certFile and upstreamTLS.keyFile must be set together")
+	}
+	return nil
+}
+
+func (c ServerTLSConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("serverTLS.certFile and serverTLS.keyFile must be set together")
+	}
+	if !c.Enabled() && (c.ClientCAFile != "" || c.RequireClientCert) {
+		return errors.New("serverTLS.certFile is required to verify client certificates")
+	}
+	if c.RequireClientCert && c.ClientCAFile == "" {
+		return errors.New("serverTLS.clientCAFile is required to require client certificates")
+	}
+	return nil
+}
+
+// NewClientTLSConfig returns the TLS config of the upstream connections, or nil to use the defaults.
+// The certificate files are read again when they change.
+func NewClientTLSConfig(cfg UpstreamTLSConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" {
+		return nil, nil
+	}
+	files, err := newTLSFiles(cfg.CertFile, cfg.KeyFile, cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := files.load()
+			return cert, nil
+		}
+	}
+	if cfg.CAFile != "" {
+		// the server certificate is verified with the current CA bundle instead of a fixed one
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("No certificate received from the server")
+			}
+			_, roots := files.load()
+			opts := x509.VerifyOptions{DNSName: cs.ServerName, Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return tlsConfig, nil
+}
+
+// NewServerTLSConfig returns the TLS config of the server, reading the certificate files again when they change.
+func NewServerTLSConfig(cfg ServerTLSConfig) (*tls.Config, error) {
+	files, err := newTLSFiles(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.NoClientCert
+	if cfg.RequireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	} else if cfg.ClientCAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := files.load()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    clientCAs,
+			}, nil
+		},
+	}, nil
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{info.ModTime(), info.Size()}, nil
+}
+
+// tlsFiles keeps a certificate and a CA bundle read from files, reading them again when they change.
+// If the changed files are invalid, e.g. because they are being replaced, the previous ones are kept.
+type tlsFiles struct {
+	certFile, keyFile, caFile string
+
+	mu     sync.Mutex
+	stamps [3]fileStamp
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+}
+
+func newTLSFiles(certFile string, keyFile string, caFile string) (*tlsFiles, error) {
+	f := &tlsFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *tlsFiles) load() (*tls.Certificate, *x509.CertPool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stamps, err := f.stampFiles()
+	if err != nil || stamps == f.stamps {
+		return f.cert, f.pool
+	}
+	if err := f.reload(); err != nil {
+		// tried again once the files change again
+		f.stamps = stamps
+		logger.log.WithFields(logrus.Fields{"cert_file": f.certFile, "ca_file": f.caFile}).WithError(err).
+			Warn("TLS certificates not reloaded, keeping the previous ones")
+		return f.cert, f.pool
+	}
+	logger.log.WithFields(logrus.Fields{"cert_file": f.certFile, "ca_file": f.caFile}).Info("TLS certificates reloaded")
+	return f.cert, f.pool
+}
+
+func (f *tlsFiles) stampFiles() ([3]fileStamp, error) {
+	var stamps [3]fileStamp
+	for i, path := range []string{f.certFile, f.keyFile, f.caFile} {
+		if path == "" {
+			continue
+		}
+		stamp, err := stampFile(path)
+		if err != nil {
+			return stamps, errors.Wrapf(err, "Error reading %s", path)
+		}
+		stamps[i] = stamp
+	}
+	return stamps, nil
+}
+
+func (f *tlsFiles) reload() error {
+	stamps, err := f.stampFiles()
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	if f.certFile != "" {
+		c, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return errors.Wrapf(err, "Error loading the certificate %s", f.certFile)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if f.caFile != "" {
+		pem, err := ioutil.ReadFile(f.caFile)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading the CA bundle %s", f.caFile)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.Errorf("No certificate found in the CA bundle %s", f.caFile)
+		}
+	}
+	f.stamps, f.cert, f.pool = stamps, cert, pool
+	return nil
+}
@@ -0,0 +1,130 @@
+package content
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type certForTest struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newCertForTest returns a certificate for 127.0.0.1 signed by the CA, or a CA if there is none.
+func newCertForTest(t *testing.T, ca *certForTest, usage x509.ExtKeyUsage) *certForTest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "Cannot generate a key")
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("content-unroller-test-%d", serial)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	parent, parentKey := template, key
+	if ca == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, parentKey = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err, "Cannot create a certificate")
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err, "Cannot parse the certificate")
+	return &certForTest{cert, key, der}
+}
+
+func (c *certForTest) write(t *testing.T, certPath string, keyPath string) {
+	writeFileForTest(t, certPath, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})))
+	if keyPath != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		assert.NoError(t, err, "Cannot marshal the key")
+		writeFileForTest(t, keyPath, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	}
+}
+
+func TestTLS_MutualTLSWithReloadedCertificates(t *testing.T) {
+	dir, cleanup := tempDirForTest(t)
+	defer cleanup()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	ca := newCertForTest(t, nil, x509.ExtKeyUsageAny)
+	ca.write(t, path("ca.pem"), "")
+	newCertForTest(t, ca, x509.ExtKeyUsageServerAuth).write(t, path("server.pem"), path("server-key.pem"))
+	newCertForTest(t, ca, x509.ExtKeyUsageClientAuth).write(t, path("client.pem"), path("client-key.pem"))
+
+	serverTLS, err := NewServerTLSConfig(ServerTLSConfig{
+		CertFile:          path("server.pem"),
+		KeyFile:           path("server-key.pem"),
+		ClientCAFile:      path("ca.pem"),
+		RequireClientCert: true,
+	})
+	assert.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "Cannot start listener necessary for test")
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(tls.NewListener(l, serverTLS))
+	defer server.Close()
+	url := "https://" + l.Addr().String()
+
+	clientTLS, err := NewClientTLSConfig(UpstreamTLSConfig{CAFile: path("ca.pem"), CertFile: path("client.pem"), KeyFile: path("client-key.pem")})
+	assert.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS, DisableKeepAlives: true}}
+	resp, err := client.Get(url)
+	if assert.NoError(t, err, "The client certificate should be accepted") {
+		resp.Body.Close()
+	}
+
+	anonymousTLS, err := NewClientTLSConfig(UpstreamTLSConfig{CAFile: path("ca.pem")})
+	assert.NoError(t, err)
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: anonymousTLS, DisableKeepAlives: true}}
+	_, err = anonymous.Get(url)
+	assert.Error(t, err, "A client without a certificate should be rejected")
+
+	// the server certificate is rotated to one signed by a new CA, which the client trusts too
+	rotatedCA := newCertForTest(t, nil, x509.ExtKeyUsageAny)
+	writeFileForTest(t, path("ca.pem"), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}))+
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rotatedCA.der})))
+	newCertForTest(t, rotatedCA, x509.ExtKeyUsageServerAuth).write(t, path("server.pem"), path("server-key.pem"))
+
+	resp, err = client.Get(url)
+	if assert.NoError(t, err, "The rotated certificates should be used without a restart") {
+		resp.Body.Close()
+	}
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientTLS.GetClientCertificate(nil)
+		},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, rotatedCA.cert.Subject, conn.ConnectionState().PeerCertificates[0].Issuer)
+		conn.Close()
+	}
+}
+
+func TestServerTLSConfig_Validate(t *testing.T) {
+	assert.NoError(t, ServerTLSConfig{}.validate())
+	assert.Error(t, ServerTLSConfig{CertFile: "cert.pem"}.validate())
+	assert.Error(t, ServerTLSConfig{RequireClientCert: true}.validate())
+	assert.Error(t, ServerTLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", RequireClientCert: true}.validate())
+}
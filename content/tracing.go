@@ -0,0 +1,132 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+
+	tracerName = "github.com/Financial-Times/content-unroller/content"
+)
+
+var tracer = otel.Tracer(tracerName)
+
+type TracingConfig struct {
+	ServiceName string
+	// Exporter is one of none, otlp, stdout or file. The OTLP exporter is configured
+	// with the standard OTEL_EXPORTER_OTLP_* environment variables.
+	Exporter string
+	FilePath string
+}
+
+// InitTracing sets up W3C trace context propagation and the exporter of the spans.
+// The returned function flushes and stops the exporter.
+func InitTracing(cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	var err error
+	switch cfg.Exporter {
+	case "", TracingExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case TracingExporterOTLP:
+		exporter, err = otlptracehttp.New(context.Background())
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingExporterFile:
+		file, err = os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		return nil, errors.Errorf("Unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating the %s tracing exporter", cfg.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// startServerSpan starts the span of a request, continuing the trace of the caller if there is one.
+func startServerSpan(r *http.Request, endpoint string, tid string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, "POST /"+endpoint,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.route", "/"+endpoint),
+			attribute.String("transaction_id", tid),
+			attribute.String("client.id", clientID(r.Context())),
+		))
+}
+
+func endServerSpan(span trace.Span, sr *statusRecorder) {
+	status := sr.status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	span.SetAttributes(attribute.Int("http.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// traceExpansion adds the number of embeds expanded and of the fields which couldn't be to the request span.
+func traceExpansion(ctx context.Context, res UnrollResult) {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("content.embeds.expanded", res.expandedEmbeds()),
+		attribute.Int("content.expansion.failures", len(res.report.problems())),
+	)
+}
+
+// startClientSpan starts the span of a request to an upstream app.
+func startClientSpan(ctx context.Context, appName string, method string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, appName+" "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("peer.service", appName)))
+}
+
+// injectTraceContext adds the traceparent header of the current span to an upstream request.
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,64 @@
+package content
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const incomingTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func TestGetContent_SpansContinueIncomingTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var upstreamTraceparents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparents = append(upstreamTraceparents, r.Header.Get("traceparent"))
+		file, err := os.Open("../test-resources/source-content-valid-response.json")
+		assert.NoError(t, err, "File necessary for starting mock server not found.")
+		defer file.Close()
+		io.Copy(w, file)
+	}))
+	defer ts.Close()
+
+	h := Handler{Service: NewContentUnroller(readerForTest(ts.URL, ""), "test.api.ft.com")}
+	body, err := ioutil.ReadFile("../test-resources/content-valid-request.json")
+	assert.NoError(t, err, "Cannot read test file")
+	req, err := http.NewRequest(http.MethodPost, "/content", bytes.NewReader(body))
+	assert.NoError(t, err, "Cannot create request necessary for test")
+	req.Header.Set("traceparent", "00-"+incomingTraceID+"-00f067aa0ba902b7-01")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.GetContent).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		assert.Equal(t, incomingTraceID, s.SpanContext().TraceID().String(), "Span %s should be part of the incoming trace", s.Name())
+		spans[s.Name()] = s
+	}
+	assert.Contains(t, spans, "POST /content")
+	assert.Contains(t, spans, "createContentSchema")
+	assert.Contains(t, spans, "parseBodyXML")
+	assert.Contains(t, spans, "content-source-app-name Get")
+	assert.Equal(t, trace.SpanKindServer, spans["POST /content"].SpanKind())
+	assert.Equal(t, spans["POST /content"].SpanContext().SpanID(), spans["createContentSchema"].Parent().SpanID())
+
+	assert.NotEmpty(t, upstreamTraceparents)
+	for _, tp := range upstreamTraceparents {
+		assert.Contains(t, tp, incomingTraceID, "The trace should be propagated to the upstream app")
+	}
+}
@@ -8,15 +8,11 @@ import (
 
 const uuidRegex = "([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})"
 
-func extractUUIDFromString(url string) (string, error) {
-	re, err := regexp.Compile(uuidRegex)
-	if err != nil {
-		return "", errors.Wrap(err, "Error during extracting UUID")
-	}
+var uuidFinder = regexp.MustCompile(uuidRegex)
 
-	values := re.FindStringSubmatch(url)
-	if len(values) > 0 {
-		return values[0], nil
+func extractUUIDFromString(url string) (string, error) {
+	if uuid := uuidFinder.FindString(url); uuid != "" {
+		return uuid, nil
 	}
 	return "", errors.Errorf("Cannot extract UUID from %s", url)
 }
@@ -24,3 +20,12 @@ func extractUUIDFromString(url string) (string, error) {
 func createID(APIHost string, handlerPath string, uuid string) string {
 	return "http://" + APIHost + "/" + handlerPath + "/" + uuid
 }
+
+var uuidPattern = regexp.MustCompile("^" + uuidRegex + "$")
+
+func validateUUID(uuid string) error {
+	if !uuidPattern.MatchString(uuid) {
+		return errors.Errorf("Invalid UUID %s", uuid)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package content
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// validatedResponse is a response of an upstream app which had an ETag or a Last-Modified,
+// so that it can be requested again conditionally.
+type validatedResponse struct {
+	key          string
+	etag         string
+	lastModified string
+	body         []byte
+}
+
+// responseValidators keeps the validated responses of the upstream apps by request, evicting the least
+// recently used ones when it is full. A response which didn't change is then read from here instead
+// of being downloaded again.
+type responseValidators struct {
+	mu        sync.Mutex
+	enabled   bool
+	max       int
+	responses map[string]*list.Element
+	lru       *list.List
+}
+
+func newResponseValidators(cfg CacheConfig) *responseValidators {
+	v := &responseValidators{responses: make(map[string]*list.Element), lru: list.New()}
+	v.configure(cfg)
+	return v
+}
+
+// validatorsKey identifies a request to an upstream app, whichever of its hosts serves it.
+func validatorsKey(method string, pathAndQuery string) string {
+	return method + " " + pathAndQuery
+}
+
+// conditional adds the validators of the last response to the request, returning the response.
+func (v *responseValidators) conditional(key string, req *http.Request) (validatedResponse, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, found := v.responses[key]
+	if !v.enabled || !found {
+		return validatedResponse{}, false
+	}
+	v.lru.MoveToFront(e)
+	r := *e.Value.(*validatedResponse)
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	if r.lastModified != "" {
+		req.Header.Set("If-Modified-Since", r.lastModified)
+	}
+	return r, true
+}
+
+// store keeps the response if it has validators, or forgets the previous one.
+func (v *responseValidators) store(key string, header http.Header, body []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.enabled {
+		return
+	}
+	if e, found := v.responses[key]; found {
+		v.remove(e)
+	}
+	r := &validatedResponse{key: key, etag: header.Get("ETag"), lastModified: header.Get("Last-Modified"), body: body}
+	if r.etag == "" && r.lastModified == "" {
+		return
+	}
+	v.responses[key] = v.lru.PushFront(r)
+	for v.lru.Len() > v.max {
+		v.remove(v.lru.Back())
+	}
+}
+
+// configure applies new settings, disabling the revalidation forgets the responses.
+func (v *responseValidators) configure(cfg CacheConfig) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.enabled = cfg.Revalidate
+	v.max = cfg.MaxEntries
+	if !v.enabled {
+		v.responses = make(map[string]*list.Element)
+		v.lru.Init()
+	}
+	for v.lru.Len() > v.max {
+		v.remove(v.lru.Back())
+	}
+}
+
+func (v *responseValidators) remove(e *list.Element) {
+	v.lru.Remove(e)
+	delete(v.responses, e.Value.(*validatedResponse).key)
+}
@@ -0,0 +1,38 @@
+package content
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseValidators_ConditionalRequests(t *testing.T) {
+	v := newResponseValidators(CacheConfig{Revalidate: true, MaxEntries: 2})
+	v.store("a", http.Header{"Etag": []string{`"v1"`}}, []byte("a"))
+	v.store("b", http.Header{"Last-Modified": []string{"Sun, 18 Oct 2026 10:00:00 GMT"}}, []byte("b"))
+	v.store("c", http.Header{}, []byte("c"))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/content", nil)
+	r, found := v.conditional("a", req)
+	assert.True(t, found)
+	assert.Equal(t, []byte("a"), r.body)
+	assert.Equal(t, `"v1"`, req.Header.Get("If-None-Match"))
+
+	req, _ = http.NewRequest(http.MethodGet, "http://localhost/content", nil)
+	_, found = v.conditional("b", req)
+	assert.True(t, found)
+	assert.Equal(t, "Sun, 18 Oct 2026 10:00:00 GMT", req.Header.Get("If-Modified-Since"))
+	assert.Empty(t, req.Header.Get("If-None-Match"))
+
+	_, found = v.conditional("c", req)
+	assert.False(t, found, "A response without validators shouldn't be kept")
+
+	v.store("d", http.Header{"Etag": []string{`"v1"`}}, []byte("d"))
+	_, found = v.conditional("a", req)
+	assert.False(t, found, "The least recently used response should be evicted")
+
+	v.configure(CacheConfig{MaxEntries: 2})
+	_, found = v.conditional("d", req)
+	assert.False(t, found, "Disabling the revalidation should forget the responses")
+}
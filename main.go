@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Financial-Times/content-unroller/content"
@@ -14,6 +20,7 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,6 +28,24 @@ const (
 	AppCode = "content-unroller"
 	AppName = "Content Unroller"
 	AppDesc = "Content Unroller - unroll images and dynamic content for a given content"
+
+	flowRead    = "read"
+	flowPreview = "preview"
+	flowBoth    = "both"
+
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	// an unroll makes up to three sequential upstream calls, each limited by the 10s default upstream timeout
+	writeTimeout = 45 * time.Second
+	idleTimeout  = 120 * time.Second
+
+	defaultUpstreamTimeout    = 10 * time.Second
+	defaultHealthcheckTimeout = 10 * time.Second
+	defaultCacheTTL           = time.Minute
+	defaultCacheMaxEntries    = 10000
+
+	// the upstream apps are checked in the background, the healthchecks and the GTG serving the last results
+	defaultHealthcheckInterval = 10 * time.Second
 )
 
 func main() {
@@ -76,53 +101,235 @@ func main() {
 	flow := app.String(cli.StringOpt{
 		Name:   "flow",
 		Value:  "read",
-		Desc:   "Marks if it is 'read' or 'preview' flow (default: read)",
+		Desc:   "The flows served: 'read', 'preview', 'both' or a comma separated list of them (default: read)",
 		EnvVar: "FLOW",
 	})
+	shutdownDelay := app.String(cli.StringOpt{
+		Name:   "shutdownDelay",
+		Value:  "5s",
+		Desc:   "How long the GTG fails before the server stops accepting requests when shutting down",
+		EnvVar: "SHUTDOWN_DELAY",
+	})
+	shutdownGracePeriod := app.String(cli.StringOpt{
+		Name:   "shutdownGracePeriod",
+		Value:  "20s",
+		Desc:   "How long in-flight requests are given to complete when shutting down, before their upstream calls are cancelled",
+		EnvVar: "SHUTDOWN_GRACE_PERIOD",
+	})
+	tracingExporter := app.String(cli.StringOpt{
+		Name:   "tracingExporter",
+		Value:  content.TracingExporterNone,
+		Desc:   "Where to export traces: 'none', 'otlp' (configured with the OTEL_EXPORTER_OTLP_* variables), 'stdout' or 'file'",
+		EnvVar: "TRACING_EXPORTER",
+	})
+	tracingFile := app.String(cli.StringOpt{
+		Name:   "tracingFile",
+		Value:  "traces.json",
+		Desc:   "File the traces are written to when the 'file' exporter is used",
+		EnvVar: "TRACING_FILE",
+	})
+	adminAddress := app.String(cli.StringOpt{
+		Name:   "adminAddress",
+		Value:  "localhost:9091",
+		Desc:   "Address of the admin listener, only reachable by the operators (e.g. with a port forward), empty to disable it",
+		EnvVar: "ADMIN_ADDRESS",
+	})
+	configFile := app.String(cli.StringOpt{
+		Name:   "configFile",
+		Value:  "",
+		Desc:   "YAML config file, its settings override the options. It is reloaded when it changes",
+		EnvVar: "CONFIG_FILE",
+	})
 
 	app.Action = func() {
+		delay, err := time.ParseDuration(*shutdownDelay)
+		if err != nil {
+			log.Fatalf("Invalid shutdown delay %q: %v", *shutdownDelay, err)
+		}
+		gracePeriod, err := time.ParseDuration(*shutdownGracePeriod)
+		if err != nil {
+			log.Fatalf("Invalid shutdown grace period %q: %v", *shutdownGracePeriod, err)
+		}
+
+		shutdownTracing, err := content.InitTracing(content.TracingConfig{
+			ServiceName: AppCode,
+			Exporter:    *tracingExporter,
+			FilePath:    *tracingFile,
+		})
+		if err != nil {
+			log.Fatalf("Unable to set up tracing: %v", err)
+		}
+		defer shutdownTracing(context.Background())
+
+		defaults := content.Config{
+			APIHost:        *apiHost,
+			ContentStore:   content.UpstreamConfig{AppName: *contentStoreApplicationName, Host: *contentStoreHost},
+			ContentPreview: content.UpstreamConfig{AppName: *contentPreviewAppName, Host: *contentPreviewHost},
+			Paths:          content.PathsConfig{Content: *contentPathEndpoint, InternalContent: *internalContentPathEndpoint},
+			Timeouts:       content.TimeoutsConfig{Upstream: defaultUpstreamTimeout, Healthcheck: defaultHealthcheckTimeout},
+			Cache:          content.CacheConfig{TTL: defaultCacheTTL, MaxEntries: defaultCacheMaxEntries},
+			Healthchecks:   content.HealthchecksConfig{Interval: defaultHealthcheckInterval, Probe: content.HealthProbeHealth},
+		}
+		cfg := defaults
+		if *configFile != "" {
+			cfg, err = content.LoadConfig(*configFile, defaults)
+			if err != nil {
+				log.Fatalf("Unable to load the config file: %v", err)
+			}
+		}
+
+		clientTLS, err := content.NewClientTLSConfig(cfg.UpstreamTLS)
+		if err != nil {
+			log.Fatalf("Unable to set up TLS for the upstream apps: %v", err)
+		}
+		// the upstream requests are limited by the timeouts in the config, which can be reloaded
 		httpClient := &http.Client{
-			Timeout: 10 * time.Second,
 			Transport: &http.Transport{
 				MaxIdleConnsPerHost: 100,
 				DialContext: (&net.Dialer{
 					KeepAlive: 30 * time.Second,
 				}).DialContext,
+				TLSClientConfig: clientTLS,
 			},
 		}
 
+		probePath := cfg.Healthchecks.Path()
 		sc := content.ServiceConfig{
-			ContentStoreAppName:        *contentStoreApplicationName,
-			ContentStoreAppHealthURI:   getServiceHealthURI(*contentStoreHost),
-			ContentPreviewAppName:      *contentPreviewAppName,
-			ContentPreviewAppHealthURI: getServiceHealthURI(*contentPreviewHost),
-			HTTPClient:                 httpClient,
+			ContentStoreAppName:         cfg.ContentStore.AppName,
+			ContentStoreAppHealthURI:    getServiceHealthURI(cfg.ContentStore.Host, probePath),
+			ContentPreviewAppName:       cfg.ContentPreview.AppName,
+			ContentPreviewAppHealthURI:  getServiceHealthURI(cfg.ContentPreview.Host, probePath),
+			ContentStoreAppHealthURIs:   getServiceHealthURIs(cfg.ContentStore.AllHosts(), probePath),
+			ContentPreviewAppHealthURIs: getServiceHealthURIs(cfg.ContentPreview.AllHosts(), probePath),
+			ContentStoreAuth:            cfg.ContentStore.Auth,
+			ContentPreviewAuth:          cfg.ContentPreview.Auth,
+			HTTPClient:                  httpClient,
+			HealthcheckTimeout:          cfg.Timeouts.Healthcheck,
+			HealthcheckSlowThreshold:    cfg.Healthchecks.SlowThreshold,
 		}
 
-		readerConfig := content.ReaderConfig{
-			ContentStoreAppName:         *contentStoreApplicationName,
-			ContentStoreHost:            *contentStoreHost,
-			ContentPreviewAppName:       *contentPreviewAppName,
-			ContentPreviewHost:          *contentPreviewHost,
-			ContentPathEndpoint:         *contentPathEndpoint,
-			InternalContentPathEndpoint: *internalContentPathEndpoint,
+		reader := content.NewContentReader(cfg.ReaderConfig(), httpClient)
+		unroller := content.NewContentUnroller(reader, cfg.APIHost)
+		unroller.UpdateConfig(cfg.APIHost, cfg.Expansion)
+		limits := content.NewUnrollLimits(cfg.Limits)
+		inboundAuth := content.NewInboundAuth(cfg.InboundAuth, limits)
+		rateLimiter := content.NewRateLimiter(cfg.RateLimits)
+		loadShedder := content.NewLoadShedder(cfg.LoadShedding)
+
+		flows := parseFlows(*flow)
+		log.Infof("Serving the %s flows.", strings.Join(flows, ", "))
+
+		// the self-test unrolls the article like the read flow, or like the preview flow when only it is served
+		unroll := unroller.UnrollContent
+		if !servesFlow(flows, flowRead) {
+			unroll = unroller.UnrollContentPreview
 		}
+		selfTest := content.NewSelfTest(unroll, cfg.SelfTest, limits)
+		outputs := content.NewOutputCache(cfg.OutputCache)
+		admin := content.NewAdmin(cfg, reader, outputs)
+		invalidator := content.NewInvalidator(cfg.Invalidation, reader, outputs, httpClient)
+		compressor := content.NewCompressor(cfg.Compression, limits)
 
-		reader := content.NewContentReader(readerConfig, httpClient)
-		unroller := content.NewContentUnroller(reader, *apiHost)
+		if *configFile != "" {
+			watcher := content.NewConfigWatcher(*configFile, defaults, cfg, func(c content.Config) {
+				reader.UpdateConfig(c.ReaderConfig())
+				unroller.UpdateConfig(c.APIHost, c.Expansion)
+				inboundAuth.UpdateConfig(c.InboundAuth)
+				rateLimiter.UpdateConfig(c.RateLimits)
+				loadShedder.UpdateConfig(c.LoadShedding)
+				selfTest.UpdateConfig(c.SelfTest)
+				admin.UpdateConfig(c)
+				invalidator.UpdateConfig(c.Invalidation)
+				outputs.UpdateConfig(c.OutputCache)
+				compressor.UpdateConfig(c.Compression)
+				limits.UpdateConfig(c.Limits)
+				probePath := c.Healthchecks.Path()
+				sc.UpdateUpstreams(getServiceHealthURIs(c.ContentStore.AllHosts(), probePath), getServiceHealthURIs(c.ContentPreview.AllHosts(), probePath),
+					c.ContentStore.Auth, c.ContentPreview.Auth, c.Timeouts.Healthcheck, c.Healthchecks.SlowThreshold)
+			})
+			ctx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+			go func() {
+				if err := watcher.Watch(ctx); err != nil {
+					log.Errorf("Config file changes won't be applied: %v", err)
+				}
+			}()
+		}
 
-		switch *flow {
-		case "read", "preview":
-			log.Infof("Value of 'flow' set to '%s'.", *flow)
-		default:
-			log.Warnf("Value of 'flow' should be one of: 'read' or 'preview', defaulting to 'read'.")
+		if cfg.Healthchecks.Interval > 0 {
+			ctx, stopPolling := context.WithCancel(context.Background())
+			defer stopPolling()
+			go sc.PollUpstreams(ctx, cfg.Healthchecks.Interval, servesFlow(flows, flowPreview))
+		}
+		// the background revalidations of the cached models are cancelled once the server has shut down
+		ctx, stopRevalidating := context.WithCancel(context.Background())
+		defer stopRevalidating()
+		reader.SetBackgroundContext(ctx)
+
+		// the notifications feed is only polled while it is configured
+		ctx, stopPollingNotifications := context.WithCancel(context.Background())
+		defer stopPollingNotifications()
+		go invalidator.Poll(ctx)
+
+		if cfg.SelfTest.Interval > 0 {
+			ctx, stopSelfTests := context.WithCancel(context.Background())
+			defer stopSelfTests()
+			go selfTest.Poll(ctx, cfg.SelfTest.Interval)
 		}
 
-		h := setupServiceHandler(unroller, sc, *flow)
-		err := http.ListenAndServe(":"+*port, h)
+		h := setupServiceHandler(serviceDependencies{
+			Unroller:    unroller,
+			Outputs:     outputs,
+			Limits:      limits,
+			Service:     &sc,
+			Compressor:  compressor,
+			InboundAuth: inboundAuth,
+			RateLimiter: rateLimiter,
+			LoadShedder: loadShedder,
+			Invalidator: invalidator,
+			SelfTest:    selfTest,
+			Flows:       flows,
+		})
+		server := &http.Server{
+			Handler:           h,
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       readTimeout,
+			WriteTimeout:      writeTimeout,
+			IdleTimeout:       idleTimeout,
+		}
+		l, err := net.Listen("tcp", ":"+*port)
 		if err != nil {
 			log.Fatalf("Unable to start server: %v", err)
 		}
+		if cfg.ServerTLS.Enabled() {
+			serverTLS, err := content.NewServerTLSConfig(cfg.ServerTLS)
+			if err != nil {
+				log.Fatalf("Unable to set up TLS for the server: %v", err)
+			}
+			l = tls.NewListener(l, serverTLS)
+			log.Info("Serving HTTPS")
+		}
+
+		if *adminAddress != "" {
+			al, err := net.Listen("tcp", *adminAddress)
+			if err != nil {
+				log.Fatalf("Unable to start the admin server: %v", err)
+			}
+			adminServer := &http.Server{Handler: setupAdminHandler(admin, invalidator, rateLimiter, loadShedder), ReadHeaderTimeout: readHeaderTimeout}
+			defer adminServer.Close()
+			go func() {
+				if err := adminServer.Serve(al); err != http.ErrServerClosed {
+					log.Errorf("Admin server stopped with error: %v", err)
+				}
+			}()
+			log.Infof("Serving the admin endpoints on %s", al.Addr())
+		}
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		if err := runServer(server, l, &sc, stop, delay, gracePeriod); err != nil {
+			log.Errorf("Server stopped with error: %v", err)
+		}
 	}
 
 	log.SetLevel(log.InfoLevel)
@@ -130,25 +337,114 @@ func main() {
 	app.Run(os.Args)
 }
 
-func setupServiceHandler(s content.Unroller, sc content.ServiceConfig, flow string) *mux.Router {
+// runServer serves requests until a signal is received on stop. It then fails the GTG, waits for
+// the delay and drains the in-flight requests, cancelling their upstream calls if the grace period expires.
+func runServer(server *http.Server, l net.Listener, sc *content.ServiceConfig, stop <-chan os.Signal, delay time.Duration, gracePeriod time.Duration) error {
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server.BaseContext = func(net.Listener) context.Context { return requestsCtx }
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Serve(l)
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case sig := <-stop:
+		log.Infof("Received %v, shutting down", sig)
+	}
+
+	sc.StartShutdown()
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warnf("In-flight requests not completed within %v, cancelling them: %v", gracePeriod, err)
+		cancelRequests()
+		return server.Close()
+	}
+	log.Info("All in-flight requests completed")
+	return nil
+}
+
+// parseFlows returns the flows to serve from the value of the flow option, defaulting to read.
+func parseFlows(flow string) []string {
+	var read, preview bool
+	for _, f := range strings.Split(flow, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case flowRead:
+			read = true
+		case flowPreview:
+			preview = true
+		case flowBoth:
+			read, preview = true, true
+		default:
+			log.Warnf("Unknown flow '%s', it should be one of: 'read', 'preview' or 'both'.", f)
+		}
+	}
+
+	var flows []string
+	if read || !preview {
+		flows = append(flows, flowRead)
+	}
+	if preview {
+		flows = append(flows, flowPreview)
+	}
+	return flows
+}
+
+func servesFlow(flows []string, flow string) bool {
+	for _, f := range flows {
+		if f == flow {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceDependencies are what the service listener serves its endpoints with.
+type serviceDependencies struct {
+	Unroller content.Unroller
+	Outputs  *content.OutputCache
+	Limits   *content.UnrollLimits
+	// Service has the healthchecks of the upstream apps
+	Service     *content.ServiceConfig
+	Compressor  *content.Compressor
+	InboundAuth *content.InboundAuth
+	RateLimiter *content.RateLimiter
+	LoadShedder *content.LoadShedder
+	Invalidator *content.Invalidator
+	SelfTest    *content.SelfTest
+	// Flows are the flows served, read and/or preview
+	Flows []string
+}
+
+func setupServiceHandler(d serviceDependencies) *mux.Router {
 	r := mux.NewRouter()
-	ch := &content.Handler{Service: s}
-	// Splitting the read and preview flow: endpoints and healthchecks assigned accordingly
-	var checks []fthealth.Check
-	var gtgHandler func(http.ResponseWriter, *http.Request)
-
-	if flow == "preview" {
-		r.HandleFunc("/content-preview", ch.GetContentPreview).Methods("POST")
-		r.HandleFunc("/internalcontent-preview", ch.GetInternalContentPreview).Methods("POST")
-		checks = []fthealth.Check{sc.ContentStoreCheck(), sc.ContentPreviewCheck()}
-		gtgHandler = httphandlers.NewGoodToGoHandler(gtg.StatusChecker(sc.GtgCheckPreview))
-	} else {
-		// the default value for flow is "read"
-		r.HandleFunc("/content", ch.GetContent).Methods("POST")
-		r.HandleFunc("/internalcontent", ch.GetInternalContent).Methods("POST")
-		checks = []fthealth.Check{sc.ContentStoreCheck()}
-		gtgHandler = httphandlers.NewGoodToGoHandler(gtg.StatusChecker(sc.GtgCheck))
+	ch := &content.Handler{Service: d.Unroller, Outputs: d.Outputs, Limits: d.Limits}
+	// Each flow registers its endpoints, and adds its healthchecks and GTG check
+	checks := []fthealth.Check{d.Service.ContentStoreCheck()}
+	var gtgChecks []gtg.StatusChecker
+
+	for _, flow := range d.Flows {
+		switch flow {
+		case flowPreview:
+			r.HandleFunc("/content-preview", ch.GetContentPreview).Methods("POST")
+			r.HandleFunc("/internalcontent-preview", ch.GetInternalContentPreview).Methods("POST")
+			checks = append(checks, d.Service.ContentPreviewCheck())
+			gtgChecks = append(gtgChecks, d.Service.GtgCheckPreview)
+		case flowRead:
+			r.HandleFunc("/content", ch.GetContent).Methods("POST")
+			r.HandleFunc("/internalcontent", ch.GetInternalContent).Methods("POST")
+			gtgChecks = append(gtgChecks, d.Service.GtgCheck)
+		}
 	}
+	checks = append(checks, d.SelfTest.Check())
+	gtgHandler := httphandlers.NewGoodToGoHandler(gtg.FailFastParallelCheck(gtgChecks))
 
 	r.Path(httphandlers.BuildInfoPath).HandlerFunc(httphandlers.BuildInfoHandler)
 	r.Path(httphandlers.PingPath).HandlerFunc(httphandlers.PingHandler)
@@ -160,9 +456,47 @@ func setupServiceHandler(s content.Unroller, sc content.ServiceConfig, flow stri
 
 	r.Path("/__health").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(fthealth.Handler(&hc))})
 	r.Path("/__gtg").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(gtgHandler)})
+	r.Path("/metrics").Handler(handlers.MethodHandler{"GET": promhttp.Handler()})
+	r.Path("/__self-test").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(d.SelfTest.Handler)})
+	// the publish pipeline pushes its notifications here, with the credentials of inboundAuth
+	r.Path("/notifications").Handler(handlers.MethodHandler{"POST": http.HandlerFunc(d.Invalidator.Handler)})
+	r.Use(content.RecoveryHandler)
+	r.Use(d.Compressor.Handler)
+	r.Use(d.InboundAuth.Handler)
+	r.Use(d.RateLimiter.Handler)
+	r.Use(d.LoadShedder.Handler)
 	return r
 }
 
-func getServiceHealthURI(hostname string) string {
-	return fmt.Sprintf("%s%s", hostname, "/__health")
+// setupAdminHandler serves the endpoints of the admin listener: the effective config, the model cache and
+// its invalidation, the state of the upstream apps, rate limits and load shedding, and the pprof profiles.
+func setupAdminHandler(admin *content.Admin, invalidator *content.Invalidator, limiter *content.RateLimiter, shedder *content.LoadShedder) *mux.Router {
+	r := mux.NewRouter()
+	r.Path("/config").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(admin.ConfigHandler)})
+	r.Path("/cache").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(admin.CacheStatsHandler)})
+	r.Path("/cache/{uuid}").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(admin.CacheEntryHandler), "DELETE": http.HandlerFunc(admin.CacheEntryHandler)})
+	r.Path("/notifications").Handler(handlers.MethodHandler{"POST": http.HandlerFunc(invalidator.Handler)})
+	r.Path("/upstreams").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(admin.UpstreamsHandler)})
+	r.Path("/rate-limits").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(limiter.StateHandler)})
+	r.Path("/load-shedding").Handler(handlers.MethodHandler{"GET": http.HandlerFunc(shedder.StateHandler)})
+
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// the index serves the other profiles, e.g. heap and goroutine
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	return r
+}
+
+func getServiceHealthURI(hostname string, probePath string) string {
+	return fmt.Sprintf("%s%s", hostname, probePath)
+}
+
+func getServiceHealthURIs(hostnames []string, probePath string) []string {
+	var uris []string
+	for _, h := range hostnames {
+		uris = append(uris, getServiceHealthURI(h, probePath))
+	}
+	return uris
 }
@@ -0,0 +1,76 @@
+// +build both
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var flow = "both"
+
+func TestBothFlows_ShouldServeReadAndPreviewEndpoints(t *testing.T) {
+	contentStoreServiceMock := startContentServerMock("test-resources/source-internalcontent-valid-lead-images-reasponse.json", false)
+	contentPreviewServiceMock := startContentServerMock("test-resources/source-internalcontentpreview-valid-response.json", true)
+	startUnrollerService(contentStoreServiceMock.URL, contentPreviewServiceMock.URL, flow)
+
+	defer contentStoreServiceMock.Close()
+	defer contentPreviewServiceMock.Close()
+	defer unrollerService.Close()
+
+	body, err := ioutil.ReadFile("test-resources/internalcontent-valid-request.json")
+	assert.NoError(t, err, "Cannot read file necessary for test case")
+
+	for _, endpoint := range []string{"/content", "/internalcontent", "/content-preview", "/internalcontent-preview"} {
+		resp, err := http.Post(unrollerService.URL+endpoint, "application/json", bytes.NewReader(body))
+		assert.NoError(t, err, "Cannot send request to %s endpoint", endpoint)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status of %s should be 200", endpoint)
+	}
+}
+
+func TestBothFlows_ShouldBeGoodToGo(t *testing.T) {
+	contentStoreServiceMock := startContentServerMock("test-resources/source-content-valid-response.json", false)
+	contentPreviewServiceMock := startContentServerMock("test-resources/source-contentpreview-valid-response.json", true)
+	startUnrollerService(contentStoreServiceMock.URL, contentPreviewServiceMock.URL, flow)
+
+	defer contentStoreServiceMock.Close()
+	defer contentPreviewServiceMock.Close()
+	defer unrollerService.Close()
+
+	resp, err := http.Get(unrollerService.URL + "/__gtg")
+	assert.NoError(t, err, "Cannot send request to gtg endpoint")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+}
+
+func TestBothFlows_ShouldNotBeGoodToGoWhenContentPreviewIsNotHappy(t *testing.T) {
+	contentStoreServiceMock := startContentServerMock("test-resources/source-content-valid-response.json", false)
+	contentPreviewServiceMock := startUnhealthyContentServerMock()
+	startUnrollerService(contentStoreServiceMock.URL, contentPreviewServiceMock.URL, flow)
+
+	defer contentStoreServiceMock.Close()
+	defer contentPreviewServiceMock.Close()
+	defer unrollerService.Close()
+
+	resp, err := http.Get(unrollerService.URL + "/__gtg")
+	assert.NoError(t, err, "Cannot send request to gtg endpoint")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "Response status should be 503")
+}
+
+func TestBothFlows_ShouldNotBeGoodToGoWhenContentStoreIsNotHappy(t *testing.T) {
+	contentStoreServiceMock := startUnhealthyContentServerMock()
+	contentPreviewServiceMock := startContentServerMock("test-resources/source-contentpreview-valid-response.json", true)
+	startUnrollerService(contentStoreServiceMock.URL, contentPreviewServiceMock.URL, flow)
+
+	defer contentStoreServiceMock.Close()
+	defer contentPreviewServiceMock.Close()
+	defer unrollerService.Close()
+
+	resp, err := http.Get(unrollerService.URL + "/__gtg")
+	assert.NoError(t, err, "Cannot send request to gtg endpoint")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "Response status should be 503")
+}
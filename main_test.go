@@ -2,19 +2,25 @@ package main
 
 import (
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"syscall"
+	"testing"
+	"time"
 
 	"github.com/Financial-Times/content-unroller/content"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
 	contentStoreAppName           = "content-source-app-name"
 	contentPreviewAppName         = "content-preview-app-name"
 	internalContentPreviewAppName = "internal-content-preview-app-name"
+	shuttingDownMessage           = "The service is shutting down"
 )
 
 var (
@@ -63,9 +69,9 @@ func statusServiceUnavailableHandler(w http.ResponseWriter, r *http.Request) {
 func startUnrollerService(contentStoreURL string, contentPreviewURL string, flow string) {
 	sc := content.ServiceConfig{
 		ContentStoreAppName:        contentStoreAppName,
-		ContentStoreAppHealthURI:   getServiceHealthURI(contentStoreURL),
+		ContentStoreAppHealthURI:   getServiceHealthURI(contentStoreURL, "/__health"),
 		ContentPreviewAppName:      contentPreviewAppName,
-		ContentPreviewAppHealthURI: getServiceHealthURI(contentPreviewURL),
+		ContentPreviewAppHealthURI: getServiceHealthURI(contentPreviewURL, "/__health"),
 		HTTPClient:                 http.DefaultClient,
 	}
 
@@ -81,6 +87,127 @@ func startUnrollerService(contentStoreURL string, contentPreviewURL string, flow
 	reader := content.NewContentReader(rc, http.DefaultClient)
 	unroller := content.NewContentUnroller(reader, "test.api.ft.com")
 
-	h := setupServiceHandler(unroller, sc, flow)
+	limits := content.NewUnrollLimits(content.LimitsConfig{})
+	h := setupServiceHandler(serviceDependencies{
+		Unroller:    unroller,
+		Outputs:     content.NewOutputCache(content.OutputCacheConfig{}),
+		Limits:      limits,
+		Service:     &sc,
+		Compressor:  content.NewCompressor(content.CompressionConfig{}, limits),
+		InboundAuth: content.NewInboundAuth(content.InboundAuthConfig{}, limits),
+		RateLimiter: content.NewRateLimiter(content.RateLimitConfig{}),
+		LoadShedder: content.NewLoadShedder(content.LoadSheddingConfig{}),
+		Invalidator: content.NewInvalidator(content.InvalidationConfig{}, reader, nil, http.DefaultClient),
+		SelfTest:    content.NewSelfTest(unroller.UnrollContent, content.SelfTestConfig{}, limits),
+		Flows:       parseFlows(flow),
+	})
 	unrollerService = httptest.NewServer(h)
 }
+
+func startServerForTest(t *testing.T, h http.Handler, sc *content.ServiceConfig, stop chan os.Signal, gracePeriod time.Duration) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "Cannot start listener necessary for test")
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(&http.Server{Handler: h}, l, sc, stop, 0, gracePeriod)
+	}()
+	return "http://" + l.Addr().String(), done
+}
+
+func TestRunServer_DrainsInFlightRequestsOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+	sc := &content.ServiceConfig{HTTPClient: http.DefaultClient}
+	stop := make(chan os.Signal, 1)
+	url, done := startServerForTest(t, h, sc, stop, 5*time.Second)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			status <- resp.StatusCode
+		}
+	}()
+	<-started
+
+	stop <- syscall.SIGTERM
+	for i := 0; i < 100 && sc.GtgCheck().Message != shuttingDownMessage; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, shuttingDownMessage, sc.GtgCheck().Message, "The GTG should fail while in-flight requests are drained")
+	close(release)
+
+	assert.Equal(t, http.StatusOK, <-status)
+	assert.NoError(t, <-done)
+}
+
+func TestRunServer_CancelsInFlightRequestsAfterGracePeriod(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	})
+	stop := make(chan os.Signal, 1)
+	url, done := startServerForTest(t, h, &content.ServiceConfig{}, stop, 50*time.Millisecond)
+
+	go func() {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	stop <- syscall.SIGTERM
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "The in-flight request should be cancelled when the grace period expires")
+	}
+	<-done
+}
+
+func TestParseFlows(t *testing.T) {
+	tests := map[string][]string{
+		"read":            {flowRead},
+		"preview":         {flowPreview},
+		"both":            {flowRead, flowPreview},
+		"preview, read":   {flowRead, flowPreview},
+		"":                {flowRead},
+		"unknown":         {flowRead},
+		"unknown,preview": {flowPreview},
+	}
+	for flow, expected := range tests {
+		assert.Equal(t, expected, parseFlows(flow), "Flows for '%s'", flow)
+	}
+}
+
+func TestSetupAdminHandler(t *testing.T) {
+	cfg := content.Config{Cache: content.CacheConfig{Enabled: true, TTL: time.Minute, MaxEntries: 10}}
+	reader := content.NewContentReader(cfg.ReaderConfig(), http.DefaultClient)
+	h := setupAdminHandler(content.NewAdmin(cfg, reader, nil), content.NewInvalidator(cfg.Invalidation, reader, nil, http.DefaultClient), content.NewRateLimiter(content.RateLimitConfig{}), content.NewLoadShedder(content.LoadSheddingConfig{}))
+	uncached := "22c0d426-1466-11e7-b0c1-37e417ee6c76"
+
+	for path, expectedStatus := range map[string]int{
+		"/config":                http.StatusOK,
+		"/cache":                 http.StatusOK,
+		"/cache/" + uncached:     http.StatusNotFound,
+		"/upstreams":             http.StatusOK,
+		"/rate-limits":           http.StatusOK,
+		"/load-shedding":         http.StatusOK,
+		"/debug/pprof/":          http.StatusOK,
+		"/debug/pprof/goroutine": http.StatusOK,
+		"/__health":              http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, expectedStatus, w.Code, path)
+	}
+}